@@ -1,66 +1,134 @@
 package blockchain
 
 import (
-	"blockchain/crypto/sha3"
-	"blockchain/trie"
+	"blockchain/blockchain/eip1559"
+	"blockchain/kvstore"
+	"blockchain/rawdb"
+	"blockchain/statdb"
 	"blockchain/txpool"
 	"blockchain/types"
+	"blockchain/utils/event"
 	"blockchain/utils/hash"
-	"blockchain/utils/rlp"
+	"sync"
 )
 
-type Header struct {
-	Root       hash.Hash
-	ParentHash hash.Hash
-	Height     uint64
-	Coinbase   types.Address
-	Timestamp  uint64
-	Nonce      uint64
-	//TODO: Add difficulty
-}
-
-type Body struct {
-	Transactions []types.Transaction
-	Receiptions  []types.Receiption
-}
+// Header 和 Body 放在 types 里，rawdb 也需要用到它们
+type Header = types.Header
 
-func (header Header) Hash() hash.Hash {
-	data, _ := rlp.EncodeToBytes(header)
-	return sha3.Keccak256(data)
-}
+type Body = types.Body
 
+// NewHeader 创建 parent 的子区块头，gas limit 是 BlockGasLimit，base fee 按父区块的 gas 使用量调整
 func NewHeader(parent Header) *Header {
-	return &Header{
-		Root:       parent.Root,
-		ParentHash: parent.Hash(),
-		Height:     parent.Height + 1,
-	}
+	header := types.NewHeader(parent)
+	header.GasLimit = BlockGasLimit
+	header.BaseFee = eip1559.CalcBaseFee(&parent)
+	return header
 }
 
 func NewBlockBody() *Body {
-	return &Body{
-		Transactions: make([]types.Transaction, 0),
-		Receiptions:  make([]types.Receiption, 0),
-	}
+	return types.NewBlockBody()
 }
 
 type Blockchain struct {
 	CurrentHeader Header
-	Statedb       *trie.State
-	Txpool        *txpool.DefaultPool
+	Statedb       statdb.StatDB
+	Txpool        txpool.TxPool
+
+	db            kvstore.KVDatabase
+	chainHeadFeed event.Feed[types.ChainHeadEvent]
+
+	mu sync.RWMutex //保护 CurrentHeader 和 Statedb，出块写状态时拿写锁，只读调用拿读锁
 }
 
-func NewBlockchain(statedb *trie.State, txpool *txpool.DefaultPool) *Blockchain {
-	return &Blockchain{
-		CurrentHeader: Header{
-			Root:       statedb.Root(),
-			ParentHash: hash.Hash{},
-			Height:     0,
-			Coinbase:   types.Address{},
-			Timestamp:  0,
-			Nonce:      0,
-		},
+// NewBlockchain 从数据库里读取最新的区块；数据库里还没有区块时，用 statedb 当前的状态作为创世区块写入
+func NewBlockchain(db kvstore.KVDatabase, statedb statdb.StatDB, txpool txpool.TxPool) (*Blockchain, error) {
+	bc := &Blockchain{
 		Statedb: statedb,
 		Txpool:  txpool,
+		db:      db,
+	}
+	if head := rawdb.ReadHeadHeader(db); head != nil {
+		bc.CurrentHeader = *head
+		return bc, nil
+	}
+	genesis := &Header{
+		Root: statedb.Root(),
+	}
+	if err := bc.InsertBlock(genesis, NewBlockBody(), statedb); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// CurrentBlock 返回最新的区块头的副本
+func (bc *Blockchain) CurrentBlock() *Header {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	header := bc.CurrentHeader
+	return &header
+}
+
+// StateRoot 把 state 的修改写进链上的状态并返回状态根。
+// 出块时 state 包着 Statedb，所以要拿写锁，不能和只读调用同时进行。
+// Root 不返回错误，先 Finalise，写状态失败时返回错误
+func (bc *Blockchain) StateRoot(state statdb.JournaledStatDB) (hash.Hash, error) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if err := state.Finalise(); err != nil {
+		return hash.Hash{}, err
 	}
+	return state.Root(), nil
+}
+
+// InsertBlock 把状态的修改、区块、收据和索引放在一个 batch 里写入数据库，然后把它设为最新区块。
+// 写入失败时状态回到当前最新区块的状态
+func (bc *Blockchain) InsertBlock(header *Header, body *Body, state statdb.StatDB) error {
+	bc.mu.Lock()
+	err := bc.writeBlock(header, body, state)
+	if err != nil {
+		//状态里已经有这个区块的修改，不能在没有写进数据库的状态上继续出块
+		bc.Statedb.SetStatRoot(bc.CurrentHeader.Root)
+	}
+	bc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	bc.chainHeadFeed.Send(types.ChainHeadEvent{Header: header, Body: body})
+	return nil
+}
+
+// writeBlock 是 InsertBlock 写数据库的部分，调用方要拿着写锁
+func (bc *Blockchain) writeBlock(header *Header, body *Body, state statdb.StatDB) error {
+	batch := bc.db.NewBatch()
+	if _, err := state.CommitBatch(batch); err != nil {
+		return err
+	}
+	if err := rawdb.WriteBlock(batch, header, body); err != nil {
+		return err
+	}
+	if err := rawdb.WriteHeadBlockHash(batch, header.Hash()); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	bc.CurrentHeader = *header
+	return nil
+}
+
+// SubscribeChainHeadEvent 订阅新区块，交易池用它在出块之后清理交易
+func (bc *Blockchain) SubscribeChainHeadEvent(ch chan<- types.ChainHeadEvent) event.Subscription {
+	return bc.chainHeadFeed.Subscribe(ch)
+}
+
+// GetHeaderByNumber 读取主链上某个高度的区块头
+func (bc *Blockchain) GetHeaderByNumber(number uint64) *Header {
+	h := rawdb.ReadCanonicalHash(bc.db, number)
+	return rawdb.ReadHeader(bc.db, h, number)
+}
+
+// GetBody 读取主链上某个高度的区块体
+func (bc *Blockchain) GetBody(number uint64) *Body {
+	h := rawdb.ReadCanonicalHash(bc.db, number)
+	return rawdb.ReadBody(bc.db, h, number)
 }
@@ -0,0 +1,81 @@
+package blockchain
+
+import (
+	"blockchain/kvstore"
+	"blockchain/trie"
+	"blockchain/txpool"
+	"blockchain/types"
+	"errors"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+var errWriteFailed = errors.New("write failed")
+
+// failingDB 的 batch 在 fail 为 true 时写入失败
+type failingDB struct {
+	kvstore.KVDatabase
+	fail bool
+}
+
+type failingBatch struct {
+	kvstore.Batch
+	db *failingDB
+}
+
+func (db *failingDB) NewBatch() kvstore.Batch {
+	return &failingBatch{Batch: db.KVDatabase.NewBatch(), db: db}
+}
+
+func (batch *failingBatch) Write() error {
+	if batch.db.fail {
+		return errWriteFailed
+	}
+	return batch.Batch.Write()
+}
+
+// 区块写入失败时状态回到最新区块，下一个区块不会包含失败区块的修改
+func TestInsertBlockWriteFailed(t *testing.T) {
+	db := &failingDB{KVDatabase: kvstore.NewMemoryDB()}
+	state := trie.NewState(db, trie.EmptyHash)
+	bc, err := NewBlockchain(db, state, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := bc.CurrentHeader
+
+	lost, kept := types.Address{0x01}, types.Address{0x02}
+	state.Store(lost, types.Account{Amount: *uint256.NewInt(1)})
+	header := NewHeader(bc.CurrentHeader)
+	header.Root = state.Root()
+	db.fail = true
+	if err := bc.InsertBlock(header, NewBlockBody(), state); !errors.Is(err, errWriteFailed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bc.CurrentHeader.Hash() != genesis.Hash() || state.Root() != genesis.Root {
+		t.Fatal("chain or state not reset after failed write")
+	}
+
+	db.fail = false
+	state.Store(kept, types.Account{Amount: *uint256.NewInt(2)})
+	header = NewHeader(bc.CurrentHeader)
+	header.Root = state.Root()
+	if err := bc.InsertBlock(header, NewBlockBody(), state); err != nil {
+		t.Fatal(err)
+	}
+	reopened := trie.NewState(db, header.Root)
+	if _, err := reopened.Load(lost); err == nil {
+		t.Fatal("state of the failed block was committed")
+	}
+	if account, err := reopened.Load(kept); err != nil || account.Amount.Uint64() != 2 {
+		t.Fatalf("account not persisted: %+v %v", account, err)
+	}
+}
+
+// 交易池的 gas 上限要和区块的 gas limit 一致
+func TestTxPoolGasLimit(t *testing.T) {
+	if txpool.DefaultConfig.GasLimit != BlockGasLimit {
+		t.Fatalf("txpool gas limit %d, block gas limit %d", txpool.DefaultConfig.GasLimit, BlockGasLimit)
+	}
+}
@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"blockchain/statdb"
+	"blockchain/statemachine"
+	"blockchain/types"
+	"blockchain/utils/hexutil"
+	"blockchain/vm"
+	"errors"
+	"fmt"
+
+	"github.com/holiman/uint256"
+)
+
+// StateAt 返回 header 这个区块之后的状态的副本，对它的修改不会影响链上的状态
+func (bc *Blockchain) StateAt(header *Header) (statdb.JournaledStatDB, error) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	state := bc.Statedb.Copy()
+	if err := state.SetStatRoot(header.Root); err != nil {
+		return nil, err
+	}
+	return statdb.NewJournal(state), nil
+}
+
+// Call 在 header 这个区块之后的状态副本上执行 tx，返回执行结果，修改都被丢弃。
+// 不检查 nonce 和签名；gas 为 0 时用区块的 gas limit；
+// gas price 为 0 的调用不付 base fee，发送方不需要有余额付gas费
+func (bc *Blockchain) Call(tx *types.Transaction, header *Header) (*statemachine.ExecutionResult, error) {
+	state, err := bc.StateAt(header)
+	if err != nil {
+		return nil, err
+	}
+	msg, context := *tx, *header
+	if msg.Gas == 0 {
+		msg.Gas = gasCap(header)
+	}
+	if msg.GasFeeCap() == 0 {
+		context.BaseFee = 0
+	}
+	return statemachine.NewStateMachine().Call(state, &context, &msg)
+}
+
+// EstimateGas 在最新区块的状态上用二分法找出 tx 执行成功需要的最小 gas limit。
+// tx 的 gas 不为 0 时最多找到它为止，否则最多到区块的 gas limit；
+// 有 gas price 的时候还不能超过发送方的余额付得起的gas
+func (bc *Blockchain) EstimateGas(tx *types.Transaction) (uint64, error) {
+	header := bc.CurrentBlock()
+	hi := gasCap(header)
+	if tx.Gas != 0 && tx.Gas < hi {
+		hi = tx.Gas
+	}
+	if feeCap := tx.GasFeeCap(); feeCap > 0 {
+		state, err := bc.StateAt(header)
+		if err != nil {
+			return 0, err
+		}
+		account, _ := state.Load(tx.From())
+		if account.Amount.Lt(tx.Value()) {
+			return 0, statemachine.ErrInsufficientFunds
+		}
+		allowance := new(uint256.Int).Sub(&account.Amount, tx.Value())
+		allowance.Div(allowance, uint256.NewInt(feeCap))
+		if allowance.IsUint64() && allowance.Uint64() < hi {
+			hi = allowance.Uint64()
+		}
+	}
+	lo := statemachine.IntrinsicGas(tx) - 1
+	if hi <= lo {
+		return 0, statemachine.ErrIntrinsicGas
+	}
+
+	execute := func(gas uint64) (*statemachine.ExecutionResult, error) {
+		msg := *tx
+		msg.Gas = gas
+		return bc.Call(&msg, header)
+	}
+	//最大的 gas limit 都不能成功时不用再找
+	result, err := execute(hi)
+	if err != nil {
+		return 0, err
+	}
+	if result.Failed() {
+		return 0, executionError(result, hi)
+	}
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		result, err := execute(mid)
+		if err != nil {
+			return 0, err
+		}
+		if result.Failed() {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
+
+// gasCap 是只读调用最多使用的gas，创世区块没有 gas limit，用 BlockGasLimit
+func gasCap(header *Header) uint64 {
+	if header.GasLimit == 0 {
+		return BlockGasLimit
+	}
+	return header.GasLimit
+}
+
+// executionError 说明给了 gas 这么多gas仍然执行失败的原因，REVERT 时带上 revert 的数据
+func executionError(result *statemachine.ExecutionResult, gas uint64) error {
+	switch {
+	case errors.Is(result.Err, vm.ErrExecutionReverted) && len(result.ReturnData) > 0:
+		return fmt.Errorf("%w: %s", vm.ErrExecutionReverted, hexutil.Encode(result.ReturnData))
+	case errors.Is(result.Err, vm.ErrOutOfGas), errors.Is(result.Err, vm.ErrCodeStoreOutOfGas):
+		return fmt.Errorf("gas required exceeds allowance (%d)", gas)
+	default:
+		return result.Err
+	}
+}
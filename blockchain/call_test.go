@@ -0,0 +1,193 @@
+package blockchain
+
+import (
+	"blockchain/blockchain/eip1559"
+	"blockchain/kvstore"
+	"blockchain/statdb"
+	"blockchain/trie"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/hexutil"
+	"blockchain/vm"
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+var (
+	callSender   = types.Address{0x01}
+	callContract = types.Address{0xcc}
+)
+
+// newCallTestChain 创建一条有两个区块的链：创世区块里只有发送方，区块 1 部署了合约。
+// 合约把 calldata 的第一个字存进 slot 0 并返回它
+func newCallTestChain(t *testing.T) *Blockchain {
+	return newCallTestChainWithState(t, kvstore.NewMemoryDB(), statdb.NewMemoryStatDB())
+}
+
+func newCallTestChainWithState(t *testing.T, db kvstore.KVDatabase, state statdb.StatDB) *Blockchain {
+	state.Store(callSender, types.Account{Amount: *uint256.NewInt(1000000000000000000)})
+	bc, err := NewBlockchain(db, state, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.SetCode(callContract, hexutil.MustDecode("0x6000358060005560005260206000f3"))
+	header := NewHeader(bc.CurrentHeader)
+	header.Root = state.Root()
+	if err := bc.InsertBlock(header, NewBlockBody(), state); err != nil {
+		t.Fatal(err)
+	}
+	return bc
+}
+
+func TestCall(t *testing.T) {
+	bc := newCallTestChain(t)
+	input := hash.BytesToHash([]byte{7})
+	tx := types.NewTransaction(0, callContract, callSender, nil, 0, 0, input[:])
+
+	result, err := bc.Call(tx, bc.CurrentBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed() || !bytes.Equal(result.ReturnData, input[:]) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	// 固有gas 21000+31*4+16，SSTORE 20000，其余指令 27
+	if want := uint64(21140 + 20000 + 27); result.UsedGas != want {
+		t.Fatalf("gas used mismatch: have %d, want %d", result.UsedGas, want)
+	}
+	//链上的状态没有变
+	if v, _ := bc.Statedb.GetState(callContract, hash.Hash{}); v != (hash.Hash{}) {
+		t.Fatalf("call modified the chain state: %x", v)
+	}
+	if account, _ := bc.Statedb.Load(callSender); account.Nonce != 0 {
+		t.Fatalf("call modified the sender nonce: %d", account.Nonce)
+	}
+
+	//创世区块的时候还没有合约，只是一笔转账
+	result, err = bc.Call(tx, bc.GetHeaderByNumber(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed() || len(result.ReturnData) != 0 {
+		t.Fatalf("unexpected result at genesis: %+v", result)
+	}
+}
+
+// 估算的 gas 刚好够用，少一点就会失败
+func TestEstimateGas(t *testing.T) {
+	bc := newCallTestChain(t)
+	input := hash.BytesToHash([]byte{7})
+	tx := types.NewTransaction(0, callContract, callSender, nil, 0, eip1559.InitialBaseFee, input[:])
+
+	gas, err := bc.EstimateGas(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gas != 21140+20000+27 {
+		t.Fatalf("estimate mismatch: have %d, want %d", gas, 21140+20000+27)
+	}
+	for _, limit := range []uint64{gas - 1, gas} {
+		msg := *tx
+		msg.Gas = limit
+		result, err := bc.Call(&msg, bc.CurrentBlock())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Failed() != (limit < gas) {
+			t.Fatalf("gas %d: unexpected result %+v", limit, result)
+		}
+	}
+
+	//转账只需要固有gas
+	if gas, err := bc.EstimateGas(types.NewTransaction(0, types.Address{0x42}, callSender, uint256.NewInt(1), 0, eip1559.InitialBaseFee, nil)); err != nil || gas != 21000 {
+		t.Fatalf("transfer estimate: have %d %v, want 21000", gas, err)
+	}
+	//tx 的 gas 不够时报错
+	if _, err := bc.EstimateGas(types.NewTransaction(0, callContract, callSender, nil, 30000, eip1559.InitialBaseFee, input[:])); err == nil {
+		t.Fatal("estimate succeeded with a too low gas limit")
+	}
+}
+
+// 合约 REVERT 时返回 revert 的数据
+func TestEstimateGasRevert(t *testing.T) {
+	bc := newCallTestChain(t)
+	//MSTORE(0, 0x2a)，REVERT(31, 1)
+	bc.Statedb.SetCode(callContract, hexutil.MustDecode("0x602a6000526001601ffd"))
+	header := NewHeader(bc.CurrentHeader)
+	header.Root = bc.Statedb.Root()
+	if err := bc.InsertBlock(header, NewBlockBody(), bc.Statedb); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := types.NewTransaction(0, callContract, callSender, nil, 0, 0, nil)
+	result, err := bc.Call(tx, bc.CurrentBlock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(result.Err, vm.ErrExecutionReverted) || !bytes.Equal(result.ReturnData, []byte{0x2a}) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	_, err = bc.EstimateGas(tx)
+	if !errors.Is(err, vm.ErrExecutionReverted) || err.Error() != "execution reverted: 0x2a" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// 出块的同时执行只读调用，用 go test -race 检查没有数据竞争；节点用的 trie 状态本身不加锁，靠 Blockchain 的锁保护
+func TestCallWhileInsertingBlocks(t *testing.T) {
+	db := kvstore.NewMemoryDB()
+	bc := newCallTestChainWithState(t, db, trie.NewState(db, trie.EmptyHash))
+	input := hash.BytesToHash([]byte{7})
+	tx := types.NewTransaction(0, callContract, callSender, nil, 0, eip1559.InitialBaseFee, input[:])
+
+	done := make(chan error)
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			//和出块一样，修改先记在 journal 里，算状态根和提交的时候才写进链上的状态
+			state := statdb.NewJournal(bc.Statedb)
+			state.Store(types.Address{0x42}, types.Account{Amount: *uint256.NewInt(uint64(i + 1))})
+			header := NewHeader(*bc.CurrentBlock())
+			root, err := bc.StateRoot(state)
+			if err != nil {
+				done <- err
+				return
+			}
+			header.Root = root
+			if err := bc.InsertBlock(header, NewBlockBody(), state); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		default:
+		}
+		if result, err := bc.Call(tx, bc.CurrentBlock()); err != nil || result.Failed() {
+			t.Fatalf("call failed: %v %+v", err, result)
+		}
+		if _, err := bc.EstimateGas(tx); err != nil {
+			t.Fatal(err)
+		}
+		//查询账户状态和 GET_ACCOUNT_STATUS latest 一样通过加锁的 StateAt 读
+		state, err := bc.StateAt(bc.CurrentBlock())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account, err := state.Load(callSender); err != nil || account.Amount.IsZero() {
+			t.Fatalf("sender not found: %+v %v", account, err)
+		}
+	}
+	if height := bc.CurrentBlock().Height; height != 21 {
+		t.Fatalf("height mismatch: have %d, want 21", height)
+	}
+}
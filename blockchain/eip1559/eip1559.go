@@ -0,0 +1,55 @@
+// Package eip1559 计算区块的 base fee。
+// 单独放一个包，交易池和区块链都要用，交易池不能引用 blockchain 包。
+package eip1559
+
+import (
+	"blockchain/types"
+	"blockchain/utils/math"
+	gomath "math"
+
+	"github.com/holiman/uint256"
+)
+
+const (
+	ElasticityMultiplier     = 2          //区块的目标 gas 是 gas limit 的一半
+	BaseFeeChangeDenominator = 8          //每个区块 base fee 最多变化 1/8
+	InitialBaseFee           = 1000000000 //没有 gas limit 的区块（创世区块和旧的区块）之后的第一个区块的 base fee，1 gwei
+)
+
+// CalcBaseFee 按父区块的 gas 使用量计算子区块的 base fee：
+// 用得比目标多就上涨（至少涨 1），比目标少就下降，正好等于目标时不变。
+// 上涨到超过 uint64 时停在 uint64 的最大值
+func CalcBaseFee(parent *types.Header) uint64 {
+	if parent.GasLimit == 0 {
+		return InitialBaseFee
+	}
+	target := parent.GasLimit / ElasticityMultiplier
+	switch {
+	case parent.GasUsed == target:
+		return parent.BaseFee
+	case parent.GasUsed > target:
+		delta := baseFeeDelta(parent.BaseFee, parent.GasUsed-target, target)
+		if delta < 1 {
+			delta = 1
+		}
+		baseFee, overflow := math.SafeAdd(parent.BaseFee, delta)
+		if overflow {
+			return gomath.MaxUint64
+		}
+		return baseFee
+	default:
+		return parent.BaseFee - baseFeeDelta(parent.BaseFee, target-parent.GasUsed, target)
+	}
+}
+
+// baseFeeDelta 计算 baseFee * gasDelta / target / BaseFeeChangeDenominator，
+// base fee 很高时乘法会超过 64 位，所以用 256 位计算，结果超过 uint64 时取最大值
+func baseFeeDelta(baseFee, gasDelta, target uint64) uint64 {
+	delta := new(uint256.Int).Mul(uint256.NewInt(baseFee), uint256.NewInt(gasDelta))
+	delta.Div(delta, uint256.NewInt(target))
+	delta.Div(delta, uint256.NewInt(BaseFeeChangeDenominator))
+	if !delta.IsUint64() {
+		return gomath.MaxUint64
+	}
+	return delta.Uint64()
+}
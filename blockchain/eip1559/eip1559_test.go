@@ -0,0 +1,32 @@
+package eip1559
+
+import (
+	"blockchain/types"
+	"math"
+	"testing"
+)
+
+func TestCalcBaseFee(t *testing.T) {
+	tests := []struct {
+		parent types.Header
+		want   uint64
+	}{
+		{types.Header{}, InitialBaseFee}, //创世区块
+		{types.Header{GasLimit: 1000000, GasUsed: 500000, BaseFee: 800}, 800},
+		{types.Header{GasLimit: 1000000, GasUsed: 1000000, BaseFee: 800}, 900},
+		{types.Header{GasLimit: 1000000, GasUsed: 750000, BaseFee: 800}, 850},
+		{types.Header{GasLimit: 1000000, GasUsed: 0, BaseFee: 800}, 700},
+		{types.Header{GasLimit: 1000000, GasUsed: 250000, BaseFee: 800}, 750},
+		{types.Header{GasLimit: 1000000, GasUsed: 500001, BaseFee: 0}, 1}, //至少涨 1
+		{types.Header{GasLimit: 1000000, GasUsed: 0, BaseFee: 0}, 0},
+		// base fee * gas 超过 64 位
+		{types.Header{GasLimit: 1000000, GasUsed: 1000000, BaseFee: 1 << 60}, 1<<60 + 1<<57},
+		{types.Header{GasLimit: 1000000, GasUsed: 1000000, BaseFee: math.MaxUint64 - 1000}, math.MaxUint64},
+		{types.Header{GasLimit: 1000000, GasUsed: 0, BaseFee: math.MaxUint64}, math.MaxUint64 - math.MaxUint64/8},
+	}
+	for i, test := range tests {
+		if have := CalcBaseFee(&test.parent); have != test.want {
+			t.Errorf("test %d: have %d, want %d", i, have, test.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package blockchain
+
+import (
+	"blockchain/statemachine"
+	"blockchain/utils/rlp"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// exportedBlock 是导出文件里的一个区块，文件就是按高度排列的一串 RLP 编码的区块
+type exportedBlock struct {
+	Header Header
+	Body   Body
+}
+
+// Export 把主链上 [first, last] 的区块按高度顺序写进 w
+func (bc *Blockchain) Export(w io.Writer, first, last uint64) error {
+	if head := bc.CurrentBlock().Height; last > head {
+		last = head
+	}
+	for number := first; number <= last; number++ {
+		header := bc.GetHeaderByNumber(number)
+		body := bc.GetBody(number)
+		if header == nil || body == nil {
+			return fmt.Errorf("block %d not found", number)
+		}
+		if err := rlp.Encode(w, &exportedBlock{Header: *header, Body: *body}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import 读取 Export 写出的区块，逐个完整验证之后写入。
+// 已经在主链上的区块会被跳过，所以可以在中断之后从当前的最新区块继续导入。
+// 返回新导入的区块数。
+func (bc *Blockchain) Import(r io.Reader, exec statemachine.IMachine) (int, error) {
+	stream := rlp.NewStream(r, 0)
+	imported := 0
+	for {
+		var block exportedBlock
+		if err := stream.Decode(&block); errors.Is(err, io.EOF) {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("decode block %d: %w", bc.CurrentBlock().Height+1, err)
+		}
+		header, body := &block.Header, &block.Body
+		if header.Height <= bc.CurrentBlock().Height {
+			//已经有这个区块了，但必须是同一条链
+			if known := bc.GetHeaderByNumber(header.Height); known == nil || known.Hash() != header.Hash() {
+				return imported, fmt.Errorf("block %d %s conflicts with the local chain", header.Height, header.Hash())
+			}
+			continue
+		}
+		if err := bc.ImportBlock(header, body, exec); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+}
@@ -0,0 +1,151 @@
+package blockchain
+
+import (
+	"blockchain/blockchain/eip1559"
+	"blockchain/statdb"
+	"blockchain/statemachine"
+	"blockchain/types"
+	"blockchain/utils/math"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/holiman/uint256"
+)
+
+const (
+	BlockReward   = 50      //出块奖励，另外矿工还会得到区块里所有交易的小费
+	Difficulty    = 2       //区块hash需要的前导0的个数
+	BlockGasLimit = 1000000 //一个区块里所有交易最多消耗的gas
+)
+
+var (
+	ErrUnknownParent = errors.New("unknown parent")
+	ErrInvalidPoW    = errors.New("invalid proof of work")
+	ErrInvalidReward = errors.New("invalid block reward")
+	ErrInvalidGas    = errors.New("invalid gas limit or gas used")
+	ErrInvalidBase   = errors.New("invalid base fee")
+	ErrFeeOverflow   = errors.New("block fees overflow")
+)
+
+// NewRewardTx 是每个区块最后一笔交易，记录矿工得到的奖励，不经过状态机执行
+func NewRewardTx(minter types.Address) *types.Transaction {
+	return types.NewTransaction(0, types.Address{}, minter, uint256.NewInt(BlockReward), 0, 0, nil)
+}
+
+// ApplyReward 把出块奖励和交易的小费加到矿工的账户上，base fee 已经被销毁，不给矿工。
+// 矿工的余额溢出时返回 ErrFeeOverflow，不修改状态
+func ApplyReward(state statdb.StatDB, minter types.Address, fees *uint256.Int) error {
+	account, err := state.Load(minter)
+	if err != nil {
+		account = types.Account{}
+	}
+	reward, overflow := math.SafeAddU256(uint256.NewInt(BlockReward), fees)
+	if overflow {
+		return ErrFeeOverflow
+	}
+	balance, overflow := math.SafeAddU256(&account.Amount, reward)
+	if overflow {
+		return ErrFeeOverflow
+	}
+	account.Amount = *balance
+	return state.Store(minter, account)
+}
+
+// CheckPoW 检查区块hash是否满足难度
+func CheckPoW(header *Header, difficulty uint64) bool {
+	return header.Hash().String()[2:difficulty+2] == strings.Repeat("0", int(difficulty))
+}
+
+// ImportBlock 完整地验证一个别的节点产生的区块：父区块、工作量证明、重新执行所有交易并核对收据和状态根，
+// 验证通过之后写入数据库并设为最新区块。验证失败时状态回到当前区块。
+func (bc *Blockchain) ImportBlock(header *Header, body *Body, exec statemachine.IMachine) error {
+	bc.mu.Lock()
+	err := bc.importBlock(header, body, exec)
+	bc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	bc.chainHeadFeed.Send(types.ChainHeadEvent{Header: header, Body: body})
+	return nil
+}
+
+// importBlock 验证区块并写入数据库，调用方要拿着写锁
+func (bc *Blockchain) importBlock(header *Header, body *Body, exec statemachine.IMachine) error {
+	parent := bc.CurrentHeader
+	if header.Height != parent.Height+1 || header.ParentHash != parent.Hash() {
+		return fmt.Errorf("%w: block %d has parent %s, head is %d %s", ErrUnknownParent,
+			header.Height, header.ParentHash, parent.Height, parent.Hash())
+	}
+	if !CheckPoW(header, Difficulty) {
+		return ErrInvalidPoW
+	}
+	if header.GasLimit != BlockGasLimit {
+		return fmt.Errorf("%w: gas limit %d, want %d", ErrInvalidGas, header.GasLimit, BlockGasLimit)
+	}
+	if want := eip1559.CalcBaseFee(&parent); header.BaseFee != want {
+		return fmt.Errorf("%w: have %d, want %d", ErrInvalidBase, header.BaseFee, want)
+	}
+	if err := bc.Statedb.SetStatRoot(parent.Root); err != nil {
+		return err
+	}
+	state := statdb.NewJournal(bc.Statedb)
+	if err := bc.processBlock(state, header, body, exec); err != nil {
+		state.SetStatRoot(parent.Root)
+		return fmt.Errorf("block %d: %w", header.Height, err)
+	}
+	if err := bc.writeBlock(header, body, state); err != nil {
+		//和 InsertBlock 一样，没有写进数据库的修改不能留在状态里
+		bc.Statedb.SetStatRoot(parent.Root)
+		return err
+	}
+	return nil
+}
+
+func (bc *Blockchain) processBlock(state statdb.JournaledStatDB, header *Header, body *Body, exec statemachine.IMachine) error {
+	txs, receipts := body.Transactions, body.Receiptions
+	if len(txs) == 0 || len(txs) != len(receipts) {
+		return fmt.Errorf("%d transactions with %d receipts", len(txs), len(receipts))
+	}
+	var (
+		fees     = new(uint256.Int)
+		gasUsed  uint64
+		overflow bool
+	)
+	for i := range txs[:len(txs)-1] {
+		receipt, fee := exec.Execute(state, header, &txs[i])
+		if receipt == nil {
+			return fmt.Errorf("invalid transaction %s", txs[i].Hash())
+		}
+		if receipt.Hash() != receipts[i].Hash() {
+			return fmt.Errorf("receipt mismatch for %s: have %+v, want %+v", txs[i].Hash(), receipts[i], *receipt)
+		}
+		if gasUsed, overflow = math.SafeAdd(gasUsed, receipt.GasUsed); overflow {
+			return fmt.Errorf("%w: gas used overflow", ErrInvalidGas)
+		}
+		if fees, overflow = math.SafeAddU256(fees, fee); overflow {
+			return ErrFeeOverflow
+		}
+	}
+	if gasUsed > header.GasLimit || gasUsed != header.GasUsed {
+		return fmt.Errorf("%w: gas used %d, header has %d, gas limit %d", ErrInvalidGas, gasUsed, header.GasUsed, header.GasLimit)
+	}
+	reward := txs[len(txs)-1]
+	if reward.Hash() != NewRewardTx(reward.From()).Hash() {
+		return ErrInvalidReward
+	}
+	if receipt := receipts[len(receipts)-1]; receipt.Hash() != (types.Receiption{TxHash: reward.Hash()}).Hash() {
+		return ErrInvalidReward
+	}
+	if err := ApplyReward(state, reward.From(), fees); err != nil {
+		return err
+	}
+	//Root 不返回错误，先 Finalise，免得拿只写了一部分的状态去比较
+	if err := state.Finalise(); err != nil {
+		return err
+	}
+	if root := state.Root(); root != header.Root {
+		return fmt.Errorf("state root mismatch: have %s, want %s", header.Root, root)
+	}
+	return nil
+}
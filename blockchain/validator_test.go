@@ -0,0 +1,35 @@
+package blockchain
+
+import (
+	"blockchain/statdb"
+	"blockchain/types"
+	"blockchain/utils/math"
+	"errors"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestApplyReward(t *testing.T) {
+	minter := types.Address{0x01}
+	state := statdb.NewMemoryStatDB()
+	if err := ApplyReward(state, minter, uint256.NewInt(7)); err != nil {
+		t.Fatal(err)
+	}
+	if account, _ := state.Load(minter); account.Amount.Uint64() != BlockReward+7 {
+		t.Fatalf("balance mismatch: have %d, want %d", account.Amount.Uint64(), BlockReward+7)
+	}
+}
+
+// 矿工的余额溢出时不能回绕
+func TestApplyRewardOverflow(t *testing.T) {
+	minter := types.Address{0x01}
+	state := statdb.NewMemoryStatDB()
+	if err := ApplyReward(state, minter, math.MaxUint256); !errors.Is(err, ErrFeeOverflow) {
+		t.Fatalf("have %v, want %v", err, ErrFeeOverflow)
+	}
+	state.Store(minter, types.Account{Amount: *new(uint256.Int).Sub(math.MaxUint256, uint256.NewInt(BlockReward-1))})
+	if err := ApplyReward(state, minter, new(uint256.Int)); !errors.Is(err, ErrFeeOverflow) {
+		t.Fatalf("have %v, want %v", err, ErrFeeOverflow)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"blockchain/types"
+	"blockchain/utils/hexutil"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// CallResponse 是 CALL 的结果。执行失败时 Error 是原因，REVERT 的数据仍然在 Result 里
+type CallResponse struct {
+	Result  string `json:"result,omitempty"` //合约的返回值，0x 开头的十六进制
+	GasUsed uint64 `json:"gasUsed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// EstimateGasResponse 是 ESTIMATE_GAS 的结果
+type EstimateGasResponse struct {
+	Gas   uint64 `json:"gas,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleCallRequest 处理 CALL <交易的 JSON> [latest|earliest|<区块高度>]，
+// 在那个区块之后的状态上执行交易，不修改链上的状态，也不进交易池
+func (n *node) handleCallRequest(conn net.Conn, request string) {
+	txData, tag, err := parseCallRequest(request)
+	if err != nil {
+		writeResponse(conn, CallResponse{Error: err.Error()})
+		return
+	}
+	header, err := n.headerByTag(tag)
+	if err != nil {
+		writeResponse(conn, CallResponse{Error: err.Error()})
+		return
+	}
+	tx, err := txData.transaction()
+	if err != nil {
+		writeResponse(conn, CallResponse{Error: err.Error()})
+		return
+	}
+	result, err := n.blockchain.Call(tx, header)
+	if err != nil {
+		writeResponse(conn, CallResponse{Error: err.Error()})
+		return
+	}
+	response := CallResponse{GasUsed: result.UsedGas}
+	if len(result.ReturnData) > 0 {
+		response.Result = hexutil.Encode(result.ReturnData)
+	}
+	if result.Failed() {
+		response.Error = result.Err.Error()
+	}
+	writeResponse(conn, response)
+}
+
+// handleEstimateGasRequest 处理 ESTIMATE_GAS <交易的 JSON>，返回交易在最新区块上执行成功需要的最小 gas limit
+func (n *node) handleEstimateGasRequest(conn net.Conn, request string) {
+	txData, tag, err := parseCallRequest(request)
+	if err == nil && tag != "" {
+		err = fmt.Errorf("unexpected argument %q", tag)
+	}
+	if err != nil {
+		writeResponse(conn, EstimateGasResponse{Error: err.Error()})
+		return
+	}
+	tx, err := txData.transaction()
+	if err != nil {
+		writeResponse(conn, EstimateGasResponse{Error: err.Error()})
+		return
+	}
+	gas, err := n.blockchain.EstimateGas(tx)
+	if err != nil {
+		writeResponse(conn, EstimateGasResponse{Error: err.Error()})
+		return
+	}
+	writeResponse(conn, EstimateGasResponse{Gas: gas})
+}
+
+// parseCallRequest 解析开头的交易 JSON，返回它后面的参数
+func parseCallRequest(request string) (*TransactionData, string, error) {
+	var txData TransactionData
+	dec := json.NewDecoder(strings.NewReader(request))
+	if err := dec.Decode(&txData); err != nil {
+		return nil, "", err
+	}
+	return &txData, strings.TrimSpace(request[dec.InputOffset():]), nil
+}
+
+// headerByTag 找到区块标签对应的区块头，空的标签就是 latest
+func (n *node) headerByTag(tag string) (*types.Header, error) {
+	switch tag {
+	case "", "latest":
+		header := *n.blockchain.CurrentBlock()
+		return &header, nil
+	case "earliest":
+		tag = "0"
+	}
+	number, err := strconv.ParseUint(tag, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unknown block tag %q", tag)
+	}
+	header := n.blockchain.GetHeaderByNumber(number)
+	if header == nil {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	return header, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/hexutil"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestCallAndEstimateGas(t *testing.T) {
+	var (
+		sender = testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+		n      = newTestNode(t)
+	)
+	//部署一个把 calldata 的第一个字存进 slot 0 并返回它的合约
+	deploy := types.NewContractCreation(1, sender, nil, 100000, testGasPrice,
+		append(hexutil.MustDecode("0x600f600c600039600f6000f3"), hexutil.MustDecode("0x6000358060005560005260206000f3")...))
+	if err := n.blockchain.Txpool.NewTx(deploy); err != nil {
+		t.Fatal(err)
+	}
+	n.createBlock()
+	contract := types.CreateAddress(sender, 1)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go n.handleConnection(server)
+	reader := bufio.NewReader(client)
+	query := func(request string, response interface{}) {
+		if _, err := fmt.Fprintln(client, request); err != nil {
+			t.Fatal(err)
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(line, response); err != nil {
+			t.Fatalf("%s: %v", request, err)
+		}
+	}
+	input := "0x000000000000000000000000000000000000000000000000000000000000002a"
+	request := fmt.Sprintf(`{"from": "0x9B682e9770C315f43954e37D8880a6Be815A3E53", "to": "0x%x", "input": "%s"}`, contract, input)
+
+	for _, tag := range []string{"", " latest", " 1"} {
+		var call CallResponse
+		query("CALL "+request+tag, &call)
+		if call.Error != "" || call.Result != input || call.GasUsed != 21000+31*4+16+20000+27 {
+			t.Fatalf("call%s: %+v", tag, call)
+		}
+	}
+	//创世区块的时候还没有合约
+	var call CallResponse
+	query("CALL "+request+" earliest", &call)
+	if call.Error != "" || call.Result != "" {
+		t.Fatalf("call at genesis: %+v", call)
+	}
+	query("CALL "+request+" pending", &call)
+	if call.Error == "" {
+		t.Fatal("unknown tag accepted")
+	}
+	query("CALL "+request+" 9", &call)
+	if call.Error == "" {
+		t.Fatal("missing block accepted")
+	}
+	//调用不修改链上的状态
+	if v, _ := n.blockchain.Statedb.GetState(contract, hash.Hash{}); v != (hash.Hash{}) {
+		t.Fatalf("call modified the chain state: %x", v)
+	}
+
+	var estimate EstimateGasResponse
+	query("ESTIMATE_GAS "+request, &estimate)
+	if estimate.Error != "" || estimate.Gas != 21000+31*4+16+20000+27 {
+		t.Fatalf("estimate mismatch: %+v", estimate)
+	}
+	query("ESTIMATE_GAS "+request+" latest", &estimate)
+	if estimate.Error == "" {
+		t.Fatal("estimate accepted a block tag")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"blockchain/statemachine"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// exportChain 把 [first, last] 的区块导出到文件，文件名以 .gz 结尾时用 gzip 压缩
+func exportChain(n *node, file string, first, last uint64) error {
+	fh, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var w io.Writer = fh
+	if strings.HasSuffix(file, ".gz") {
+		gz := gzip.NewWriter(fh)
+		defer gz.Close()
+		w = gz
+	}
+	if err := n.blockchain.Export(w, first, last); err != nil {
+		return err
+	}
+	if gz, ok := w.(*gzip.Writer); ok {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	return fh.Close()
+}
+
+// importChain 从文件导入区块，每个区块都会重新执行和验证
+func importChain(n *node, file string) (int, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+
+	var r io.Reader = fh
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(fh)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return n.blockchain.Import(r, statemachine.NewStateMachine())
+}
+
+// runChainCommand 处理 export 和 import 子命令
+func runChainCommand(n *node, args []string) error {
+	switch args[0] {
+	case "export":
+		if len(args) < 2 || len(args) > 4 {
+			return fmt.Errorf("usage: export <file> [first [last]]")
+		}
+		first, last := uint64(0), n.blockchain.CurrentBlock().Height
+		if len(args) > 2 {
+			if _, err := fmt.Sscan(args[2], &first); err != nil {
+				return fmt.Errorf("invalid first block: %v", err)
+			}
+		}
+		if len(args) > 3 {
+			if _, err := fmt.Sscan(args[3], &last); err != nil {
+				return fmt.Errorf("invalid last block: %v", err)
+			}
+		}
+		if err := exportChain(n, args[1], first, last); err != nil {
+			return err
+		}
+		fmt.Printf("Exported blocks %d-%d to %s\n", first, min(last, n.blockchain.CurrentBlock().Height), args[1])
+	case "import":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: import <file>")
+		}
+		imported, err := importChain(n, args[1])
+		fmt.Printf("Imported %d blocks, head is now %d\n", imported, n.blockchain.CurrentBlock().Height)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"blockchain/blockchain"
+	"blockchain/statemachine"
+	"blockchain/types"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func newTestNode(t *testing.T) *node {
+	n, err := initNode(testNodeConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// 导出的链导入到一个新节点之后，两个节点的状态完全一样；再导入一次不会重复导入
+func TestExportImport(t *testing.T) {
+	var (
+		sender    = testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+		recipient = types.Address{0x42}
+		src       = newTestNode(t)
+	)
+	for i := uint64(1); i <= 2; i++ {
+		src.blockchain.Txpool.NewTx(types.NewTransaction(i, recipient, sender, uint256.NewInt(10*i), 21000, testGasPrice, nil))
+		src.createBlock()
+	}
+	if height := src.blockchain.CurrentHeader.Height; height != 2 {
+		t.Fatalf("source height mismatch: have %d, want 2", height)
+	}
+
+	for _, name := range []string{"chain.rlp", "chain.rlp.gz"} {
+		file := filepath.Join(t.TempDir(), name)
+		if err := exportChain(src, file, 0, src.blockchain.CurrentHeader.Height); err != nil {
+			t.Fatal(err)
+		}
+		dst := newTestNode(t)
+		imported, err := importChain(dst, file)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if imported != 2 {
+			t.Fatalf("%s: imported %d blocks, want 2", name, imported)
+		}
+		if have, want := dst.blockchain.CurrentHeader.Hash(), src.blockchain.CurrentHeader.Hash(); have != want {
+			t.Fatalf("%s: head mismatch: have %s, want %s", name, have, want)
+		}
+		account, _ := dst.blockchain.Statedb.Load(recipient)
+		if account.Amount.Uint64() != 30 {
+			t.Fatalf("%s: recipient balance mismatch: have %d, want 30", name, account.Amount.Uint64())
+		}
+		if imported, err := importChain(dst, file); err != nil || imported != 0 {
+			t.Fatalf("%s: reimport: imported %d, err %v", name, imported, err)
+		}
+	}
+}
+
+// 收据被篡改的区块不能导入
+func TestImportRejectsInvalidBlock(t *testing.T) {
+	var (
+		sender = testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+		src    = newTestNode(t)
+		dst    = newTestNode(t)
+	)
+	src.blockchain.Txpool.NewTx(types.NewTransaction(1, types.Address{0x42}, sender, uint256.NewInt(10), 21000, testGasPrice, nil))
+	src.createBlock()
+
+	header := src.blockchain.GetHeaderByNumber(1)
+	body := src.blockchain.GetBody(1)
+	body.Receiptions[0].GasUsed++
+	if err := dst.blockchain.ImportBlock(header, body, statemachine.NewStateMachine()); err == nil {
+		t.Fatal("imported a block with a forged receipt")
+	}
+	if dst.blockchain.CurrentHeader.Height != 0 {
+		t.Fatal("head moved after a failed import")
+	}
+}
+
+// 区块头的 gas 和 base fee 必须和父区块、交易一致
+func TestImportRejectsInvalidGasFields(t *testing.T) {
+	var (
+		sender = testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+		src    = newTestNode(t)
+		dst    = newTestNode(t)
+	)
+	src.blockchain.Txpool.NewTx(types.NewTransaction(1, types.Address{0x42}, sender, uint256.NewInt(10), 21000, testGasPrice, nil))
+	src.createBlock()
+
+	header := src.blockchain.GetHeaderByNumber(1)
+	if header.GasUsed != 21000 || header.GasLimit != blockchain.BlockGasLimit {
+		t.Fatalf("gas fields mismatch: used %d limit %d", header.GasUsed, header.GasLimit)
+	}
+	tests := []struct {
+		modify func(h *types.Header)
+		err    error
+	}{
+		{func(h *types.Header) { h.BaseFee++ }, blockchain.ErrInvalidBase},
+		{func(h *types.Header) { h.GasUsed++ }, blockchain.ErrInvalidGas},
+		{func(h *types.Header) { h.GasLimit-- }, blockchain.ErrInvalidGas},
+	}
+	for i, test := range tests {
+		forged := *header
+		test.modify(&forged)
+		for forged.Nonce = 0; !blockchain.CheckPoW(&forged, blockchain.Difficulty); forged.Nonce++ {
+		}
+		err := dst.blockchain.ImportBlock(&forged, src.blockchain.GetBody(1), statemachine.NewStateMachine())
+		if !errors.Is(err, test.err) {
+			t.Fatalf("test %d: have %v, want %v", i, err, test.err)
+		}
+	}
+}
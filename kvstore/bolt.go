@@ -0,0 +1,235 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// 所有数据都放在一个 bucket 里
+var boltBucket = []byte("kv")
+
+// boltIteratorPage 遍历时每次只读一页，不会长时间占着读事务
+const boltIteratorPage = 1024
+
+// BoltDB 是基于 bbolt 的 KVDatabase，整个数据库是一个文件
+type BoltDB struct {
+	db *bolt.DB
+}
+
+func NewBoltDB(file string) (*BoltDB, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDB{
+		db: db,
+	}, nil
+}
+
+func (bdb *BoltDB) Put(key, value []byte) error {
+	return bdb.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, copyBytes(value))
+	})
+}
+
+func (bdb *BoltDB) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get(key)
+		if v == nil {
+			return ErrNotFound
+		}
+		//v 只在事务内有效
+		value = copyBytes(v)
+		return nil
+	})
+	return value, err
+}
+
+func (bdb *BoltDB) Exist(key []byte) (bool, error) {
+	_, err := bdb.Get(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (bdb *BoltDB) Delete(key []byte) error {
+	return bdb.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+func (bdb *BoltDB) Close() error {
+	return bdb.db.Close()
+}
+
+func (bdb *BoltDB) NewBatch() Batch {
+	return &boltBatch{
+		db: bdb.db,
+	}
+}
+
+func (bdb *BoltDB) NewIterator(prefix []byte, start []byte) Iterator {
+	r := bytesPrefixRange(prefix, start)
+	return &boltIterator{
+		db:    bdb.db,
+		next:  append([]byte{}, r.Start...),
+		limit: r.Limit,
+	}
+}
+
+// Compact bbolt 不支持在线压缩，释放的页会被复用
+func (bdb *BoltDB) Compact(start []byte, limit []byte) error {
+	return nil
+}
+
+func (bdb *BoltDB) Stat() (string, error) {
+	stats := bdb.db.Stats()
+	var keys int
+	err := bdb.db.View(func(tx *bolt.Tx) error {
+		keys = tx.Bucket(boltBucket).Stats().KeyN
+		return nil
+	})
+	return fmt.Sprintf("keys: %d, free pages: %d, pending pages: %d, read txs: %d, write txs: %d",
+		keys, stats.FreePageN, stats.PendingPageN, stats.TxN, stats.TxStats.GetWrite()), err
+}
+
+type boltOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// boltBatch 先把修改记在内存里，Write 的时候在一个写事务里提交
+type boltBatch struct {
+	db   *bolt.DB
+	ops  []boltOp
+	size int
+}
+
+func (batch *boltBatch) Put(key, value []byte) error {
+	batch.ops = append(batch.ops, boltOp{key: copyBytes(key), value: copyBytes(value)})
+	batch.size += len(key) + len(value)
+	return nil
+}
+
+func (batch *boltBatch) Delete(key []byte) error {
+	batch.ops = append(batch.ops, boltOp{key: copyBytes(key), delete: true})
+	batch.size += len(key)
+	return nil
+}
+
+func (batch *boltBatch) ValueSize() int {
+	return batch.size
+}
+
+func (batch *boltBatch) Write() error {
+	return batch.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, op := range batch.ops {
+			var err error
+			if op.delete {
+				err = bucket.Delete(op.key)
+			} else {
+				err = bucket.Put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (batch *boltBatch) Reset() {
+	batch.ops = batch.ops[:0]
+	batch.size = 0
+}
+
+// boltIterator 按页读取，每一页在一个短的读事务里复制出来。
+// 遍历过程中写入的数据，如果在还没有读到的位置，可能会被遍历到。
+type boltIterator struct {
+	db    *bolt.DB
+	next  []byte //下一页从这个key开始，nil 表示已经读完
+	limit []byte
+
+	keys, values [][]byte
+	pos          int
+	err          error
+	done         bool
+}
+
+func (iter *boltIterator) Next() bool {
+	if iter.done {
+		return false
+	}
+	iter.pos++
+	if iter.pos < len(iter.keys) {
+		return true
+	}
+	if iter.next == nil || !iter.load() {
+		iter.done = true
+		iter.keys, iter.values = nil, nil
+		return false
+	}
+	return true
+}
+
+// load 读取下一页，没有数据时返回 false
+func (iter *boltIterator) load() bool {
+	iter.keys, iter.values, iter.pos = iter.keys[:0], iter.values[:0], 0
+	iter.err = iter.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		k, v := c.Seek(iter.next)
+		for ; k != nil && len(iter.keys) < boltIteratorPage; k, v = c.Next() {
+			if iter.limit != nil && bytes.Compare(k, iter.limit) >= 0 {
+				k = nil
+				break
+			}
+			iter.keys = append(iter.keys, copyBytes(k))
+			iter.values = append(iter.values, copyBytes(v))
+		}
+		if k == nil {
+			iter.next = nil
+		} else {
+			iter.next = copyBytes(k)
+		}
+		return nil
+	})
+	return iter.err == nil && len(iter.keys) > 0
+}
+
+func (iter *boltIterator) Error() error {
+	return iter.err
+}
+
+func (iter *boltIterator) Key() []byte {
+	if iter.done || iter.pos >= len(iter.keys) {
+		return nil
+	}
+	return iter.keys[iter.pos]
+}
+
+func (iter *boltIterator) Value() []byte {
+	if iter.done || iter.pos >= len(iter.keys) {
+		return nil
+	}
+	return iter.values[iter.pos]
+}
+
+func (iter *boltIterator) Release() {
+	iter.done = true
+	iter.keys, iter.values = nil, nil
+}
@@ -0,0 +1,233 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// 每个后端都要通过同一组测试，保证换存储引擎时上层的行为不变
+
+func TestLevelDB(t *testing.T) {
+	runKVDatabaseTests(t, func(dir string) (KVDatabase, error) { return NewLevelDB(dir) })
+}
+
+func TestPebbleDB(t *testing.T) {
+	runKVDatabaseTests(t, func(dir string) (KVDatabase, error) { return NewPebbleDB(dir) })
+}
+
+func TestBoltDB(t *testing.T) {
+	runKVDatabaseTests(t, func(dir string) (KVDatabase, error) { return NewBoltDB(filepath.Join(dir, boltFile)) })
+}
+
+func TestMemoryDB(t *testing.T) {
+	runKVDatabaseTests(t, func(string) (KVDatabase, error) { return NewMemoryDB(), nil })
+}
+
+// runKVDatabaseTests 的 open 每次用同一个目录调用时应该打开同一个数据库
+func runKVDatabaseTests(t *testing.T, open func(dir string) (KVDatabase, error)) {
+	newDB := func(t *testing.T) KVDatabase {
+		db, err := open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	t.Run("Basic", func(t *testing.T) { testBasic(t, newDB(t)) })
+	t.Run("Batch", func(t *testing.T) { testBatch(t, newDB(t)) })
+	t.Run("Iterator", func(t *testing.T) { testIterator(t, newDB(t)) })
+	t.Run("LargeIteration", func(t *testing.T) { testLargeIteration(t, newDB(t)) })
+	t.Run("WriteWhileIterating", func(t *testing.T) { testWriteWhileIterating(t, newDB(t)) })
+	t.Run("Reopen", func(t *testing.T) { testReopen(t, open) })
+}
+
+func testBasic(t *testing.T, db KVDatabase) {
+	defer db.Close()
+	if _, err := db.Get([]byte("missing")); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, have %v", err)
+	}
+	value := []byte("value")
+	db.Put([]byte("key"), value)
+	value[0] = 'x' //修改传入的切片不影响数据库
+	if have, _ := db.Get([]byte("key")); !bytes.Equal(have, []byte("value")) {
+		t.Fatalf("value mismatch: have %q", have)
+	}
+	db.Put([]byte("empty"), nil)
+	if have, err := db.Get([]byte("empty")); err != nil || len(have) != 0 {
+		t.Fatalf("empty value mismatch: have %q %v", have, err)
+	}
+	db.Delete([]byte("key"))
+	if ok, _ := db.Exist([]byte("key")); ok {
+		t.Fatal("deleted key still exists")
+	}
+}
+
+func testBatch(t *testing.T, db KVDatabase) {
+	defer db.Close()
+	db.Put([]byte("old"), []byte("value"))
+
+	batch := db.NewBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Put([]byte("b"), []byte("22"))
+	batch.Delete([]byte("old"))
+	if size := batch.ValueSize(); size != 1+1+1+2+3 {
+		t.Fatalf("value size mismatch: have %d", size)
+	}
+	if ok, _ := db.Exist([]byte("a")); ok {
+		t.Fatal("batch applied before Write")
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := db.Get([]byte("b")); !bytes.Equal(value, []byte("22")) {
+		t.Fatalf("value mismatch: have %q", value)
+	}
+	if ok, _ := db.Exist([]byte("old")); ok {
+		t.Fatal("deleted key still exists")
+	}
+
+	batch.Reset()
+	if batch.ValueSize() != 0 {
+		t.Fatal("reset batch is not empty")
+	}
+	batch.Put([]byte("c"), []byte("3"))
+	batch.Write()
+	if ok, _ := db.Exist([]byte("c")); !ok {
+		t.Fatal("reused batch not written")
+	}
+}
+
+func testIterator(t *testing.T, db KVDatabase) {
+	defer db.Close()
+	for _, key := range []string{"h-3", "h-1", "b-1", "h-2", "i-1"} {
+		db.Put([]byte(key), []byte("v"+key))
+	}
+
+	check := func(prefix, start string, want ...string) {
+		it := db.NewIterator([]byte(prefix), []byte(start))
+		defer it.Release()
+		var have []string
+		for it.Next() {
+			have = append(have, string(it.Key()))
+			if string(it.Value()) != "v"+string(it.Key()) {
+				t.Fatalf("value mismatch for %s: %s", it.Key(), it.Value())
+			}
+		}
+		if err := it.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if len(have) != len(want) {
+			t.Fatalf("prefix %q start %q: have %v, want %v", prefix, start, have, want)
+		}
+		for i := range have {
+			if have[i] != want[i] {
+				t.Fatalf("prefix %q start %q: have %v, want %v", prefix, start, have, want)
+			}
+		}
+	}
+	check("h-", "", "h-1", "h-2", "h-3")
+	check("h-", "2", "h-2", "h-3")
+	check("", "", "b-1", "h-1", "h-2", "h-3", "i-1")
+	check("x", "")
+
+	//prefix 后面还有空间的时候，不能把 start 写进调用方的数组
+	buf := []byte("h-9")
+	db.NewIterator(buf[:2], []byte("1")).Release()
+	if string(buf) != "h-9" {
+		t.Fatalf("prefix buffer modified: %q", buf)
+	}
+
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Stat(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testLargeIteration(t *testing.T, db KVDatabase) {
+	defer db.Close()
+	const n = 5000
+	batch := db.NewBatch()
+	for i := 0; i < n; i++ {
+		batch.Put([]byte(fmt.Sprintf("k-%05d", i)), []byte{byte(i)})
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	it := db.NewIterator([]byte("k-"), []byte("01000"))
+	defer it.Release()
+	count := 0
+	for it.Next() {
+		if want := fmt.Sprintf("k-%05d", 1000+count); string(it.Key()) != want {
+			t.Fatalf("key mismatch: have %s, want %s", it.Key(), want)
+		}
+		count++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatal(err)
+	}
+	if count != n-1000 {
+		t.Fatalf("iterated %d keys, want %d", count, n-1000)
+	}
+}
+
+// 一边遍历一边用 batch 改写，数据迁移就是这样做的
+func testWriteWhileIterating(t *testing.T, db KVDatabase) {
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		db.Put([]byte(fmt.Sprintf("old-%03d", i)), []byte{byte(i)})
+	}
+	it := db.NewIterator([]byte("old-"), nil)
+	batch := db.NewBatch()
+	for it.Next() {
+		batch.Put(append([]byte("new-"), it.Key()[4:]...), it.Value())
+		batch.Delete(it.Key())
+		if batch.ValueSize() > 100 {
+			if err := batch.Write(); err != nil {
+				t.Fatal(err)
+			}
+			batch.Reset()
+		}
+	}
+	it.Release()
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := db.Exist([]byte(fmt.Sprintf("old-%03d", i))); ok {
+			t.Fatalf("old key %d not deleted", i)
+		}
+		if value, err := db.Get([]byte(fmt.Sprintf("new-%03d", i))); err != nil || !bytes.Equal(value, []byte{byte(i)}) {
+			t.Fatalf("new key %d mismatch: %x %v", i, value, err)
+		}
+	}
+}
+
+func testReopen(t *testing.T, open func(dir string) (KVDatabase, error)) {
+	dir := t.TempDir()
+	db, err := open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("key"), []byte("value"))
+	batch := db.NewBatch()
+	batch.Put([]byte("batch"), []byte("value"))
+	batch.Write()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, ok := db.(*MemoryDB); ok {
+		return //内存数据库不会保留数据
+	}
+	for _, key := range []string{"key", "batch"} {
+		if value, err := db.Get([]byte(key)); err != nil || string(value) != "value" {
+			t.Fatalf("%s not persisted: %q %v", key, value, err)
+		}
+	}
+}
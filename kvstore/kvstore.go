@@ -1,15 +1,57 @@
 package kvstore
 
-import "io"
+import (
+	"errors"
+	"io"
+)
 
-type KVStore interface {
-	Put(key, value []byte) error
+// ErrNotFound 是 Get 一个不存在的key时返回的错误，所有实现都一样
+var ErrNotFound = errors.New("not found")
+
+type KeyValueReader interface {
 	Get(key []byte) ([]byte, error)
 	Exist(key []byte) (bool, error)
+}
+
+type KeyValueWriter interface {
+	Put(key, value []byte) error
 	Delete(key []byte) error
 }
 
+type KVStore interface {
+	KeyValueReader
+	KeyValueWriter
+
+	// NewBatch 创建一个批量写，所有修改在 Write 的时候原子地写入
+	NewBatch() Batch
+	// NewIterator 按key的顺序遍历所有以 prefix 开头、并且不小于 prefix+start 的数据
+	NewIterator(prefix []byte, start []byte) Iterator
+	// Compact 压缩 [start, limit) 范围内的数据，nil 表示不限制
+	Compact(start []byte, limit []byte) error
+	// Stat 返回数据库的统计信息
+	Stat() (string, error)
+}
+
 type KVDatabase interface {
 	KVStore
 	io.Closer
 }
+
+// Batch collects writes in memory and applies them atomically on Write.
+type Batch interface {
+	KeyValueWriter
+	// ValueSize 返回已经写入 batch 的数据大小
+	ValueSize() int
+	Write() error
+	// Reset 清空 batch，可以重复使用
+	Reset()
+}
+
+// Iterator 遍历数据库中的一段有序的key，用完之后必须调用 Release
+type Iterator interface {
+	Next() bool
+	Error() error
+	Key() []byte
+	Value() []byte
+	Release()
+}
@@ -1,26 +1,32 @@
 package kvstore
 
-import "github.com/syndtr/goleveldb/leveldb"
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
 
 type LevelDB struct {
 	db *leveldb.DB
 }
 
-func NewLevelDB(path string) *LevelDB {
+func NewLevelDB(path string) (*LevelDB, error) {
 	db, err := leveldb.OpenFile(path, nil)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	return &LevelDB{
 		db: db,
-	}
-
+	}, nil
 }
 func (ldb *LevelDB) Put(key, value []byte) error {
 	return ldb.db.Put(key, value, nil)
 }
 func (ldb *LevelDB) Get(key []byte) ([]byte, error) {
-	return ldb.db.Get(key, nil)
+	value, err := ldb.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return value, err
 }
 func (ldb *LevelDB) Exist(key []byte) (bool, error) {
 	return ldb.db.Has(key, nil)
@@ -31,3 +37,53 @@ func (ldb *LevelDB) Delete(key []byte) error {
 func (ldb *LevelDB) Close() error {
 	return ldb.db.Close()
 }
+func (ldb *LevelDB) NewBatch() Batch {
+	return &levelDBBatch{
+		db: ldb.db,
+		b:  new(leveldb.Batch),
+	}
+}
+func (ldb *LevelDB) NewIterator(prefix []byte, start []byte) Iterator {
+	return ldb.db.NewIterator(bytesPrefixRange(prefix, start), nil)
+}
+func (ldb *LevelDB) Compact(start []byte, limit []byte) error {
+	return ldb.db.CompactRange(util.Range{Start: start, Limit: limit})
+}
+func (ldb *LevelDB) Stat() (string, error) {
+	return ldb.db.GetProperty("leveldb.stats")
+}
+
+// bytesPrefixRange 返回所有以 prefix 开头并且不小于 prefix+start 的key的范围
+func bytesPrefixRange(prefix, start []byte) *util.Range {
+	r := util.BytesPrefix(prefix)
+	//r.Start 就是调用方的 prefix，直接 append 可能改掉调用方的数组
+	r.Start = append(append([]byte{}, prefix...), start...)
+	return r
+}
+
+type levelDBBatch struct {
+	db   *leveldb.DB
+	b    *leveldb.Batch
+	size int
+}
+
+func (batch *levelDBBatch) Put(key, value []byte) error {
+	batch.b.Put(key, value)
+	batch.size += len(key) + len(value)
+	return nil
+}
+func (batch *levelDBBatch) Delete(key []byte) error {
+	batch.b.Delete(key)
+	batch.size += len(key)
+	return nil
+}
+func (batch *levelDBBatch) ValueSize() int {
+	return batch.size
+}
+func (batch *levelDBBatch) Write() error {
+	return batch.db.Write(batch.b, nil)
+}
+func (batch *levelDBBatch) Reset() {
+	batch.b.Reset()
+	batch.size = 0
+}
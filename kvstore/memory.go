@@ -0,0 +1,218 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var errMemoryDBClosed = errors.New("database closed")
+
+// MemoryDB 是放在内存里的 KVDatabase，语义和 LevelDB 一样（包括 batch 和有序遍历），
+// 进程退出后数据全部丢失，用于测试和临时节点
+type MemoryDB struct {
+	lock sync.RWMutex
+	db   map[string][]byte
+}
+
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		db: make(map[string][]byte),
+	}
+}
+
+func (mdb *MemoryDB) Put(key, value []byte) error {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
+	if mdb.db == nil {
+		return errMemoryDBClosed
+	}
+	mdb.db[string(key)] = copyBytes(value)
+	return nil
+}
+
+func (mdb *MemoryDB) Get(key []byte) ([]byte, error) {
+	mdb.lock.RLock()
+	defer mdb.lock.RUnlock()
+	if mdb.db == nil {
+		return nil, errMemoryDBClosed
+	}
+	if value, ok := mdb.db[string(key)]; ok {
+		return copyBytes(value), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (mdb *MemoryDB) Exist(key []byte) (bool, error) {
+	mdb.lock.RLock()
+	defer mdb.lock.RUnlock()
+	if mdb.db == nil {
+		return false, errMemoryDBClosed
+	}
+	_, ok := mdb.db[string(key)]
+	return ok, nil
+}
+
+func (mdb *MemoryDB) Delete(key []byte) error {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
+	if mdb.db == nil {
+		return errMemoryDBClosed
+	}
+	delete(mdb.db, string(key))
+	return nil
+}
+
+func (mdb *MemoryDB) Close() error {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
+	mdb.db = nil
+	return nil
+}
+
+func (mdb *MemoryDB) NewBatch() Batch {
+	return &memoryBatch{db: mdb}
+}
+
+// NewIterator 遍历的是创建时的快照，之后的修改不可见
+func (mdb *MemoryDB) NewIterator(prefix []byte, start []byte) Iterator {
+	mdb.lock.RLock()
+	defer mdb.lock.RUnlock()
+
+	var (
+		pr     = string(prefix)
+		st     = pr + string(start)
+		keys   = make([]string, 0)
+		values = make([][]byte, 0)
+	)
+	for key := range mdb.db {
+		if strings.HasPrefix(key, pr) && key >= st {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values = append(values, mdb.db[key])
+	}
+	return &memoryIterator{
+		keys:   keys,
+		values: values,
+		index:  -1,
+	}
+}
+
+// Compact 对内存数据库没有意义
+func (mdb *MemoryDB) Compact(start []byte, limit []byte) error {
+	return nil
+}
+
+func (mdb *MemoryDB) Stat() (string, error) {
+	mdb.lock.RLock()
+	defer mdb.lock.RUnlock()
+	size := 0
+	for key, value := range mdb.db {
+		size += len(key) + len(value)
+	}
+	return fmt.Sprintf("memorydb: %d entries, %d bytes", len(mdb.db), size), nil
+}
+
+// Len 返回数据条数
+func (mdb *MemoryDB) Len() int {
+	mdb.lock.RLock()
+	defer mdb.lock.RUnlock()
+	return len(mdb.db)
+}
+
+type keyvalue struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+type memoryBatch struct {
+	db     *MemoryDB
+	writes []keyvalue
+	size   int
+}
+
+func (b *memoryBatch) Put(key, value []byte) error {
+	b.writes = append(b.writes, keyvalue{copyBytes(key), copyBytes(value), false})
+	b.size += len(key) + len(value)
+	return nil
+}
+
+func (b *memoryBatch) Delete(key []byte) error {
+	b.writes = append(b.writes, keyvalue{copyBytes(key), nil, true})
+	b.size += len(key)
+	return nil
+}
+
+func (b *memoryBatch) ValueSize() int {
+	return b.size
+}
+
+// Write 在一次加锁里应用所有修改，保证原子性
+func (b *memoryBatch) Write() error {
+	b.db.lock.Lock()
+	defer b.db.lock.Unlock()
+	if b.db.db == nil {
+		return errMemoryDBClosed
+	}
+	for _, kv := range b.writes {
+		if kv.delete {
+			delete(b.db.db, string(kv.key))
+			continue
+		}
+		b.db.db[string(kv.key)] = kv.value
+	}
+	return nil
+}
+
+func (b *memoryBatch) Reset() {
+	b.writes = b.writes[:0]
+	b.size = 0
+}
+
+type memoryIterator struct {
+	keys   []string
+	values [][]byte
+	index  int
+}
+
+func (it *memoryIterator) Next() bool {
+	if it.index >= len(it.keys) {
+		return false
+	}
+	it.index++
+	return it.index < len(it.keys)
+}
+
+func (it *memoryIterator) Error() error {
+	return nil
+}
+
+func (it *memoryIterator) Key() []byte {
+	if it.index < 0 || it.index >= len(it.keys) {
+		return nil
+	}
+	return []byte(it.keys[it.index])
+}
+
+func (it *memoryIterator) Value() []byte {
+	if it.index < 0 || it.index >= len(it.keys) {
+		return nil
+	}
+	return it.values[it.index]
+}
+
+func (it *memoryIterator) Release() {
+	it.keys, it.values = nil, nil
+}
+
+func copyBytes(b []byte) []byte {
+	cpy := make([]byte, len(b))
+	copy(cpy, b)
+	return cpy
+}
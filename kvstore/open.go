@@ -0,0 +1,77 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	LevelDBBackend = "leveldb"
+	PebbleBackend  = "pebble"
+	BoltBackend    = "bbolt"
+)
+
+// backendFile 记录数据库目录用的是哪种存储引擎
+const backendFile = "BACKEND"
+
+// boltFile 是 bbolt 在数据库目录里的数据文件
+const boltFile = "bolt.db"
+
+// Open 打开 dir 目录下的数据库。backend 为空时使用目录里记录的引擎，新目录默认用 LevelDB；
+// 目录已经用另一种引擎创建过时返回错误，不会用错误的引擎打开。
+func Open(dir string, backend string) (KVDatabase, error) {
+	stored, recorded, err := readBackend(dir)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case backend == "" && stored == "":
+		backend = LevelDBBackend
+	case backend == "":
+		backend = stored
+	case stored != "" && stored != backend:
+		return nil, fmt.Errorf("database %s was created with %s, cannot open it with %s", dir, stored, backend)
+	}
+
+	var db KVDatabase
+	switch backend {
+	case LevelDBBackend:
+		db, err = NewLevelDB(dir)
+	case PebbleBackend:
+		db, err = NewPebbleDB(dir)
+	case BoltBackend:
+		if err = os.MkdirAll(dir, 0755); err == nil {
+			db, err = NewBoltDB(filepath.Join(dir, boltFile))
+		}
+	default:
+		return nil, fmt.Errorf("unknown database backend: %s", backend)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !recorded {
+		if err := os.WriteFile(filepath.Join(dir, backendFile), []byte(backend+"\n"), 0644); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// readBackend 读取目录记录的引擎。没有记录但已经有 LevelDB 文件的目录是之前版本创建的，当作 LevelDB
+func readBackend(dir string) (backend string, recorded bool, err error) {
+	data, err := os.ReadFile(filepath.Join(dir, backendFile))
+	if err == nil {
+		return strings.TrimSpace(string(data)), true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", false, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "CURRENT")); err == nil {
+		return LevelDBBackend, false, nil
+	}
+	return "", false, nil
+}
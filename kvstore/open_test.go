@@ -0,0 +1,62 @@
+package kvstore
+
+import (
+	"testing"
+)
+
+func TestOpenRefusesOtherBackend(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir, PebbleBackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put([]byte("key"), []byte("value"))
+	db.Close()
+
+	for _, backend := range []string{LevelDBBackend, BoltBackend} {
+		if db, err := Open(dir, backend); err == nil {
+			db.Close()
+			t.Fatalf("opened a pebble database with %s", backend)
+		}
+	}
+	// 不指定引擎时使用目录里记录的引擎
+	db, err = Open(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, ok := db.(*PebbleDB); !ok {
+		t.Fatalf("opened with %T, want *PebbleDB", db)
+	}
+	if value, _ := db.Get([]byte("key")); string(value) != "value" {
+		t.Fatalf("value mismatch: %q", value)
+	}
+}
+
+// 之前版本创建的 LevelDB 目录没有记录引擎
+func TestOpenLegacyLevelDB(t *testing.T) {
+	dir := t.TempDir()
+	ldb, err := NewLevelDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb.Close()
+	if db, err := Open(dir, BoltBackend); err == nil {
+		db.Close()
+		t.Fatal("opened a legacy leveldb database with bbolt")
+	}
+	db, err := Open(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if backend, recorded, _ := readBackend(dir); backend != LevelDBBackend || !recorded {
+		t.Fatalf("backend not recorded: %q %v", backend, recorded)
+	}
+}
+
+func TestOpenUnknownBackend(t *testing.T) {
+	if _, err := Open(t.TempDir(), "rocksdb"); err == nil {
+		t.Fatal("opened a database with an unknown backend")
+	}
+}
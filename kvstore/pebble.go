@@ -0,0 +1,181 @@
+package kvstore
+
+import (
+	"bytes"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// PebbleDB 是基于 Pebble 的 KVDatabase。
+// 单个的 Put、Delete 和 batch 的 Write 都用 writeOptions，返回之前 WAL 已经落盘，
+// 和 bbolt 每个事务都 fsync 一样，不会因为走了哪条写入路径而丢数据
+type PebbleDB struct {
+	db *pebble.DB
+}
+
+var writeOptions = pebble.Sync
+
+func NewPebbleDB(path string) (*PebbleDB, error) {
+	db, err := pebble.Open(path, &pebble.Options{})
+	if err != nil {
+		return nil, err
+	}
+	return &PebbleDB{
+		db: db,
+	}, nil
+}
+
+func (pdb *PebbleDB) Put(key, value []byte) error {
+	return pdb.db.Set(key, value, writeOptions)
+}
+
+func (pdb *PebbleDB) Get(key []byte) ([]byte, error) {
+	value, closer, err := pdb.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	//value 在 closer 关闭之后就失效了
+	return copyBytes(value), nil
+}
+
+func (pdb *PebbleDB) Exist(key []byte) (bool, error) {
+	_, err := pdb.Get(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (pdb *PebbleDB) Delete(key []byte) error {
+	return pdb.db.Delete(key, writeOptions)
+}
+
+func (pdb *PebbleDB) Close() error {
+	return pdb.db.Close()
+}
+
+func (pdb *PebbleDB) NewBatch() Batch {
+	return &pebbleBatch{
+		db: pdb.db,
+		b:  pdb.db.NewBatch(),
+	}
+}
+
+func (pdb *PebbleDB) NewIterator(prefix []byte, start []byte) Iterator {
+	r := bytesPrefixRange(prefix, start)
+	it, err := pdb.db.NewIter(&pebble.IterOptions{
+		LowerBound: r.Start,
+		UpperBound: r.Limit,
+	})
+	return &pebbleIterator{it: it, err: err}
+}
+
+// Compact 压缩 [start, limit) 范围内的数据，nil 表示没有边界
+func (pdb *PebbleDB) Compact(start []byte, limit []byte) error {
+	if limit == nil {
+		//Pebble 不接受没有上界的范围，用最后一个key之后的位置代替
+		it, err := pdb.db.NewIter(nil)
+		if err != nil {
+			return err
+		}
+		if it.Last() {
+			limit = append(copyBytes(it.Key()), 0x00)
+		}
+		if err := it.Close(); err != nil {
+			return err
+		}
+		if limit == nil {
+			return nil //数据库是空的
+		}
+	}
+	if start == nil {
+		start = []byte{}
+	}
+	if bytes.Compare(start, limit) >= 0 {
+		return nil
+	}
+	return pdb.db.Compact(start, limit, true)
+}
+
+func (pdb *PebbleDB) Stat() (string, error) {
+	return pdb.db.Metrics().String(), nil
+}
+
+type pebbleBatch struct {
+	db   *pebble.DB
+	b    *pebble.Batch
+	size int
+}
+
+func (batch *pebbleBatch) Put(key, value []byte) error {
+	batch.size += len(key) + len(value)
+	return batch.b.Set(key, value, nil)
+}
+
+func (batch *pebbleBatch) Delete(key []byte) error {
+	batch.size += len(key)
+	return batch.b.Delete(key, nil)
+}
+
+func (batch *pebbleBatch) ValueSize() int {
+	return batch.size
+}
+
+func (batch *pebbleBatch) Write() error {
+	return batch.b.Commit(writeOptions)
+}
+
+func (batch *pebbleBatch) Reset() {
+	batch.b.Reset()
+	batch.size = 0
+}
+
+// pebbleIterator 把 Pebble 的迭代器适配成 LevelDB 的语义：第一次 Next 移到第一个元素
+type pebbleIterator struct {
+	it       *pebble.Iterator
+	err      error
+	moved    bool
+	released bool
+}
+
+func (iter *pebbleIterator) Next() bool {
+	if iter.err != nil || iter.released {
+		return false
+	}
+	if !iter.moved {
+		iter.moved = true
+		return iter.it.First()
+	}
+	return iter.it.Next()
+}
+
+func (iter *pebbleIterator) Error() error {
+	if iter.err != nil || iter.released {
+		return iter.err
+	}
+	return iter.it.Error()
+}
+
+func (iter *pebbleIterator) Key() []byte {
+	if iter.err != nil || iter.released || !iter.it.Valid() {
+		return nil
+	}
+	return iter.it.Key()
+}
+
+func (iter *pebbleIterator) Value() []byte {
+	if iter.err != nil || iter.released || !iter.it.Valid() {
+		return nil
+	}
+	return iter.it.Value()
+}
+
+func (iter *pebbleIterator) Release() {
+	if iter.it != nil && !iter.released {
+		iter.it.Close()
+		iter.released = true
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"blockchain/blockchain"
 	"blockchain/kvstore"
 	"blockchain/maker"
+	"blockchain/rawdb"
+	"blockchain/statdb"
 	"blockchain/statemachine"
 	"blockchain/trie"
 	"blockchain/txpool"
@@ -11,10 +13,16 @@ import (
 	"blockchain/utils/hexutil"
 	"bufio"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/holiman/uint256"
 )
 
 const (
@@ -30,36 +38,94 @@ type Node interface {
 
 type node struct {
 	blockchain *blockchain.Blockchain
+	db         kvstore.KVDatabase
 	minter     string
+	listenAddr string
+}
+
+type nodeConfig struct {
+	Trie       *trie.Config
+	TxPool     *txpool.Config
+	DataDir    string
+	DBEngine   string //leveldb、pebble 或 bbolt，为空时使用数据库目录里记录的引擎
+	Ephemeral  bool   //使用内存数据库，节点之间互相隔离，退出后数据全部丢失
+	ListenAddr string
 }
 
 type TransactionData struct {
-	From     string `json:"from"`
-	To       string `json:"to"`
-	Nonce    uint64 `json:"nonce"`
-	Value    uint64 `json:"value"`
-	Gas      uint64 `json:"gas"`
-	GasPrice uint64 `json:"gasPrice"`
-	Input    string `json:"input"`
-	R        string `json:"r"`
-	S        string `json:"s"`
-	V        uint8  `json:"v"`
+	From     string       `json:"from"`
+	To       string       `json:"to"` //为空时创建合约，Input 是初始化代码
+	Nonce    uint64       `json:"nonce"`
+	Value    *uint256.Int `json:"value"` //十进制数字，或者十进制、十六进制的字符串
+	Gas      uint64       `json:"gas"`
+	GasPrice uint64       `json:"gasPrice"`
+	Input    string       `json:"input"` //调用合约的参数，0x 开头的十六进制
+	R        string       `json:"r"`
+	S        string       `json:"s"`
+	V        uint8        `json:"v"`
+	Local    bool         `json:"local"` //本节点用户提交的交易，不受最低 gas price 限制，不会被挤掉，重启后还在
+
+	//设置了 maxFeePerGas 的是 EIP-1559 交易，gasPrice 被忽略
+	MaxFeePerGas         uint64 `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas uint64 `json:"maxPriorityFeePerGas,omitempty"`
+}
+
+// TransactionResponse 是提交交易的结果，交易被拒绝时 Error 是原因
+type TransactionResponse struct {
+	Hash            string `json:"hash,omitempty"`
+	ContractAddress string `json:"contractAddress,omitempty"` //创建合约的交易部署到的地址
+	Error           string `json:"error,omitempty"`
 }
 
 type AccountStatusResponse struct {
-	Balance uint64 `json:"balance"`
-	Nonce   uint64 `json:"nonce"`
+	Balance *uint256.Int `json:"balance"` //256 位的余额编码成十进制字符串
+	Nonce   uint64       `json:"nonce"`
 }
 
 func main() {
-	node := initNode()
+	scheme := flag.String("trie", trie.RadixScheme, "state trie implementation: radix or mpt")
+	datadir := flag.String("datadir", "./leveldb", "directory of the database")
+	engine := flag.String("db.engine", "", "database backend: leveldb, pebble or bbolt (default: the one recorded in datadir, leveldb for a new datadir)")
+	listen := flag.String("listen", ":8080", "address to listen for transactions on")
+	journal := flag.String("txpool.journal", "transactions.rlp", "journal of local transactions, relative to datadir (empty to disable)")
+	var ephemeral bool
+	flag.BoolVar(&ephemeral, "dev", false, "run an ephemeral node backed by an in-memory database")
+	flag.BoolVar(&ephemeral, "ephemeral", false, "same as -dev")
+	flag.Parse()
+
+	poolConfig := *txpool.DefaultConfig
+	poolConfig.Journal = *journal
+	node, err := initNode(&nodeConfig{
+		Trie:       &trie.Config{Scheme: *scheme},
+		TxPool:     &poolConfig,
+		DataDir:    *datadir,
+		DBEngine:   *engine,
+		Ephemeral:  ephemeral,
+		ListenAddr: *listen,
+	})
+	if err != nil {
+		fmt.Println(Red+"Node initialization failed:", err)
+		fmt.Printf(Reset)
+		os.Exit(1)
+	}
+	if flag.NArg() > 0 {
+		err := runChainCommand(node, flag.Args())
+		node.db.Close()
+		if err != nil {
+			fmt.Println(Red+"Error:", err)
+			fmt.Printf(Reset)
+			os.Exit(1)
+		}
+		return
+	}
 	node.startNode()
 }
 
-func NewNode(statedb *trie.State, txpool *txpool.DefaultPool) *node {
+func NewNode(chain *blockchain.Blockchain) *node {
 	return &node{
-		blockchain.NewBlockchain(statedb, txpool),
-		"0x6c8E523FC59529765Ea6A3Bf0cC18AFFc171e484",
+		blockchain: chain,
+		minter:     "0x6c8E523FC59529765Ea6A3Bf0cC18AFFc171e484",
+		listenAddr: ":8080",
 	}
 }
 
@@ -75,24 +141,90 @@ func (n *node) startNode() error {
 	}
 }
 
-func initNode() *node {
+func initNode(config *nodeConfig) (*node, error) {
 	fmt.Println("Initialing...")
-	db := kvstore.NewLevelDB("./leveldb")
-	state := trie.NewState(db, trie.EmptyHash)
+	var db kvstore.KVDatabase
+	if config.Ephemeral {
+		fmt.Println(Yellow + "Running an ephemeral node, all data will be lost on exit")
+		fmt.Printf(Reset)
+		db = kvstore.NewMemoryDB()
+	} else {
+		var err error
+		if db, err = kvstore.Open(config.DataDir, config.DBEngine); err != nil {
+			return nil, err
+		}
+	}
+	// 旧版本的数据库原地升级到当前的 schema
+	if err := rawdb.Migrate(db); err != nil {
+		return nil, err
+	}
+	if err := checkTrieScheme(db, config.Trie); err != nil {
+		return nil, err
+	}
 
-	// Initialize accounts for testing
-	initAccount(state, "0x9B682e9770C315f43954e37D8880a6Be815A3E53", 300, 0)
+	root := trie.EmptyHash
+	head := rawdb.ReadHeadHeader(db)
+	if head != nil {
+		root = head.Root
+	}
+	state := trie.NewStateWithConfig(db, root, config.Trie)
+	if head == nil {
+		// Initialize accounts for testing
+		initAccount(state, "0x9B682e9770C315f43954e37D8880a6Be815A3E53", devAccountBalance, 0)
+	}
 
-	txpool := txpool.NewDefaultPool(state)
-	node := NewNode(state, txpool)
+	poolConfig := txpool.DefaultConfig
+	if config.TxPool != nil {
+		poolConfig = config.TxPool
+	}
+	// 日志放在数据库目录里，内存数据库的节点不写日志
+	if poolConfig.Journal != "" {
+		cpy := *poolConfig
+		if config.Ephemeral {
+			cpy.Journal = ""
+		} else if !filepath.IsAbs(cpy.Journal) {
+			cpy.Journal = filepath.Join(config.DataDir, cpy.Journal)
+		}
+		poolConfig = &cpy
+	}
+	txpool := txpool.NewDefaultPoolWithConfig(state, poolConfig)
+	chain, err := blockchain.NewBlockchain(db, state, txpool)
+	if err != nil {
+		return nil, err
+	}
+	txpool.SubscribeChainHeads(chain)
+	node := NewNode(chain)
+	node.db = db
+	if config.ListenAddr != "" {
+		node.listenAddr = config.ListenAddr
+	}
 	fmt.Println(Green + "Node initialization successful!")
 	fmt.Printf(Reset)
-	return node
+	return node, nil
 }
 
-func initAccount(state *trie.State, address string, amount uint64, nonce uint64) {
+// checkTrieScheme 数据库第一次使用时记录状态 trie 的实现，之后拒绝用另一种实现打开
+func checkTrieScheme(db kvstore.KVDatabase, config *trie.Config) error {
+	scheme := config.Scheme
+	if scheme == "" {
+		scheme = trie.RadixScheme
+	}
+	stored := rawdb.ReadTrieScheme(db)
+	if stored == "" {
+		return rawdb.WriteTrieScheme(db, scheme)
+	}
+	if stored != scheme {
+		return fmt.Errorf("database was created with trie scheme %q, cannot open it with %q", stored, scheme)
+	}
+	return nil
+}
+
+// devAccountBalance 是测试账户的初始余额，1 ether，够付很多笔交易的 base fee
+const devAccountBalance = 1000000000000000000
+
+func initAccount(state statdb.StatDB, address string, amount uint64, nonce uint64) {
 	account := types.Account{
-		Amount: amount,
+		Amount: *uint256.NewInt(amount),
 		Nonce:  nonce,
 	}
 	add, _ := hexutil.Decode(address)
@@ -103,14 +235,14 @@ func initAccount(state *trie.State, address string, amount uint64, nonce uint64)
 }
 
 func (n *node) listenForTransactions() {
-	listen, err := net.Listen("tcp", ":8080")
+	listen, err := net.Listen("tcp", n.listenAddr)
 	if err != nil {
 		fmt.Println(Red+"Error setting up listener:", err)
 		return
 	}
 	defer listen.Close()
 
-	fmt.Println("Listening on port 8080...")
+	fmt.Println("Listening on " + n.listenAddr + "...")
 	fmt.Println("================================================================")
 	for {
 		conn, err := listen.Accept()
@@ -140,6 +272,18 @@ func (n *node) handleConnection(conn net.Conn) {
 		if strings.HasPrefix(request, "GET_ACCOUNT_STATUS") {
 			address := strings.TrimPrefix(request, "GET_ACCOUNT_STATUS ")
 			n.handleAccountStatusRequest(conn, address)
+		} else if request == "TXPOOL_STATUS" {
+			n.handleTxPoolStatusRequest(conn)
+		} else if request == "TXPOOL_CONTENT" {
+			n.handleTxPoolContentRequest(conn)
+		} else if strings.HasPrefix(request, "TXPOOL_CONTENT_FROM ") {
+			n.handleTxPoolContentFromRequest(conn, strings.TrimPrefix(request, "TXPOOL_CONTENT_FROM "))
+		} else if strings.HasPrefix(request, "TXPOOL_GET ") {
+			n.handleTxPoolGetRequest(conn, strings.TrimPrefix(request, "TXPOOL_GET "))
+		} else if strings.HasPrefix(request, "CALL ") {
+			n.handleCallRequest(conn, strings.TrimPrefix(request, "CALL "))
+		} else if strings.HasPrefix(request, "ESTIMATE_GAS ") {
+			n.handleEstimateGasRequest(conn, strings.TrimPrefix(request, "ESTIMATE_GAS "))
 		} else {
 			n.handleTransactionRequest(conn, request)
 		}
@@ -152,51 +296,162 @@ func (n *node) handleTransactionRequest(conn net.Conn, request string) {
 	if err != nil {
 		fmt.Println("Error unmarshalling data:", err)
 		fmt.Printf(Reset)
+		writeResponse(conn, TransactionResponse{Error: err.Error()})
 		return
 	}
 
-	fromAddress := txData.From
-	fromAdd, _ := hexutil.Decode(fromAddress)
-	var fromAddr types.Address
-	copy(fromAddr[:], fromAdd[:20])
+	tx, err := txData.transaction()
+	if err != nil {
+		writeResponse(conn, TransactionResponse{Error: err.Error()})
+		return
+	}
+	// 签名由交易池检查，被拒绝的原因原样返回给客户端
+	add := n.blockchain.Txpool.NewTx
+	if txData.Local {
+		// 本地交易不受最低价格限制也不会被挤掉，只接受本机提交的，其他连接按远程交易处理
+		if isLoopback(conn.RemoteAddr()) {
+			add = n.blockchain.Txpool.AddLocal
+		} else {
+			fmt.Println(Yellow+"Ignoring local flag from remote peer", conn.RemoteAddr())
+			fmt.Printf(Reset)
+		}
+	}
+	if err := add(tx); err != nil {
+		fmt.Println(Yellow+"Transaction rejected:", err)
+		fmt.Printf(Reset)
+		writeResponse(conn, TransactionResponse{Error: err.Error()})
+		return
+	}
+	resp := TransactionResponse{Hash: tx.Hash().Hex()}
+	if tx.To() == nil {
+		addr := types.CreateAddress(tx.From(), tx.Nonce())
+		resp.ContractAddress = hexutil.Encode(addr[:])
+	}
+	writeResponse(conn, resp)
+}
 
-	toAddress := txData.To
-	toAdd, _ := hexutil.Decode(toAddress)
-	var toAddr types.Address
-	copy(toAddr[:], toAdd[:20])
-	tx := types.NewTransaction(txData.Nonce, toAddr, fromAddr, txData.Value, txData.Gas, txData.GasPrice, []byte(txData.Input))
-	success := tx.Verify()
-	if success {
-		n.blockchain.Txpool.NewTx(tx)
-	} else {
-		fmt.Println("Transaction verification failed!")
+// isLoopback 判断连接是不是从本机发起的
+func isLoopback(addr net.Addr) bool {
+	if addr == nil {
+		return false
 	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
 
+// transaction 按请求创建交易，to 为空时是创建合约的交易，带有签名时附上签名
+func (txData *TransactionData) transaction() (*types.Transaction, error) {
+	var fromAddr, toAddr types.Address
+	var err error
+	if txData.From != "" {
+		if fromAddr, err = parseAddress(txData.From); err != nil {
+			return nil, errors.New("invalid from address: " + err.Error())
+		}
+	}
+	if txData.To != "" {
+		if toAddr, err = parseAddress(txData.To); err != nil {
+			return nil, errors.New("invalid to address: " + err.Error())
+		}
+	}
+	var input []byte
+	if txData.Input != "" {
+		if input, err = hexutil.Decode(txData.Input); err != nil {
+			return nil, errors.New("invalid input: " + err.Error())
+		}
+	}
+	tx := types.NewTransaction(txData.Nonce, toAddr, fromAddr, txData.Value, txData.Gas, txData.GasPrice, input)
+	if txData.MaxFeePerGas > 0 {
+		tx = types.NewDynamicFeeTransaction(txData.Nonce, toAddr, fromAddr, txData.Value, txData.Gas,
+			txData.MaxFeePerGas, txData.MaxPriorityFeePerGas, input)
+	}
+	if txData.To == "" {
+		tx.Txdata.To = nil
+	}
+	if sig, err := txData.signature(); err == nil {
+		tx, _ = tx.WithSignature(sig)
+	}
+	return tx, nil
 }
 
-func (n *node) handleAccountStatusRequest(conn net.Conn, address string) {
-	address1, _ := hexutil.Decode(address)
-	var Address1 types.Address
-	copy(Address1[:], address1[:20])
-	account, _ := n.blockchain.Statedb.Load(Address1)
-	response := AccountStatusResponse{
-		Balance: account.Amount,
-		Nonce:   account.Nonce,
+// signature 把 R、S、V 拼成 65 字节的签名，V 可以是 0/1 或者 27/28
+func (txData *TransactionData) signature() ([]byte, error) {
+	r, err := hexutil.Decode(txData.R)
+	if err != nil {
+		return nil, err
+	}
+	s, err := hexutil.Decode(txData.S)
+	if err != nil {
+		return nil, err
 	}
+	if len(r) > 32 || len(s) > 32 {
+		return nil, errors.New("invalid signature length")
+	}
+	sig := make([]byte, 65)
+	copy(sig[32-len(r):32], r)
+	copy(sig[64-len(s):64], s)
+	sig[64] = txData.V
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+	return sig, nil
+}
 
+func writeResponse(conn net.Conn, response interface{}) {
 	respJSON, err := json.Marshal(response)
 	if err != nil {
 		fmt.Println("Error marshalling response:", err)
 		return
 	}
-
 	respJSON = append(respJSON, '\n')
-	_, err = conn.Write(respJSON)
-	if err != nil {
+	if _, err := conn.Write(respJSON); err != nil {
 		fmt.Println("Error sending response:", err)
 	}
 }
 
+// handleAccountStatusRequest 处理 GET_ACCOUNT_STATUS <address> [latest|pending]。
+// latest 是最新区块的状态；pending 还算上了交易池里的 pending 交易，客户端连续发送交易时用它取 nonce
+func (n *node) handleAccountStatusRequest(conn net.Conn, request string) {
+	args := strings.Fields(request)
+	if len(args) == 0 || len(args) > 2 {
+		writeResponse(conn, TransactionResponse{Error: "usage: GET_ACCOUNT_STATUS <address> [latest|pending]"})
+		return
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		writeResponse(conn, TransactionResponse{Error: err.Error()})
+		return
+	}
+	tag := "latest"
+	if len(args) == 2 {
+		tag = args[1]
+	}
+	var account types.Account
+	switch tag {
+	case "latest":
+		//出块时会写 Statedb，要通过加锁的 StateAt 读
+		state, err := n.blockchain.StateAt(n.blockchain.CurrentBlock())
+		if err != nil {
+			writeResponse(conn, TransactionResponse{Error: err.Error()})
+			return
+		}
+		account, _ = state.Load(addr)
+	case "pending":
+		account = n.blockchain.Txpool.PendingAccount(addr)
+	default:
+		writeResponse(conn, TransactionResponse{Error: fmt.Sprintf("unknown block tag %q", tag)})
+		return
+	}
+	response := AccountStatusResponse{
+		Balance: &account.Amount,
+		Nonce:   account.Nonce,
+	}
+	writeResponse(conn, response)
+}
+
 func (n *node) createBlock() {
 
 	fmt.Println("start make block...")
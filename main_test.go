@@ -0,0 +1,302 @@
+package main
+
+import (
+	"blockchain/blockchain"
+	"blockchain/blockchain/eip1559"
+	"blockchain/trie"
+	"blockchain/txpool"
+	"blockchain/types"
+	"blockchain/utils/hexutil"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func testAddress(s string) types.Address {
+	var addr types.Address
+	copy(addr[:], hexutil.MustDecode(s))
+	return addr
+}
+
+// testGasPrice 是要出块的测试交易的 gas price，创世区块之后的 base fee 是 InitialBaseFee，之后只会下降
+const testGasPrice = eip1559.InitialBaseFee
+
+// testNodeConfig 是临时节点的配置，测试交易没有签名，交易池不限制 gas price
+func testNodeConfig() *nodeConfig {
+	pool := *txpool.DefaultConfig
+	pool.PriceLimit = 0
+	pool.NoSignatureCheck = true
+	return &nodeConfig{Trie: trie.DefaultConfig, TxPool: &pool, Ephemeral: true}
+}
+
+// 多个临时节点各自使用内存数据库，互不影响
+func TestEphemeralNodesAreIsolated(t *testing.T) {
+	var (
+		sender    = testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+		recipient = types.Address{0x42}
+		nodes     = make([]*node, 3)
+	)
+	for i := range nodes {
+		n, err := initNode(testNodeConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = n
+	}
+	// 每个节点收到一笔金额不同的交易
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		n.blockchain.Txpool.NewTx(types.NewTransaction(1, recipient, sender, uint256.NewInt(uint64(10*(i+1))), 21000, testGasPrice, nil))
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			n.createBlock()
+		}(n)
+	}
+	wg.Wait()
+
+	for i, n := range nodes {
+		if height := n.blockchain.CurrentHeader.Height; height != 1 {
+			t.Fatalf("node %d: height mismatch: have %d, want 1", i, height)
+		}
+		account, err := n.blockchain.Statedb.Load(recipient)
+		if err != nil {
+			t.Fatalf("node %d: recipient not found: %v", i, err)
+		}
+		if want := uint64(10 * (i + 1)); account.Amount.Uint64() != want {
+			t.Fatalf("node %d: balance mismatch: have %d, want %d", i, account.Amount.Uint64(), want)
+		}
+	}
+}
+
+// gas limit 放不进区块剩下空间的交易被跳过，后面的交易照样打包
+func TestLargeTxDoesNotStallBlock(t *testing.T) {
+	n, err := initNode(testNodeConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		small     = types.Address{0x01}
+		large     = types.Address{0x02}
+		dev       = testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+		recipient = types.Address{0x42}
+	)
+	//价格一样，按地址顺序打包：small、large、dev
+	for _, addr := range []types.Address{small, large} {
+		n.blockchain.Statedb.Store(addr, types.Account{Amount: *uint256.NewInt(devAccountBalance)})
+	}
+	root, err := n.blockchain.Statedb.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.blockchain.Txpool.SetStatRoot(root)
+	txs := []*types.Transaction{
+		types.NewTransaction(1, recipient, small, uint256.NewInt(1), 21000, testGasPrice, nil),
+		types.NewTransaction(1, recipient, large, uint256.NewInt(1), blockchain.BlockGasLimit, testGasPrice, nil),
+		types.NewTransaction(1, recipient, dev, uint256.NewInt(1), 21000, testGasPrice, nil),
+	}
+	for _, tx := range txs {
+		if err := n.blockchain.Txpool.NewTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n.createBlock()
+
+	body := n.blockchain.GetBody(1)
+	if body == nil {
+		t.Fatal("block 1 not found")
+	}
+	//最后一笔是出块奖励
+	if have := len(body.Transactions); have != 3 {
+		t.Fatalf("transaction count mismatch: have %d, want 3", have)
+	}
+	for i, want := range []*types.Transaction{txs[0], txs[2]} {
+		if body.Transactions[i].Hash() != want.Hash() {
+			t.Fatalf("tx %d mismatch: have %x, want %x", i, body.Transactions[i].Hash(), want.Hash())
+		}
+	}
+}
+
+// 交易池拒绝交易的原因返回给客户端
+func TestTransactionRejectionForwarded(t *testing.T) {
+	n, err := initNode(testNodeConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(nonce uint64, value uint64) TransactionResponse {
+		server, client := net.Pipe()
+		defer client.Close()
+		request, _ := json.Marshal(TransactionData{
+			From:  "0x9B682e9770C315f43954e37D8880a6Be815A3E53",
+			To:    "0x6c8E523FC59529765Ea6A3Bf0cC18AFFc171e484",
+			Nonce: nonce,
+			Value: uint256.NewInt(value),
+			Gas:   21000,
+		})
+		go func() {
+			n.handleTransactionRequest(server, string(request))
+			server.Close()
+		}()
+		var response TransactionResponse
+		if err := json.NewDecoder(client).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+	if resp := send(0, 1); resp.Error != txpool.ErrNonceTooLow.Error() {
+		t.Fatalf("have %q, want %q", resp.Error, txpool.ErrNonceTooLow)
+	}
+	if resp := send(1, devAccountBalance+1); resp.Error != txpool.ErrInsufficientFunds.Error() {
+		t.Fatalf("have %q, want %q", resp.Error, txpool.ErrInsufficientFunds)
+	}
+	if resp := send(1, 1); resp.Error != "" || resp.Hash == "" {
+		t.Fatalf("valid tx rejected: %+v", resp)
+	}
+	if resp := send(1, 1); resp.Error != txpool.ErrAlreadyKnown.Error() {
+		t.Fatalf("have %q, want %q", resp.Error, txpool.ErrAlreadyKnown)
+	}
+}
+
+// To 为空的请求创建合约，返回的地址上部署了初始化代码的返回值
+func TestContractCreationRequest(t *testing.T) {
+	n, err := initNode(testNodeConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+	request, _ := json.Marshal(TransactionData{
+		From:     hexutil.Encode(sender[:]),
+		Nonce:    1,
+		Gas:      100000,
+		GasPrice: testGasPrice,
+		Input:    "0x600a600c600039600a6000f3602a60005260206000f3",
+	})
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		n.handleTransactionRequest(server, string(request))
+		server.Close()
+	}()
+	var response TransactionResponse
+	if err := json.NewDecoder(client).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	want := types.CreateAddress(sender, 1)
+	if response.Error != "" || response.ContractAddress != hexutil.Encode(want[:]) {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+
+	n.createBlock()
+	code, err := n.blockchain.Statedb.GetCode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hexutil.Encode(code) != "0x602a60005260206000f3" {
+		t.Fatalf("code mismatch: have %x", code)
+	}
+}
+
+// 只有本机连接提交的 local 交易才是本地交易，远程连接带上 local 仍然受最低价格限制
+func TestLocalFlagRequiresLoopback(t *testing.T) {
+	config := testNodeConfig()
+	config.TxPool.PriceLimit = 1
+	n, err := initNode(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(nonce uint64) string {
+		data, _ := json.Marshal(TransactionData{
+			From:  "0x9B682e9770C315f43954e37D8880a6Be815A3E53",
+			To:    "0x6c8E523FC59529765Ea6A3Bf0cC18AFFc171e484",
+			Nonce: nonce,
+			Value: uint256.NewInt(1),
+			Gas:   21000,
+			Local: true,
+		})
+		return string(data)
+	}
+	send := func(conn net.Conn, request string) TransactionResponse {
+		if _, err := fmt.Fprintln(conn, request); err != nil {
+			t.Fatal(err)
+		}
+		var response TransactionResponse
+		if err := json.NewDecoder(conn).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	//net.Pipe 的地址不是 loopback，相当于远程连接
+	server, client := net.Pipe()
+	go n.handleConnection(server)
+	if resp := send(client, request(1)); resp.Error != txpool.ErrUnderpriced.Error() {
+		t.Fatalf("remote local tx: have %+v, want %q", resp, txpool.ErrUnderpriced)
+	}
+	client.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			n.handleConnection(conn)
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if resp := send(conn, request(1)); resp.Error != "" {
+		t.Fatalf("loopback local tx rejected: %+v", resp)
+	}
+}
+
+// 出块之后 base fee 的部分被销毁：矿工只得到出块奖励和小费，总余额减少的正好是 GasUsed*BaseFee
+func TestBaseFeeBurned(t *testing.T) {
+	var (
+		sender    = testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+		recipient = types.Address{0x42}
+		minter    = testAddress("0x6c8E523FC59529765Ea6A3Bf0cC18AFFc171e484")
+		tip       = uint64(100)
+		n         = newTestNode(t)
+	)
+	var burned, tips uint64
+	for i := uint64(1); i <= 3; i++ {
+		n.blockchain.Txpool.NewTx(types.NewDynamicFeeTransaction(i, recipient, sender, uint256.NewInt(10), 21000, 2*testGasPrice, tip, nil))
+		n.createBlock()
+		header := n.blockchain.GetHeaderByNumber(i)
+		if header == nil || header.GasUsed != 21000 {
+			t.Fatalf("block %d: transaction not included: %+v", i, header)
+		}
+		if header.BaseFee == 0 {
+			t.Fatalf("block %d: base fee is 0", i)
+		}
+		burned += header.GasUsed * header.BaseFee
+		tips += header.GasUsed * tip
+	}
+
+	balance := func(addr types.Address) uint64 {
+		account, err := n.blockchain.Statedb.Load(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return account.Amount.Uint64()
+	}
+	if have, want := balance(minter), 3*blockchain.BlockReward+tips; have != want {
+		t.Fatalf("minter balance mismatch: have %d, want %d", have, want)
+	}
+	if have, want := balance(recipient), uint64(30); have != want {
+		t.Fatalf("recipient balance mismatch: have %d, want %d", have, want)
+	}
+	total := balance(sender) + balance(recipient) + balance(minter)
+	if want := devAccountBalance + 3*blockchain.BlockReward - burned; total != want {
+		t.Fatalf("total balance mismatch: have %d, want %d (burned %d)", total, want, burned)
+	}
+}
@@ -2,16 +2,18 @@ package maker
 
 import (
 	"blockchain/blockchain"
+	"blockchain/statdb"
 	"blockchain/statemachine"
-	"blockchain/trie"
 	"blockchain/txpool"
 	"blockchain/types"
-	"blockchain/utils/hexutil"
+	"blockchain/utils/math"
 	"blockchain/utils/xtime"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/holiman/uint256"
 )
 
 const (
@@ -28,18 +30,22 @@ type ChainConfig struct {
 	Duration   time.Duration
 	Coinbase   types.Address
 	Difficulty uint64
+	GasLimit   uint64 //一个区块里所有交易最多消耗的gas
 }
 
 type BlockMaker struct {
-	txpool *txpool.DefaultPool
-	state  *trie.State
-	exec   *statemachine.StateMachine
+	txpool txpool.TxPool
+	state  statdb.JournaledStatDB
+	exec   statemachine.IMachine
 
 	config ChainConfig
 	chain  *blockchain.Blockchain
 
 	nextHeader *blockchain.Header
 	nextBody   *blockchain.Body
+	gasUsed    uint64
+	fees       *uint256.Int //已经打包的交易给矿工的小费
+	txs        *txpool.TxsByPriceAndNonce
 
 	interupt chan bool
 }
@@ -48,30 +54,35 @@ func (maker *BlockMaker) InitMakerConfig() {
 	maker.config = ChainConfig{
 		Duration:   1 * time.Second,
 		Coinbase:   types.Address{},
-		Difficulty: 2,
+		Difficulty: blockchain.Difficulty,
+		GasLimit:   blockchain.BlockGasLimit,
 	}
 
 }
 
-func NewBlockMaker(state *trie.State, exec *statemachine.StateMachine, chain *blockchain.Blockchain) *BlockMaker {
+func NewBlockMaker(state statdb.StatDB, exec statemachine.IMachine, chain *blockchain.Blockchain) *BlockMaker {
 	return &BlockMaker{
-		txpool: chain.Txpool,
-		state:  state,
-		exec:   exec,
-		chain:  chain,
+		txpool:   chain.Txpool,
+		state:    statdb.NewJournal(state),
+		exec:     exec,
+		chain:    chain,
+		interupt: make(chan bool, 1),
 	}
 }
 
 func (maker *BlockMaker) NewBlock() {
 	maker.nextBody = blockchain.NewBlockBody()
-	maker.nextHeader = blockchain.NewHeader(maker.chain.CurrentHeader)
+	maker.nextHeader = blockchain.NewHeader(*maker.chain.CurrentBlock())
+	maker.gasUsed = 0
+	maker.fees = new(uint256.Int)
+	maker.txs = txpool.NewTxsByPriceAndNonce(maker.txpool.Pending(), maker.nextHeader.BaseFee)
 	maker.InitMakerConfig()
 	maker.nextHeader.Coinbase = maker.config.Coinbase
 }
 
-func (maker *BlockMaker) Pack() uint64 {
+// Pack 在 Duration 之内从交易池打包交易，返回这些交易给矿工的小费
+func (maker *BlockMaker) Pack() *uint256.Int {
 	end := time.After(maker.config.Duration)
-	var totalgas uint64 = 0
 Loop:
 	for {
 		select {
@@ -81,53 +92,79 @@ Loop:
 		case <-end:
 			break Loop
 		default:
-			totalgas += maker.pack()
+			maker.pack()
 		}
 	}
-	//更新区块链状态树
-	maker.nextHeader.Root = maker.state.Root()
-	return uint64(totalgas)
+	return maker.fees
 }
-func (maker *BlockMaker) pack() uint64 {
+func (maker *BlockMaker) pack() {
 	mutex.Lock()
 	defer mutex.Unlock()
-	tx := maker.txpool.Pop()
+	tx := maker.txs.Peek()
 	if tx != nil {
-		receiption, gasUsed := maker.exec.Execute(maker.state, tx)
+		//交易池可能还没有删掉上一个区块打包的交易
+		if account, _ := maker.state.Load(tx.From()); tx.Nonce() <= account.Nonce {
+			maker.txs.Shift()
+			return
+		}
+		//区块剩下的gas不够这笔交易的 gas limit，跳过这个账户继续打包，交易还在交易池里，留给下一个区块。
+		//不能停在这里，否则一笔 gas limit 很大的交易会让之后的区块都是空的
+		if tx.Gas > maker.config.GasLimit-maker.gasUsed {
+			maker.txs.Pop()
+			return
+		}
+		snapshot := maker.state.Snapshot()
+		receiption, fee := maker.exec.Execute(maker.state, maker.nextHeader, tx)
 		if receiption == nil {
+			//这个账户后面的交易也执行不了
+			maker.txs.Pop()
 			fmt.Println(Red + "Tx execute failed.")
 			fmt.Printf(Reset)
-			return 0
+			return
+		}
+		gasUsed, overflow := math.SafeAdd(maker.gasUsed, receiption.GasUsed)
+		fees, feeOverflow := math.SafeAddU256(maker.fees, fee)
+		if overflow || feeOverflow || gasUsed > maker.config.GasLimit {
+			//小费累加溢出，或者区块放不下了（前面按 gas limit 检查过，这里只是兜底），撤销这笔交易，它还在交易池里，留给下一个区块
+			maker.state.RevertToSnapshot(snapshot)
+			maker.Interupt()
+			return
+		}
+		maker.txs.Shift()
+		maker.gasUsed = gasUsed
+		maker.fees = fees
+		if receiption.Status == types.ReceiptStatusFailed {
+			fmt.Println(Yellow + "The transaction failed and has been reverted, gas is still charged.")
+		} else {
+			fmt.Println(Green + "The transaction has been executed successfully!")
 		}
-		fmt.Println(Green + "The transaction has been executed successfully!")
 		fmt.Printf(Reset)
 		maker.nextBody.Transactions = append(maker.nextBody.Transactions, *tx)
 		maker.nextBody.Receiptions = append(maker.nextBody.Receiptions, *receiption)
 		if len(maker.nextBody.Transactions) >= 10 {
 			maker.Interupt()
 		}
-		return gasUsed
 	} else {
 		//fmt.Println(Yellow + "Txpool is empty, waiting for transactions.")
 		fmt.Printf(Reset)
-		return 0
 	}
 }
 
 func (maker *BlockMaker) Interupt() {
-	maker.interupt <- true
+	select {
+	case maker.interupt <- true:
+	default:
+	}
 }
 
 func (maker *BlockMaker) Mint() (*blockchain.Header, *blockchain.Body) {
 	maker.nextHeader.Timestamp = xtime.Now()
 	maker.nextHeader.Nonce = 0
 	diff := maker.config.Difficulty
-	zeroPrefix := strings.Repeat("0", int(diff))
-	fmt.Println("Mining difficulty:", zeroPrefix)
+	fmt.Println("Mining difficulty:", strings.Repeat("0", int(diff)))
 	for n := 0; ; n++ {
 		maker.nextHeader.Nonce = uint64(n)
-		hash := maker.nextHeader.Hash().String()[2 : diff+2]
-		if hash == zeroPrefix {
+		if blockchain.CheckPoW(maker.nextHeader, diff) {
 			fmt.Println(Green+"Mining successful:", maker.nextHeader.Hash().String())
 			fmt.Printf(Reset)
 			break
@@ -140,9 +177,25 @@ func (maker *BlockMaker) PackAndMint(minter types.Address) bool {
 	maker.NewBlock()
 	fmt.Println("Packing...")
 	minterReward := maker.Pack()
-	maker.addMinterTx(minter, minterReward)
+	if !maker.addMinterTx(minter, minterReward) {
+		return false
+	}
 	fmt.Printf(Reset)
+	maker.nextHeader.GasUsed = maker.gasUsed
+	root, err := maker.chain.StateRoot(maker.state)
+	if err != nil {
+		fmt.Println(Red+"Compute state root failed:", err)
+		fmt.Printf(Reset)
+		return false
+	}
+	maker.nextHeader.Root = root
 	header, body := maker.Mint()
+	//整个区块的状态修改和区块数据一次性写入数据库
+	if err := maker.chain.InsertBlock(header, body, maker.state); err != nil {
+		fmt.Println(Red+"Insert block failed:", err)
+		fmt.Printf(Reset)
+		return false
+	}
 	fmt.Println("|--------------------------------------------------------------------------------------------------|")
 	fmt.Println("|block data:                                                                                       |")
 	fmt.Println("|--------------------------------------------------------------------------------------------------|")
@@ -155,23 +208,17 @@ func (maker *BlockMaker) PackAndMint(minter types.Address) bool {
 		fmt.Printf("|	Transaction %d:%s\n", i, tx.Hash().String())
 	}
 	fmt.Println("|--------------------------------------------------------------------------------------------------")
-	maker.chain.CurrentHeader = *header
 	return true
 
 }
 
-func (maker *BlockMaker) addMinterTx(minter types.Address, minterReward uint64) bool {
-	var inputString string = "minter reward"
-	input, _ := hexutil.Decode(inputString)
-	var addr types.Address
-	tx := types.NewTransaction(0, addr, minter, 50, 0, 0, input)
-	toAccount, err := maker.state.Load(minter)
-	if err != nil {
-		toAccount = types.Account{}
+func (maker *BlockMaker) addMinterTx(minter types.Address, minterReward *uint256.Int) bool {
+	tx := blockchain.NewRewardTx(minter)
+	if err := blockchain.ApplyReward(maker.state, minter, minterReward); err != nil {
+		fmt.Println(Red+"Apply minter reward failed:", err)
+		fmt.Printf(Reset)
+		return false
 	}
-
-	toAccount.Amount = toAccount.Amount + 50 + minterReward
-	maker.state.Store(minter, toAccount)
 	receiption := &types.Receiption{
 		TxHash: tx.Hash(),
 		Status: 0,
@@ -0,0 +1,154 @@
+package rawdb
+
+import (
+	"blockchain/kvstore"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/rlp"
+	"encoding/binary"
+)
+
+// ReadCanonicalHash 读取某个高度上主链区块的hash
+func ReadCanonicalHash(db kvstore.KeyValueReader, number uint64) hash.Hash {
+	data, _ := db.Get(headerHashKey(number))
+	return hash.BytesToHash(data)
+}
+
+func WriteCanonicalHash(db kvstore.KeyValueWriter, h hash.Hash, number uint64) error {
+	return db.Put(headerHashKey(number), h[:])
+}
+
+// ReadHeaderNumber 根据区块hash读取高度，不存在时返回nil
+func ReadHeaderNumber(db kvstore.KeyValueReader, h hash.Hash) *uint64 {
+	data, _ := db.Get(headerNumberKey(h))
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+func ReadHeadBlockHash(db kvstore.KeyValueReader) hash.Hash {
+	data, _ := db.Get(headBlockKey)
+	return hash.BytesToHash(data)
+}
+
+func WriteHeadBlockHash(db kvstore.KeyValueWriter, h hash.Hash) error {
+	return db.Put(headBlockKey, h[:])
+}
+
+func ReadHeader(db kvstore.KeyValueReader, h hash.Hash, number uint64) *types.Header {
+	data, err := db.Get(headerKey(number, h))
+	if err != nil {
+		return nil
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(data, header); err != nil {
+		return nil
+	}
+	return header
+}
+
+// WriteHeader 写入区块头以及 hash -> 高度 的索引
+func WriteHeader(db kvstore.KeyValueWriter, header *types.Header) error {
+	h, number := header.Hash(), header.Height
+	data, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		return err
+	}
+	if err := db.Put(headerNumberKey(h), encodeBlockNumber(number)); err != nil {
+		return err
+	}
+	return db.Put(headerKey(number, h), data)
+}
+
+// ReadHeadHeader 读取最新的区块头，数据库里还没有区块时返回nil
+func ReadHeadHeader(db kvstore.KeyValueReader) *types.Header {
+	h := ReadHeadBlockHash(db)
+	if h == (hash.Hash{}) {
+		return nil
+	}
+	number := ReadHeaderNumber(db, h)
+	if number == nil {
+		return nil
+	}
+	return ReadHeader(db, h, *number)
+}
+
+func ReadBody(db kvstore.KeyValueReader, h hash.Hash, number uint64) *types.Body {
+	data, err := db.Get(blockBodyKey(number, h))
+	if err != nil {
+		return nil
+	}
+	body := new(types.Body)
+	if err := rlp.DecodeBytes(data, body); err != nil {
+		return nil
+	}
+	return body
+}
+
+func WriteBody(db kvstore.KeyValueWriter, h hash.Hash, number uint64, body *types.Body) error {
+	data, err := rlp.EncodeToBytes(body)
+	if err != nil {
+		return err
+	}
+	return db.Put(blockBodyKey(number, h), data)
+}
+
+func ReadReceipts(db kvstore.KeyValueReader, h hash.Hash, number uint64) []types.Receiption {
+	data, err := db.Get(blockReceiptsKey(number, h))
+	if err != nil {
+		return nil
+	}
+	var receipts []types.Receiption
+	if err := rlp.DecodeBytes(data, &receipts); err != nil {
+		return nil
+	}
+	return receipts
+}
+
+func WriteReceipts(db kvstore.KeyValueWriter, h hash.Hash, number uint64, receipts []types.Receiption) error {
+	data, err := rlp.EncodeToBytes(receipts)
+	if err != nil {
+		return err
+	}
+	return db.Put(blockReceiptsKey(number, h), data)
+}
+
+// ReadTxLookupEntry 返回交易所在区块的高度，不存在时返回nil
+func ReadTxLookupEntry(db kvstore.KeyValueReader, txHash hash.Hash) *uint64 {
+	data, _ := db.Get(txLookupKey(txHash))
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+func WriteTxLookupEntries(db kvstore.KeyValueWriter, number uint64, txs []types.Transaction) error {
+	enc := encodeBlockNumber(number)
+	for _, tx := range txs {
+		if err := db.Put(txLookupKey(tx.Hash()), enc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBlock 写入区块头、区块体、收据和交易索引，并把它设为主链上这个高度的区块
+func WriteBlock(db kvstore.KeyValueWriter, header *types.Header, body *types.Body) error {
+	h, number := header.Hash(), header.Height
+	if err := WriteHeader(db, header); err != nil {
+		return err
+	}
+	if err := WriteBody(db, h, number, body); err != nil {
+		return err
+	}
+	if err := WriteReceipts(db, h, number, body.Receiptions); err != nil {
+		return err
+	}
+	if err := WriteTxLookupEntries(db, number, body.Transactions); err != nil {
+		return err
+	}
+	return WriteCanonicalHash(db, h, number)
+}
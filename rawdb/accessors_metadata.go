@@ -0,0 +1,30 @@
+package rawdb
+
+import (
+	"blockchain/kvstore"
+	"encoding/binary"
+)
+
+// ReadDatabaseVersion 读取数据库 schema 的版本，没有记录时返回nil
+func ReadDatabaseVersion(db kvstore.KeyValueReader) *uint64 {
+	data, _ := db.Get(databaseVersionKey)
+	if len(data) != 8 {
+		return nil
+	}
+	version := binary.BigEndian.Uint64(data)
+	return &version
+}
+
+func WriteDatabaseVersion(db kvstore.KeyValueWriter, version uint64) error {
+	return db.Put(databaseVersionKey, encodeBlockNumber(version))
+}
+
+// ReadTrieScheme 读取创建数据库时使用的状态 trie 实现，没有记录时返回空字符串
+func ReadTrieScheme(db kvstore.KeyValueReader) string {
+	data, _ := db.Get(trieSchemeKey)
+	return string(data)
+}
+
+func WriteTrieScheme(db kvstore.KeyValueWriter, scheme string) error {
+	return db.Put(trieSchemeKey, []byte(scheme))
+}
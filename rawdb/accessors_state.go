@@ -0,0 +1,27 @@
+package rawdb
+
+import (
+	"blockchain/kvstore"
+	"blockchain/utils/hash"
+)
+
+func ReadTrieNode(db kvstore.KeyValueReader, h hash.Hash) ([]byte, error) {
+	return db.Get(trieNodeKey(h))
+}
+
+func HasTrieNode(db kvstore.KeyValueReader, h hash.Hash) bool {
+	ok, _ := db.Exist(trieNodeKey(h))
+	return ok
+}
+
+func WriteTrieNode(db kvstore.KeyValueWriter, h hash.Hash, node []byte) error {
+	return db.Put(trieNodeKey(h), node)
+}
+
+func ReadCode(db kvstore.KeyValueReader, h hash.Hash) ([]byte, error) {
+	return db.Get(codeKey(h))
+}
+
+func WriteCode(db kvstore.KeyValueWriter, h hash.Hash, code []byte) error {
+	return db.Put(codeKey(h), code)
+}
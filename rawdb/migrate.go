@@ -0,0 +1,83 @@
+package rawdb
+
+import (
+	"blockchain/kvstore"
+	"blockchain/utils/hash"
+	"fmt"
+)
+
+// SchemaVersion 是当前代码使用的数据库 schema 版本
+//
+//	0: 没有前缀，trie 节点和账户数据直接以 keccak hash 为key
+//	1: 所有数据都带前缀
+const SchemaVersion = 1
+
+// migrations[i] 把数据库从版本 i 升级到 i+1
+var migrations = []func(db kvstore.KVDatabase) error{
+	migratePrefixTrieNodes,
+}
+
+// idealBatchSize 迁移时每个 batch 的大小，太大会占用很多内存
+const idealBatchSize = 1 << 20
+
+// Migrate 检查数据库的 schema 版本，按顺序执行需要的迁移，把旧的数据库原地升级到当前版本。
+// 空数据库直接记录为当前版本。
+func Migrate(db kvstore.KVDatabase) error {
+	version := ReadDatabaseVersion(db)
+	if version == nil {
+		if isEmpty(db) {
+			return WriteDatabaseVersion(db, SchemaVersion)
+		}
+		legacy := uint64(0)
+		version = &legacy
+	}
+	if *version > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", *version, SchemaVersion)
+	}
+	for v := *version; v < SchemaVersion; v++ {
+		if err := migrations[v](db); err != nil {
+			return fmt.Errorf("migrate database from version %d: %v", v, err)
+		}
+		if err := WriteDatabaseVersion(db, v+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isEmpty(db kvstore.KVDatabase) bool {
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	return !it.Next()
+}
+
+// migratePrefixTrieNodes 给版本 0 里没有前缀的 trie 节点和账户数据加上 TrieNodePrefix。
+// 版本 0 的数据库里只有这种数据，它们的key都是32字节的hash，带前缀的key长度都不是32。
+func migratePrefixTrieNodes(db kvstore.KVDatabase) error {
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+
+	batch := db.NewBatch()
+	for it.Next() {
+		key := it.Key()
+		if len(key) != hash.HASH_LEN {
+			continue
+		}
+		if err := WriteTrieNode(batch, hash.BytesToHash(key), it.Value()); err != nil {
+			return err
+		}
+		if err := batch.Delete(key); err != nil {
+			return err
+		}
+		if batch.ValueSize() >= idealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
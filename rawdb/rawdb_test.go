@@ -0,0 +1,88 @@
+package rawdb
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/kvstore"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"bytes"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestBlockRoundtrip(t *testing.T) {
+	db := kvstore.NewMemoryDB()
+	tx := types.NewTransaction(1, types.Address{0x2}, types.Address{0x1}, uint256.NewInt(10), 21000, 1, nil)
+	header := &types.Header{Root: hash.Hash{0xaa}, Height: 3, Timestamp: 100}
+	body := &types.Body{
+		Transactions: []types.Transaction{*tx},
+		Receiptions:  []types.Receiption{{TxHash: tx.Hash(), Status: types.ReceiptStatusFailed, GasUsed: 21000}},
+	}
+	if err := WriteBlock(db, header, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteHeadBlockHash(db, header.Hash()); err != nil {
+		t.Fatal(err)
+	}
+
+	head := ReadHeadHeader(db)
+	if head == nil || head.Hash() != header.Hash() {
+		t.Fatalf("head header mismatch: have %+v, want %+v", head, header)
+	}
+	if h := ReadCanonicalHash(db, 3); h != header.Hash() {
+		t.Fatalf("canonical hash mismatch: have %x, want %x", h, header.Hash())
+	}
+	if got := ReadBody(db, header.Hash(), 3); got == nil || len(got.Transactions) != 1 || got.Transactions[0].Hash() != tx.Hash() {
+		t.Fatalf("body mismatch: %+v", got)
+	}
+	receipts := ReadReceipts(db, header.Hash(), 3)
+	if len(receipts) != 1 || receipts[0].Hash() != body.Receiptions[0].Hash() {
+		t.Fatalf("receipts mismatch: %+v", receipts)
+	}
+	if number := ReadTxLookupEntry(db, tx.Hash()); number == nil || *number != 3 {
+		t.Fatalf("tx lookup mismatch: %v", number)
+	}
+}
+
+// 版本 0 的数据库里只有以hash为key的 trie 节点，迁移之后都应该带上前缀
+func TestMigrateLegacyDatabase(t *testing.T) {
+	db := kvstore.NewMemoryDB()
+	legacy := make(map[hash.Hash][]byte)
+	for i := 0; i < 100; i++ {
+		value := []byte{byte(i), 1, 2, 3}
+		h := sha3.Keccak256(value)
+		legacy[h] = value
+		db.Put(h[:], value)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if version := ReadDatabaseVersion(db); version == nil || *version != SchemaVersion {
+		t.Fatalf("version mismatch: have %v, want %d", version, SchemaVersion)
+	}
+	for h, value := range legacy {
+		if ok, _ := db.Exist(h[:]); ok {
+			t.Fatalf("legacy key %x not removed", h)
+		}
+		data, err := ReadTrieNode(db, h)
+		if err != nil || !bytes.Equal(data, value) {
+			t.Fatalf("node %x not migrated: %x %v", h, data, err)
+		}
+	}
+	// 再次打开时不会重复迁移
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != len(legacy)+1 {
+		t.Fatalf("unexpected entries after migration: have %d, want %d", db.Len(), len(legacy)+1)
+	}
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	db := kvstore.NewMemoryDB()
+	WriteDatabaseVersion(db, SchemaVersion+1)
+	if err := Migrate(db); err == nil {
+		t.Fatal("opened a database with a newer schema")
+	}
+}
@@ -0,0 +1,80 @@
+package rawdb
+
+import (
+	"blockchain/utils/hash"
+	"encoding/binary"
+)
+
+// 数据库里所有的key都带有前缀，不同种类的数据互不冲突，也方便按前缀遍历
+var (
+	databaseVersionKey = []byte("DatabaseVersion") //数据库 schema 的版本
+	headBlockKey       = []byte("LastBlock")       //最新区块的hash
+	trieSchemeKey      = []byte("TrieScheme")      //状态 trie 的实现
+
+	headerPrefix       = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
+	headerHashSuffix   = []byte("n") // headerPrefix + num (uint64 big endian) + headerHashSuffix -> hash
+	headerNumberPrefix = []byte("H") // headerNumberPrefix + hash -> num (uint64 big endian)
+
+	blockBodyPrefix     = []byte("b") // blockBodyPrefix + num (uint64 big endian) + hash -> block body
+	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
+
+	txLookupPrefix = []byte("l") // txLookupPrefix + hash -> 交易所在区块的高度
+
+	TrieNodePrefix = []byte("t") // TrieNodePrefix + hash -> trie 节点或者叶子的数据
+	CodePrefix     = []byte("c") // CodePrefix + code hash -> 合约代码
+)
+
+// encodeBlockNumber encodes a block number as big endian uint64
+func encodeBlockNumber(number uint64) []byte {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, number)
+	return enc
+}
+
+func concat(parts ...[]byte) []byte {
+	var key []byte
+	for _, part := range parts {
+		key = append(key, part...)
+	}
+	return key
+}
+
+// headerKey = headerPrefix + num (uint64 big endian) + hash
+func headerKey(number uint64, h hash.Hash) []byte {
+	return concat(headerPrefix, encodeBlockNumber(number), h[:])
+}
+
+// headerHashKey = headerPrefix + num (uint64 big endian) + headerHashSuffix
+func headerHashKey(number uint64) []byte {
+	return concat(headerPrefix, encodeBlockNumber(number), headerHashSuffix)
+}
+
+// headerNumberKey = headerNumberPrefix + hash
+func headerNumberKey(h hash.Hash) []byte {
+	return concat(headerNumberPrefix, h[:])
+}
+
+// blockBodyKey = blockBodyPrefix + num (uint64 big endian) + hash
+func blockBodyKey(number uint64, h hash.Hash) []byte {
+	return concat(blockBodyPrefix, encodeBlockNumber(number), h[:])
+}
+
+// blockReceiptsKey = blockReceiptsPrefix + num (uint64 big endian) + hash
+func blockReceiptsKey(number uint64, h hash.Hash) []byte {
+	return concat(blockReceiptsPrefix, encodeBlockNumber(number), h[:])
+}
+
+// txLookupKey = txLookupPrefix + hash
+func txLookupKey(h hash.Hash) []byte {
+	return concat(txLookupPrefix, h[:])
+}
+
+// trieNodeKey = TrieNodePrefix + hash
+func trieNodeKey(h hash.Hash) []byte {
+	return concat(TrieNodePrefix, h[:])
+}
+
+// codeKey = CodePrefix + hash
+func codeKey(h hash.Hash) []byte {
+	return concat(CodePrefix, h[:])
+}
@@ -0,0 +1,264 @@
+package statdb
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/kvstore"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"bytes"
+	"sort"
+)
+
+// JournaledStatDB 是支持快照和回滚的状态，状态机用它在交易执行失败时撤销修改
+type JournaledStatDB interface {
+	StatDB
+	Snapshot() int
+	RevertToSnapshot(id int)
+	Finalise() error //把缓存写到下层的状态，之前的快照都失效
+}
+
+var _ JournaledStatDB = (*Journal)(nil)
+
+// Journal 在任意 StatDB 外面加一层内存缓存，所有修改先记在缓存里并写一条日志，
+// 回滚时按日志倒序撤销。Finalise 之后缓存才写到下层的 StatDB。
+type Journal struct {
+	db StatDB
+
+	accounts map[types.Address]types.Account
+	storage  map[types.Address]map[hash.Hash]hash.Hash
+	code     map[types.Address][]byte
+
+	entries   []journalEntry
+	revisions []revision
+	nextID    int
+}
+
+type revision struct {
+	id    int
+	index int //快照时日志的长度
+}
+
+// journalEntry 记录一次修改之前的值
+type journalEntry interface {
+	revert(j *Journal)
+}
+
+type (
+	accountChange struct {
+		address types.Address
+		prev    types.Account
+		existed bool //修改之前缓存里是否已经有这个账户
+	}
+	storageChange struct {
+		address types.Address
+		key     hash.Hash
+		prev    hash.Hash
+		existed bool
+	}
+	codeChange struct {
+		address types.Address
+		prev    []byte
+		existed bool
+	}
+)
+
+func (ch accountChange) revert(j *Journal) {
+	if ch.existed {
+		j.accounts[ch.address] = ch.prev
+	} else {
+		delete(j.accounts, ch.address)
+	}
+}
+
+func (ch storageChange) revert(j *Journal) {
+	if ch.existed {
+		j.storage[ch.address][ch.key] = ch.prev
+	} else {
+		delete(j.storage[ch.address], ch.key)
+	}
+}
+
+func (ch codeChange) revert(j *Journal) {
+	if ch.existed {
+		j.code[ch.address] = ch.prev
+	} else {
+		delete(j.code, ch.address)
+	}
+}
+
+func NewJournal(db StatDB) *Journal {
+	j := &Journal{db: db}
+	j.reset()
+	return j
+}
+
+func (j *Journal) reset() {
+	j.accounts = make(map[types.Address]types.Account)
+	j.storage = make(map[types.Address]map[hash.Hash]hash.Hash)
+	j.code = make(map[types.Address][]byte)
+	j.entries = j.entries[:0]
+	j.revisions = j.revisions[:0]
+}
+
+// Copy 复制下层状态和还没有 Finalise 的修改，快照不会被复制
+func (j *Journal) Copy() StatDB {
+	cpy := NewJournal(j.db.Copy())
+	for addr, account := range j.accounts {
+		cpy.accounts[addr] = account
+	}
+	for addr, slots := range j.storage {
+		cpy.storage[addr] = make(map[hash.Hash]hash.Hash, len(slots))
+		for key, value := range slots {
+			cpy.storage[addr][key] = value
+		}
+	}
+	for addr, code := range j.code {
+		cpy.code[addr] = code
+	}
+	return cpy
+}
+
+func (j *Journal) Load(address types.Address) (types.Account, error) {
+	if account, ok := j.accounts[address]; ok {
+		return account, nil
+	}
+	return j.db.Load(address)
+}
+
+func (j *Journal) Store(address types.Address, account types.Account) error {
+	prev, existed := j.accounts[address]
+	j.entries = append(j.entries, accountChange{address, prev, existed})
+	j.accounts[address] = account
+	return nil
+}
+
+func (j *Journal) GetState(address types.Address, key hash.Hash) (hash.Hash, error) {
+	if value, ok := j.storage[address][key]; ok {
+		return value, nil
+	}
+	return j.db.GetState(address, key)
+}
+
+func (j *Journal) SetState(address types.Address, key hash.Hash, value hash.Hash) error {
+	slots, ok := j.storage[address]
+	if !ok {
+		slots = make(map[hash.Hash]hash.Hash)
+		j.storage[address] = slots
+	}
+	prev, existed := slots[key]
+	j.entries = append(j.entries, storageChange{address, key, prev, existed})
+	slots[key] = value
+	return nil
+}
+
+func (j *Journal) GetCode(address types.Address) ([]byte, error) {
+	if code, ok := j.code[address]; ok {
+		return code, nil
+	}
+	return j.db.GetCode(address)
+}
+
+func (j *Journal) SetCode(address types.Address, code []byte) error {
+	prev, existed := j.code[address]
+	j.entries = append(j.entries, codeChange{address, prev, existed})
+	j.code[address] = code
+
+	account, err := j.Load(address)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	account.CodeHash = sha3.Keccak256(code)
+	return j.Store(address, account)
+}
+
+// Snapshot 返回一个快照id，之后可以用 RevertToSnapshot 回到这个时刻
+func (j *Journal) Snapshot() int {
+	id := j.nextID
+	j.nextID++
+	j.revisions = append(j.revisions, revision{id, len(j.entries)})
+	return id
+}
+
+// RevertToSnapshot 撤销快照之后的所有修改，这个快照之后创建的快照都会失效
+func (j *Journal) RevertToSnapshot(id int) {
+	idx := sort.Search(len(j.revisions), func(i int) bool {
+		return j.revisions[i].id >= id
+	})
+	if idx == len(j.revisions) || j.revisions[idx].id != id {
+		panic("revision id cannot be reverted")
+	}
+	index := j.revisions[idx].index
+	for i := len(j.entries) - 1; i >= index; i-- {
+		j.entries[i].revert(j)
+	}
+	j.entries = j.entries[:index]
+	j.revisions = j.revisions[:idx]
+}
+
+// Finalise 把缓存里的修改写到下层的 StatDB，并清空日志，之前的快照都不能再回滚
+func (j *Journal) Finalise() error {
+	//先写账户，再写存储和代码，下层会在写存储和代码的时候更新账户的 Root 和 CodeHash
+	for _, address := range sortedAddresses(j.accounts) {
+		if err := j.db.Store(address, j.accounts[address]); err != nil {
+			return err
+		}
+	}
+	for _, address := range sortedAddresses(j.storage) {
+		slots := j.storage[address]
+		keys := make([]hash.Hash, 0, len(slots))
+		for key := range slots {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(a, b int) bool { return keys[a].Cmp(keys[b]) < 0 })
+		for _, key := range keys {
+			if err := j.db.SetState(address, key, slots[key]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, address := range sortedAddresses(j.code) {
+		if err := j.db.SetCode(address, j.code[address]); err != nil {
+			return err
+		}
+	}
+	j.reset()
+	return nil
+}
+
+// Root 会先 Finalise，所以之前的快照都不能再回滚。
+// Root 没有办法返回 Finalise 的错误，出错时得到的是只写了一部分修改的根，
+// 需要检查错误的调用方应该先调用 Finalise
+func (j *Journal) Root() hash.Hash {
+	j.Finalise()
+	return j.db.Root()
+}
+
+func (j *Journal) SetStatRoot(root hash.Hash) error {
+	j.reset()
+	return j.db.SetStatRoot(root)
+}
+
+func (j *Journal) Commit() (hash.Hash, error) {
+	if err := j.Finalise(); err != nil {
+		return hash.Hash{}, err
+	}
+	return j.db.Commit()
+}
+
+func (j *Journal) CommitBatch(batch kvstore.Batch) (hash.Hash, error) {
+	if err := j.Finalise(); err != nil {
+		return hash.Hash{}, err
+	}
+	return j.db.CommitBatch(batch)
+}
+
+func sortedAddresses[V any](m map[types.Address]V) []types.Address {
+	addrs := make([]types.Address, 0, len(m))
+	for address := range m {
+		addrs = append(addrs, address)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	return addrs
+}
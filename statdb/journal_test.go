@@ -0,0 +1,52 @@
+package statdb
+
+import (
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestJournalRevert(t *testing.T) {
+	db := NewMemoryStatDB()
+	addr := types.Address{0x01}
+	db.Store(addr, types.Account{Amount: *uint256.NewInt(10)})
+	j := NewJournal(db)
+
+	j.Store(addr, types.Account{Amount: *uint256.NewInt(20)})
+	first := j.Snapshot()
+	j.Store(addr, types.Account{Amount: *uint256.NewInt(30)})
+	j.SetState(addr, hash.HexToHash("0x01"), hash.HexToHash("0x02"))
+	second := j.Snapshot()
+	j.Store(types.Address{0x02}, types.Account{Amount: *uint256.NewInt(1)})
+	j.SetCode(addr, []byte{0x60})
+
+	j.RevertToSnapshot(second)
+	if _, err := j.Load(types.Address{0x02}); err != ErrNotFound {
+		t.Fatalf("account created after snapshot still exists: %v", err)
+	}
+	if code, _ := j.GetCode(addr); code != nil {
+		t.Fatalf("code set after snapshot still exists: %x", code)
+	}
+	if value, _ := j.GetState(addr, hash.HexToHash("0x01")); value != hash.HexToHash("0x02") {
+		t.Fatalf("storage before snapshot lost: %x", value)
+	}
+
+	j.RevertToSnapshot(first)
+	if account, _ := j.Load(addr); account.Amount.Uint64() != 20 {
+		t.Fatalf("amount mismatch: have %d, want 20", account.Amount.Uint64())
+	}
+	if value, _ := j.GetState(addr, hash.HexToHash("0x01")); value != (hash.Hash{}) {
+		t.Fatalf("storage not reverted: %x", value)
+	}
+
+	// 修改在 Finalise 之前不会写到下层
+	if account, _ := db.Load(addr); account.Amount.Uint64() != 10 {
+		t.Fatalf("underlying state modified before finalise: %+v", account)
+	}
+	j.Finalise()
+	if account, _ := db.Load(addr); account.Amount.Uint64() != 20 {
+		t.Fatalf("underlying state not updated: %+v", account)
+	}
+}
@@ -0,0 +1,206 @@
+package statdb
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/kvstore"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/rlp"
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var errUnknownRoot = errors.New("unknown state root")
+
+// MemoryStatDB 是完全放在内存里的 StatDB，状态根是所有数据排序后的hash，
+// 不能生成证明，只用于单元测试
+type MemoryStatDB struct {
+	lock      sync.RWMutex
+	current   *memoryState
+	committed *committedStates //副本之间共享，相当于同一个数据库
+}
+
+// committedStates 保存提交过的状态，提交之后不会再被修改
+type committedStates struct {
+	lock   sync.RWMutex
+	states map[hash.Hash]*memoryState
+}
+
+type memoryState struct {
+	accounts map[types.Address]types.Account
+	storage  map[types.Address]map[hash.Hash]hash.Hash
+	code     map[hash.Hash][]byte
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{
+		accounts: make(map[types.Address]types.Account),
+		storage:  make(map[types.Address]map[hash.Hash]hash.Hash),
+		code:     make(map[hash.Hash][]byte),
+	}
+}
+
+func (s *memoryState) copy() *memoryState {
+	cpy := newMemoryState()
+	for addr, account := range s.accounts {
+		cpy.accounts[addr] = account
+	}
+	for addr, slots := range s.storage {
+		cpy.storage[addr] = make(map[hash.Hash]hash.Hash, len(slots))
+		for key, value := range slots {
+			cpy.storage[addr][key] = value
+		}
+	}
+	for h, code := range s.code {
+		cpy.code[h] = code
+	}
+	return cpy
+}
+
+func NewMemoryStatDB() *MemoryStatDB {
+	db := &MemoryStatDB{
+		current:   newMemoryState(),
+		committed: &committedStates{states: make(map[hash.Hash]*memoryState)},
+	}
+	db.committed.states[db.current.root()] = newMemoryState()
+	return db
+}
+
+func (db *MemoryStatDB) Load(address types.Address) (types.Account, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	account, ok := db.current.accounts[address]
+	if !ok {
+		return account, ErrNotFound
+	}
+	return account, nil
+}
+
+func (db *MemoryStatDB) Store(address types.Address, account types.Account) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.current.accounts[address] = account
+	return nil
+}
+
+func (db *MemoryStatDB) GetState(address types.Address, key hash.Hash) (hash.Hash, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.current.storage[address][key], nil
+}
+
+func (db *MemoryStatDB) SetState(address types.Address, key hash.Hash, value hash.Hash) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	slots, ok := db.current.storage[address]
+	if !ok {
+		slots = make(map[hash.Hash]hash.Hash)
+		db.current.storage[address] = slots
+	}
+	slots[key] = value
+	account := db.current.accounts[address]
+	account.Root = db.current.storageRoot(address)
+	db.current.accounts[address] = account
+	return nil
+}
+
+func (db *MemoryStatDB) GetCode(address types.Address) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	account, ok := db.current.accounts[address]
+	if !ok {
+		return nil, nil
+	}
+	return db.current.code[account.CodeHash], nil
+}
+
+func (db *MemoryStatDB) SetCode(address types.Address, code []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	codeHash := sha3.Keccak256(code)
+	db.current.code[codeHash] = code
+	account := db.current.accounts[address]
+	account.CodeHash = codeHash
+	db.current.accounts[address] = account
+	return nil
+}
+
+func (db *MemoryStatDB) Root() hash.Hash {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.current.root()
+}
+
+func (db *MemoryStatDB) SetStatRoot(root hash.Hash) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.committed.lock.RLock()
+	state, ok := db.committed.states[root]
+	db.committed.lock.RUnlock()
+	if !ok {
+		return errUnknownRoot
+	}
+	db.current = state.copy()
+	return nil
+}
+
+func (db *MemoryStatDB) Commit() (hash.Hash, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	root := db.current.root()
+	db.committed.lock.Lock()
+	db.committed.states[root] = db.current.copy()
+	db.committed.lock.Unlock()
+	return root, nil
+}
+
+func (db *MemoryStatDB) Copy() StatDB {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return &MemoryStatDB{
+		current:   db.current.copy(),
+		committed: db.committed,
+	}
+}
+
+// CommitBatch 内存实现没有东西要写盘，和 Commit 一样
+func (db *MemoryStatDB) CommitBatch(batch kvstore.Batch) (hash.Hash, error) {
+	return db.Commit()
+}
+
+// root 把账户按地址排序之后编码再求hash
+func (s *memoryState) root() hash.Hash {
+	addrs := make([]types.Address, 0, len(s.accounts))
+	for addr := range s.accounts {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	entries := make([][]byte, 0, len(addrs)*2)
+	for _, addr := range addrs {
+		entries = append(entries, addr[:], s.accounts[addr].Bytes())
+	}
+	data, _ := rlp.EncodeToBytes(entries)
+	return sha3.Keccak256(data)
+}
+
+func (s *memoryState) storageRoot(address types.Address) hash.Hash {
+	slots := s.storage[address]
+	keys := make([]hash.Hash, 0, len(slots))
+	for key := range slots {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Cmp(keys[j]) < 0
+	})
+	entries := make([][]byte, 0, len(keys)*2)
+	for _, key := range keys {
+		value := slots[key]
+		entries = append(entries, key[:], value[:])
+	}
+	data, _ := rlp.EncodeToBytes(entries)
+	return sha3.Keccak256(data)
+}
@@ -1,12 +1,32 @@
 package statdb
 
 import (
+	"blockchain/kvstore"
 	"blockchain/types"
 	"blockchain/utils/hash"
+	"errors"
 )
 
+var ErrNotFound = errors.New("not found")
+
+// StatDB 是世界状态的抽象，区块打包、状态机、交易池和区块链都只依赖这个接口。
+// trie.State 是基于 trie 的实现，MemoryStatDB 是给测试用的内存实现。
 type StatDB interface {
-	Load(address types.Address) (types.Account, error)
-	Store(address types.Address, account types.Account)
-	SetStatRoot(root hash.Hash)
+	Load(address types.Address) (types.Account, error) //账户不存在时返回 ErrNotFound
+	Store(address types.Address, account types.Account) error
+
+	GetState(address types.Address, key hash.Hash) (hash.Hash, error) //合约存储
+	SetState(address types.Address, key hash.Hash, value hash.Hash) error
+	GetCode(address types.Address) ([]byte, error)
+	SetCode(address types.Address, code []byte) error
+
+	Root() hash.Hash                  //当前状态根
+	SetStatRoot(root hash.Hash) error //切换到某个已经提交过的状态根
+	Commit() (hash.Hash, error)       //把修改写入数据库
+
+	// CommitBatch 把修改放进调用方的 batch，由调用方 Write，用于和区块数据一起原子提交
+	CommitBatch(batch kvstore.Batch) (hash.Hash, error)
+
+	// Copy 返回一个独立的副本，之后两边的修改互不影响，例如交易池用它读取某个区块之后的状态
+	Copy() StatDB
 }
@@ -0,0 +1,57 @@
+package statemachine
+
+import "errors"
+
+var ErrOutOfGas = errors.New("out of gas")
+
+// MaxRefundQuotient 限制退还的gas最多是用掉的 1/5（EIP-3529）
+const MaxRefundQuotient = 5
+
+// GasMeter 记录一笔交易还剩多少gas，以及执行过程中累计的退款（例如清空存储）
+type GasMeter struct {
+	limit  uint64
+	used   uint64
+	refund uint64
+}
+
+func NewGasMeter(limit uint64) *GasMeter {
+	return &GasMeter{limit: limit}
+}
+
+// ConsumeGas 消耗 amount 的gas，不够时用光剩下的gas并返回 ErrOutOfGas
+func (g *GasMeter) ConsumeGas(amount uint64) error {
+	if amount > g.GasLeft() {
+		g.used = g.limit
+		return ErrOutOfGas
+	}
+	g.used += amount
+	return nil
+}
+
+func (g *GasMeter) GasLeft() uint64 {
+	return g.limit - g.used
+}
+
+func (g *GasMeter) GasUsed() uint64 {
+	return g.used
+}
+
+func (g *GasMeter) AddRefund(amount uint64) {
+	g.refund += amount
+}
+
+// SubRefund 撤销之前的退款，例如清空的存储又被写入
+func (g *GasMeter) SubRefund(amount uint64) {
+	if amount > g.refund {
+		amount = g.refund
+	}
+	g.refund -= amount
+}
+
+// Refund 是交易结束时实际退还的gas，不超过用掉的 1/MaxRefundQuotient
+func (g *GasMeter) Refund() uint64 {
+	if limit := g.used / MaxRefundQuotient; g.refund > limit {
+		return limit
+	}
+	return g.refund
+}
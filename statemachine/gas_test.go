@@ -0,0 +1,32 @@
+package statemachine
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGasMeter(t *testing.T) {
+	meter := NewGasMeter(30000)
+	if err := meter.ConsumeGas(21000); err != nil {
+		t.Fatal(err)
+	}
+	if meter.GasLeft() != 9000 || meter.GasUsed() != 21000 {
+		t.Fatalf("left %d used %d", meter.GasLeft(), meter.GasUsed())
+	}
+	// 退款不超过用掉的 1/5
+	meter.AddRefund(10000)
+	if meter.Refund() != 21000/MaxRefundQuotient {
+		t.Fatalf("refund %d, want %d", meter.Refund(), 21000/MaxRefundQuotient)
+	}
+	meter.SubRefund(8000)
+	if meter.Refund() != 2000 {
+		t.Fatalf("refund %d, want 2000", meter.Refund())
+	}
+	// gas 不够时用光所有gas
+	if err := meter.ConsumeGas(9001); !errors.Is(err, ErrOutOfGas) {
+		t.Fatalf("have %v, want %v", err, ErrOutOfGas)
+	}
+	if meter.GasLeft() != 0 || meter.GasUsed() != 30000 {
+		t.Fatalf("left %d used %d after out of gas", meter.GasLeft(), meter.GasUsed())
+	}
+}
@@ -1,12 +1,50 @@
 package statemachine
 
 import (
-	"blockchain/trie"
+	"blockchain/statdb"
 	"blockchain/types"
+	"blockchain/utils/math"
+	"blockchain/vm"
+	"errors"
+
+	"github.com/holiman/uint256"
+)
+
+var errBalanceOverflow = errors.New("balance overflow")
+
+// 交易无效的原因，Call 返回这些错误
+var (
+	ErrNonce             = errors.New("nonce is not the next nonce of the sender")
+	ErrNonceMax          = errors.New("nonce has max value")
+	ErrIntrinsicGas      = errors.New("intrinsic gas too low")
+	ErrInsufficientFunds = errors.New("insufficient funds for gas * price")
+)
+
+const (
+	TxGas                 = 21000 //一笔转账交易需要的gas
+	TxGasContractCreation = 53000 //一笔创建合约的交易需要的gas
+	TxDataZeroGas         = 4     //Input 里每个 0 字节
+	TxDataNonZeroGas      = 16    //Input 里每个非 0 字节
 )
 
+// IntrinsicGas 是交易在执行之前就要付的gas，gas limit 比它小的交易不可能执行成功
+func IntrinsicGas(tx *types.Transaction) uint64 {
+	gas := uint64(TxGas)
+	if tx.To() == nil {
+		gas = TxGasContractCreation
+	}
+	for _, b := range tx.Input() {
+		if b == 0 {
+			gas += TxDataZeroGas
+		} else {
+			gas += TxDataNonZeroGas
+		}
+	}
+	return gas
+}
+
 type IMachine interface {
-	Execute(state trie.ITrie, tx types.Transaction) *types.Receiption
+	Execute(state statdb.JournaledStatDB, header *types.Header, tx *types.Transaction) (*types.Receiption, *uint256.Int)
 }
 
 type StateMachine struct {
@@ -15,39 +53,148 @@ type StateMachine struct {
 func NewStateMachine() *StateMachine {
 	return &StateMachine{}
 }
-func (m StateMachine) Execute(state *trie.State, tx *types.Transaction) (*types.Receiption, uint64) {
+
+// ExecutionResult 是执行一笔交易的结果
+type ExecutionResult struct {
+	UsedGas         uint64        //退款之后实际用掉的gas
+	Err             error         //执行出错，例如 vm.ErrExecutionReverted；交易本身仍然有效，gas费照常扣除
+	ReturnData      []byte        //合约的返回值，REVERT 时是 revert 的数据
+	Logs            []*types.Log  //执行失败时为空
+	ContractAddress types.Address //创建合约的交易部署到的地址
+}
+
+// Failed 表示执行出错，修改都被回滚了
+func (result *ExecutionResult) Failed() bool {
+	return result.Err != nil
+}
+
+// Execute 在 header 这个区块里执行一笔交易，返回收据和给矿工的小费。
+//
+// 执行之前先按 gas limit 扣除全部gas费，然后用 GasMeter 记录固有gas和执行消耗的gas，
+// 执行结束之后没有用完的gas按原价退还，收据里是实际用掉的gas。
+// 发送方每单位gas付 baseFee+小费，baseFee 的部分被销毁，不给任何人。
+//
+// To 为空的交易创建合约：Input 是初始化代码，它的返回值保存为合约的代码，
+// 合约地址由发送方和交易的 nonce 决定，写在收据里，执行失败时收据里也有这个地址。
+//
+// nonce 不是账户的下一个 nonce、GasFeeCap 低于 baseFee、gas limit 不够固有gas或者付不起 gas limit 的交易是无效的，返回nil；
+// 执行失败的交易会回滚执行的修改，但是gas费照常扣除并增加nonce，收据的状态为失败。gas 用光时全部gas都不退还。
+//
+// 余额和金额都是 256 位的，gas费按 256 位计算，所有加减都检查溢出，溢出的交易不能凭空产生余额。
+func (m StateMachine) Execute(state statdb.JournaledStatDB, header *types.Header, tx *types.Transaction) (*types.Receiption, *uint256.Int) {
+	result, fee, err := m.apply(state, header, tx, true)
+	if err != nil {
+		return nil, nil
+	}
+	status := uint64(types.ReceiptStatusSuccessful)
+	if result.Failed() {
+		status = types.ReceiptStatusFailed
+	}
+	receiption := &types.Receiption{
+		TxHash:  tx.Hash(),
+		Status:  status,
+		GasUsed: result.UsedGas,
+		Logs:    result.Logs,
+
+		ContractAddress: result.ContractAddress,
+	}
+	return receiption, fee
+}
+
+// Call 和 Execute 一样执行交易，但是不检查 nonce，直接用账户的下一个 nonce，并返回执行结果。
+// 交易无效时返回错误。用于只读调用和估算gas，调用方负责丢弃状态的修改
+func (m StateMachine) Call(state statdb.JournaledStatDB, header *types.Header, tx *types.Transaction) (*ExecutionResult, error) {
+	result, _, err := m.apply(state, header, tx, false)
+	return result, err
+}
+
+// apply 扣除gas费、执行交易并退还没有用完的gas，返回执行结果和给矿工的小费
+func (m StateMachine) apply(state statdb.JournaledStatDB, header *types.Header, tx *types.Transaction, checkNonce bool) (*ExecutionResult, *uint256.Int, error) {
 	from := tx.From()
-	to := tx.To()
-	value := tx.Value()
-	gasUsed := tx.Gas
-	if gasUsed > 21000 {
-		gasUsed = 21000
+	tip, err := tx.EffectiveGasTip(header.BaseFee)
+	if err != nil {
+		return nil, nil, err
+	}
+	gasPrice := header.BaseFee + tip //tip 不超过 GasFeeCap-baseFee，不会溢出
+	if tx.Gas < IntrinsicGas(tx) {
+		return nil, nil, ErrIntrinsicGas
 	}
 
-	gasUsed = gasUsed * tx.GasPrice()
-	cost := value + gasUsed
 	account, err := state.Load(from)
+	if errors.Is(err, statdb.ErrNotFound) && !checkNonce {
+		account, err = types.Account{}, nil //只读调用可以从不存在的账户发出
+	}
 	if err != nil {
-		return nil, 0
+		return nil, nil, err
 	}
-	if account.Amount < cost {
-		return nil, 0
+	if account.Nonce == ^uint64(0) {
+		return nil, nil, ErrNonceMax
+	}
+	if checkNonce && tx.Nonce() != account.Nonce+1 {
+		return nil, nil, ErrNonce
+	}
+	//先按 gas limit 扣费
+	balance, overflow := math.SafeSubU256(&account.Amount, math.MulU64(tx.Gas, gasPrice))
+	if overflow {
+		return nil, nil, ErrInsufficientFunds
 	}
-
 	account.Nonce = account.Nonce + 1
-	account.Amount = account.Amount - cost
+	account.Amount = *balance
 	state.Store(from, account)
 
-	toAccount, err := state.Load(to)
-	if err != nil {
-		toAccount = types.Account{}
+	meter := NewGasMeter(tx.Gas)
+	meter.ConsumeGas(IntrinsicGas(tx))
+	evm := vm.NewEVM(vm.NewBlockContext(header), vm.TxContext{Origin: from, GasPrice: gasPrice}, state)
+	result := new(ExecutionResult)
+	if tx.To() == nil {
+		result.ContractAddress = types.CreateAddress(from, account.Nonce)
+		result.ReturnData, result.Err = m.create(evm, meter, tx, result.ContractAddress)
+	} else {
+		result.ReturnData, result.Err = m.call(evm, meter, tx)
+	}
+	//出错时 EVM 已经回滚了这笔交易的修改
+	if !result.Failed() {
+		result.Logs = evm.Logs()
 	}
 
-	toAccount.Amount = toAccount.Amount + value
-	state.Store(to, toAccount)
-	receiption := &types.Receiption{
-		TxHash: tx.Hash(),
-		Status: 0,
+	result.UsedGas = meter.GasUsed() - meter.Refund()
+	if err := refundGas(state, from, math.MulU64(tx.Gas-result.UsedGas, gasPrice)); err != nil {
+		return nil, nil, err
+	}
+	return result, math.MulU64(result.UsedGas, tip), nil
+}
+
+// call 执行交易本身：向 To 转账，To 有代码时用 Input 执行合约，消耗的gas和退款记在 meter 上
+func (m StateMachine) call(evm *vm.EVM, meter *GasMeter, tx *types.Transaction) ([]byte, error) {
+	gas := meter.GasLeft()
+	ret, leftOver, err := evm.Call(tx.From(), *tx.To(), tx.Input(), gas, tx.Value())
+	meter.ConsumeGas(gas - leftOver)
+	meter.AddRefund(evm.Refund())
+	return ret, err
+}
+
+// create 执行创建合约的交易：在 address 上运行 Input，把返回值保存为合约的代码
+func (m StateMachine) create(evm *vm.EVM, meter *GasMeter, tx *types.Transaction, address types.Address) ([]byte, error) {
+	gas := meter.GasLeft()
+	ret, leftOver, err := evm.CreateAt(tx.From(), tx.Input(), gas, tx.Value(), address)
+	meter.ConsumeGas(gas - leftOver)
+	meter.AddRefund(evm.Refund())
+	return ret, err
+}
+
+// refundGas 把没有用完的gas费退还给发送方
+func refundGas(state statdb.StatDB, from types.Address, amount *uint256.Int) error {
+	if amount.IsZero() {
+		return nil
+	}
+	account, err := state.Load(from)
+	if err != nil {
+		return err
+	}
+	balance, overflow := math.SafeAddU256(&account.Amount, amount)
+	if overflow {
+		return errBalanceOverflow
 	}
-	return receiption, gasUsed
+	account.Amount = *balance
+	return state.Store(from, account)
 }
@@ -0,0 +1,351 @@
+package statemachine
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/statdb"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/hexutil"
+	"blockchain/utils/math"
+	"bytes"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+var (
+	alice = types.Address{0x01}
+	bob   = types.Address{0x02}
+
+	testHeader = &types.Header{} //base fee 为 0，整个 gas price 都给矿工
+)
+
+func newTestState(accounts map[types.Address]types.Account) *statdb.Journal {
+	db := statdb.NewMemoryStatDB()
+	for address, account := range accounts {
+		db.Store(address, account)
+	}
+	return statdb.NewJournal(db)
+}
+
+func TestExecuteTransfer(t *testing.T) {
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(100000)}})
+
+	tx := types.NewTransaction(1, bob, alice, uint256.NewInt(1000), 21000, 2, nil)
+	receipt, fee := NewStateMachine().Execute(state, testHeader, tx)
+	if receipt == nil || receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("transfer failed: %+v", receipt)
+	}
+	if fee.Uint64() != 42000 {
+		t.Fatalf("gas fee mismatch: have %d, want %d", fee, 42000)
+	}
+	sender, _ := state.Load(alice)
+	if sender.Amount.Uint64() != 100000-1000-42000 || sender.Nonce != 1 {
+		t.Fatalf("unexpected sender: %+v", sender)
+	}
+	recipient, _ := state.Load(bob)
+	if recipient.Amount.Uint64() != 1000 {
+		t.Fatalf("unexpected recipient: %+v", recipient)
+	}
+}
+
+// 付不起gas费的交易无效，不修改状态
+func TestExecuteCannotPayGas(t *testing.T) {
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(100)}})
+	root := state.Root()
+
+	tx := types.NewTransaction(1, bob, alice, uint256.NewInt(1), 21000, 1, nil)
+	if receipt, _ := NewStateMachine().Execute(state, testHeader, tx); receipt != nil {
+		t.Fatal("expected transaction to be rejected")
+	}
+	if state.Root() != root {
+		t.Fatal("rejected transaction modified the state")
+	}
+}
+
+// 付得起gas费但是转账失败，转账被回滚，gas费照样扣除
+func TestExecuteFailedTransferChargesGas(t *testing.T) {
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(30000)}})
+
+	tx := types.NewTransaction(1, bob, alice, uint256.NewInt(10000), 21000, 1, nil)
+	receipt, fee := NewStateMachine().Execute(state, testHeader, tx)
+	if receipt == nil || receipt.Status != types.ReceiptStatusFailed {
+		t.Fatalf("expected failed receipt, have %+v", receipt)
+	}
+	if fee.Uint64() != 21000 {
+		t.Fatalf("gas fee mismatch: have %d, want %d", fee, 21000)
+	}
+	sender, _ := state.Load(alice)
+	if sender.Amount.Uint64() != 30000-21000 || sender.Nonce != 1 {
+		t.Fatalf("unexpected sender: %+v", sender)
+	}
+	if _, err := state.Load(bob); err != statdb.ErrNotFound {
+		t.Fatalf("recipient should not exist, err: %v", err)
+	}
+}
+
+// nonce 必须是账户的下一个 nonce，否则同一笔交易可以被执行两次
+func TestExecuteWrongNonce(t *testing.T) {
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(100000), Nonce: 3}})
+	for _, nonce := range []uint64{3, 5} {
+		tx := types.NewTransaction(nonce, bob, alice, uint256.NewInt(1), 21000, 0, nil)
+		if receipt, _ := NewStateMachine().Execute(state, testHeader, tx); receipt != nil {
+			t.Fatalf("nonce %d: expected transaction to be rejected", nonce)
+		}
+	}
+	tx := types.NewTransaction(4, bob, alice, uint256.NewInt(1), 21000, 0, nil)
+	if receipt, _ := NewStateMachine().Execute(state, testHeader, tx); receipt == nil {
+		t.Fatal("next nonce rejected")
+	}
+}
+
+// baseFee 部分被销毁，只有小费给矿工
+func TestExecuteBaseFee(t *testing.T) {
+	header := &types.Header{BaseFee: 5}
+	tests := []struct {
+		tx   *types.Transaction
+		paid uint64 //发送方付的gas费
+		tip  uint64
+	}{
+		{types.NewDynamicFeeTransaction(1, bob, alice, uint256.NewInt(0), 21000, 10, 2, nil), 21000 * 7, 21000 * 2},
+		{types.NewDynamicFeeTransaction(1, bob, alice, uint256.NewInt(0), 21000, 6, 2, nil), 21000 * 6, 21000 * 1},
+		{types.NewTransaction(1, bob, alice, uint256.NewInt(0), 21000, 8, nil), 21000 * 8, 21000 * 3},
+	}
+	for i, test := range tests {
+		state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(1000000)}})
+		receipt, tip := NewStateMachine().Execute(state, header, test.tx)
+		if receipt == nil {
+			t.Fatalf("test %d: tx rejected", i)
+		}
+		if tip.Uint64() != test.tip {
+			t.Fatalf("test %d: tip mismatch: have %d, want %d", i, tip, test.tip)
+		}
+		if sender, _ := state.Load(alice); sender.Amount.Uint64() != 1000000-test.paid {
+			t.Fatalf("test %d: paid %d, want %d", i, 1000000-sender.Amount.Uint64(), test.paid)
+		}
+	}
+
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(1000000)}})
+	tx := types.NewDynamicFeeTransaction(1, bob, alice, uint256.NewInt(0), 21000, 4, 2, nil)
+	if receipt, _ := NewStateMachine().Execute(state, header, tx); receipt != nil {
+		t.Fatal("tx with fee cap below base fee executed")
+	}
+}
+
+// 先按 gas limit 扣费，没用完的gas退还，收据里是实际用掉的gas
+func TestExecuteRefundsUnusedGas(t *testing.T) {
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(200000)}})
+
+	tx := types.NewTransaction(1, bob, alice, uint256.NewInt(1000), 50000, 2, nil)
+	receipt, fee := NewStateMachine().Execute(state, testHeader, tx)
+	if receipt == nil || receipt.GasUsed != TxGas {
+		t.Fatalf("gas used mismatch: %+v", receipt)
+	}
+	if fee.Uint64() != TxGas*2 {
+		t.Fatalf("fee mismatch: have %d, want %d", fee, TxGas*2)
+	}
+	if sender, _ := state.Load(alice); sender.Amount.Uint64() != 200000-1000-TxGas*2 {
+		t.Fatalf("sender balance mismatch: have %d, want %d", sender.Amount.Uint64(), 200000-1000-TxGas*2)
+	}
+}
+
+// gas limit 不够固有gas或者付不起 gas limit 的交易无效
+func TestExecuteInvalidGas(t *testing.T) {
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(60000)}})
+	root := state.Root()
+	for _, tx := range []*types.Transaction{
+		types.NewTransaction(1, bob, alice, uint256.NewInt(1), TxGas-1, 1, nil),
+		types.NewTransaction(1, bob, alice, uint256.NewInt(1), 100000, 1, nil), //实际只用 21000，但是要先付得起 100000
+	} {
+		if receipt, _ := NewStateMachine().Execute(state, testHeader, tx); receipt != nil {
+			t.Fatalf("tx with gas %d executed", tx.Gas)
+		}
+	}
+	if state.Root() != root {
+		t.Fatal("rejected transaction modified the state")
+	}
+}
+
+// 金额和gas费都按 256 位计算，溢出不能凭空产生余额
+func TestExecuteOverflow(t *testing.T) {
+	// gas*price 超过 64 位，按 64 位计算会回绕成 0
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(100000)}})
+	root := state.Root()
+	tx := types.NewTransaction(1, bob, alice, uint256.NewInt(1), 1<<40, 1<<40, nil)
+	if receipt, _ := NewStateMachine().Execute(state, testHeader, tx); receipt != nil {
+		t.Fatal("tx with overflowing gas fee executed")
+	}
+	if state.Root() != root {
+		t.Fatal("rejected transaction modified the state")
+	}
+
+	// 转账金额超过余额，转账失败
+	tx = types.NewTransaction(1, bob, alice, math.MaxUint256, TxGas, 1, nil)
+	if receipt, _ := NewStateMachine().Execute(state, testHeader, tx); receipt == nil || receipt.Status != types.ReceiptStatusFailed {
+		t.Fatalf("expected failed receipt, have %+v", receipt)
+	}
+	if sender, _ := state.Load(alice); sender.Amount.Uint64() != 100000-TxGas {
+		t.Fatalf("sender balance mismatch: have %d, want %d", sender.Amount.Uint64(), 100000-TxGas)
+	}
+
+	// 收款方的余额溢出，转账失败，收款方的余额不变
+	state = newTestState(map[types.Address]types.Account{
+		alice: {Amount: *uint256.NewInt(100000)},
+		bob:   {Amount: *math.MaxUint256},
+	})
+	tx = types.NewTransaction(1, bob, alice, uint256.NewInt(1), TxGas, 1, nil)
+	if receipt, _ := NewStateMachine().Execute(state, testHeader, tx); receipt == nil || receipt.Status != types.ReceiptStatusFailed {
+		t.Fatalf("expected failed receipt, have %+v", receipt)
+	}
+	if recipient, _ := state.Load(bob); !recipient.Amount.Eq(math.MaxUint256) {
+		t.Fatalf("recipient balance changed: %s", &recipient.Amount)
+	}
+	if sender, _ := state.Load(alice); sender.Amount.Uint64() != 100000-TxGas {
+		t.Fatalf("sender balance mismatch: have %d, want %d", sender.Amount.Uint64(), 100000-TxGas)
+	}
+}
+
+// 交易的 To 有代码时执行合约，合约的存储写进账户的存储，LOG 留下的日志放进收据
+func TestExecuteContract(t *testing.T) {
+	contract := types.Address{0xcc}
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(1000000)}})
+	//SSTORE(1, 0x2a)，然后 LOG1(0, 0, topic 7)
+	state.SetCode(contract, hexutil.MustDecode("0x602a600155600760006000a100"))
+
+	tx := types.NewTransaction(1, contract, alice, uint256.NewInt(5), 100000, 1, []byte{0x01, 0x00})
+	receipt, _ := NewStateMachine().Execute(state, testHeader, tx)
+	if receipt == nil || receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("contract call failed: %+v", receipt)
+	}
+	// 固有gas 21000+16+4，SSTORE 20006，LOG1 750+9
+	if want := uint64(21020 + 20006 + 759); receipt.GasUsed != want {
+		t.Fatalf("gas used mismatch: have %d, want %d", receipt.GasUsed, want)
+	}
+	if v, _ := state.GetState(contract, hash.BytesToHash([]byte{1})); v != hash.BytesToHash([]byte{0x2a}) {
+		t.Fatalf("storage mismatch: %x", v)
+	}
+	if len(receipt.Logs) != 1 || receipt.Logs[0].Address != contract || receipt.Logs[0].Topics[0] != hash.BytesToHash([]byte{7}) {
+		t.Fatalf("unexpected logs: %+v", receipt.Logs)
+	}
+	if account, _ := state.Load(contract); account.Amount.Uint64() != 5 {
+		t.Fatalf("contract balance mismatch: have %d, want 5", account.Amount.Uint64())
+	}
+}
+
+// 合约 REVERT 时转账和存储都被回滚，收据失败并且没有日志，gas 按实际用掉的收
+func TestExecuteContractRevert(t *testing.T) {
+	contract := types.Address{0xcc}
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(1000000)}})
+	//SSTORE(1, 0x2a)，LOG0，然后 REVERT(0, 0)
+	state.SetCode(contract, hexutil.MustDecode("0x602a60015560006000a060006000fd"))
+
+	tx := types.NewTransaction(1, contract, alice, uint256.NewInt(5), 100000, 1, nil)
+	receipt, _ := NewStateMachine().Execute(state, testHeader, tx)
+	if receipt == nil || receipt.Status != types.ReceiptStatusFailed || len(receipt.Logs) != 0 {
+		t.Fatalf("expected failed receipt without logs, have %+v", receipt)
+	}
+	if want := uint64(21000 + 20006 + 381 + 6); receipt.GasUsed != want {
+		t.Fatalf("gas used mismatch: have %d, want %d", receipt.GasUsed, want)
+	}
+	if v, _ := state.GetState(contract, hash.BytesToHash([]byte{1})); v != (hash.Hash{}) {
+		t.Fatalf("reverted storage kept: %x", v)
+	}
+	if account, _ := state.Load(contract); !account.Amount.IsZero() {
+		t.Fatalf("reverted transfer kept: %d", account.Amount.Uint64())
+	}
+	if sender, _ := state.Load(alice); sender.Amount.Uint64() != 1000000-receipt.GasUsed || sender.Nonce != 1 {
+		t.Fatalf("unexpected sender: %+v", sender)
+	}
+}
+
+// To 为空的交易把初始化代码的返回值部署到 CreateAddress(发送方, nonce)，收据里有合约地址
+func TestExecuteContractCreation(t *testing.T) {
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(1000000)}})
+	//初始化代码用 CODECOPY 把后面 10 字节的合约代码复制到内存并返回，合约代码返回 42
+	runtime := hexutil.MustDecode("0x602a60005260206000f3")
+	initCode := append(hexutil.MustDecode("0x600a600c600039600a6000f3"), runtime...)
+
+	tx := types.NewContractCreation(1, alice, uint256.NewInt(5), 100000, 1, initCode)
+	receipt, _ := NewStateMachine().Execute(state, testHeader, tx)
+	if receipt == nil || receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("contract creation failed: %+v", receipt)
+	}
+	want := types.CreateAddress(alice, 1)
+	if receipt.ContractAddress != want {
+		t.Fatalf("contract address mismatch: have %x, want %x", receipt.ContractAddress, want)
+	}
+	// 固有gas 53000+4*4+18*16，初始化代码 24，保存代码 10*200
+	if gas := uint64(53000 + 304 + 24 + 2000); receipt.GasUsed != gas {
+		t.Fatalf("gas used mismatch: have %d, want %d", receipt.GasUsed, gas)
+	}
+	if code, _ := state.GetCode(want); !bytes.Equal(code, runtime) {
+		t.Fatalf("code mismatch: have %x, want %x", code, runtime)
+	}
+	account, _ := state.Load(want)
+	if account.CodeHash != sha3.Keccak256(runtime) || account.Amount.Uint64() != 5 {
+		t.Fatalf("unexpected contract account: %+v", account)
+	}
+	if sender, _ := state.Load(alice); sender.Nonce != 1 {
+		t.Fatalf("sender nonce mismatch: have %d, want 1", sender.Nonce)
+	}
+
+	//部署之后可以调用
+	call := types.NewTransaction(2, want, alice, nil, 100000, 1, nil)
+	if receipt, _ := NewStateMachine().Execute(state, testHeader, call); receipt == nil || receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("call to created contract failed: %+v", receipt)
+	}
+}
+
+// 初始化代码 REVERT 时没有部署代码，但是 nonce 增加了，收据里仍然有合约地址
+func TestExecuteContractCreationRevert(t *testing.T) {
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(1000000)}})
+	tx := types.NewContractCreation(1, alice, uint256.NewInt(5), 100000, 1, hexutil.MustDecode("0x60006000fd"))
+	receipt, _ := NewStateMachine().Execute(state, testHeader, tx)
+	if receipt == nil || receipt.Status != types.ReceiptStatusFailed {
+		t.Fatalf("expected failed receipt, have %+v", receipt)
+	}
+	want := types.CreateAddress(alice, 1)
+	if receipt.ContractAddress != want {
+		t.Fatalf("contract address mismatch: have %x, want %x", receipt.ContractAddress, want)
+	}
+	if code, _ := state.GetCode(want); len(code) != 0 {
+		t.Fatalf("reverted code deployed: %x", code)
+	}
+	if sender, _ := state.Load(alice); sender.Amount.Uint64() != 1000000-receipt.GasUsed || sender.Nonce != 1 {
+		t.Fatalf("unexpected sender: %+v", sender)
+	}
+
+	//固有gas不够 53000 的创建交易无效
+	if receipt, _ := NewStateMachine().Execute(state, testHeader, types.NewContractCreation(2, alice, nil, 52999, 1, nil)); receipt != nil {
+		t.Fatalf("creation below intrinsic gas accepted: %+v", receipt)
+	}
+}
+
+// Call 不检查 nonce，发送方不存在也可以执行，交易无效时返回原因
+func TestCall(t *testing.T) {
+	contract := types.Address{0xcc}
+	state := newTestState(map[types.Address]types.Account{alice: {Amount: *uint256.NewInt(1000000), Nonce: 5}})
+	state.SetCode(contract, hexutil.MustDecode("0x602a60005260206000f3")) //返回 42
+
+	result, err := NewStateMachine().Call(state, testHeader, types.NewTransaction(0, contract, types.Address{0x99}, nil, 100000, 0, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed() || !bytes.Equal(result.ReturnData, hash.BytesToHash([]byte{42}).Bytes()) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	//创建合约的地址用账户的下一个 nonce
+	result, err = NewStateMachine().Call(state, testHeader, types.NewContractCreation(0, alice, nil, 100000, 1, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := types.CreateAddress(alice, 6); result.ContractAddress != want {
+		t.Fatalf("contract address mismatch: have %x, want %x", result.ContractAddress, want)
+	}
+	if _, err := NewStateMachine().Call(state, testHeader, types.NewTransaction(0, contract, alice, nil, 20000, 1, nil)); err != ErrIntrinsicGas {
+		t.Fatalf("have %v, want %v", err, ErrIntrinsicGas)
+	}
+	if _, err := NewStateMachine().Call(state, testHeader, types.NewTransaction(0, contract, alice, nil, 100000, 100, nil)); err != ErrInsufficientFunds {
+		t.Fatalf("have %v, want %v", err, ErrInsufficientFunds)
+	}
+}
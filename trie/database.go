@@ -0,0 +1,306 @@
+package trie
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/kvstore"
+	"blockchain/rawdb"
+	"blockchain/utils/hash"
+	"sync"
+)
+
+const defaultCacheSize = 1 << 16
+
+const (
+	RadixScheme = "radix" //十六进制路径压缩前缀树
+	MPTScheme   = "mpt"   //以太坊 Merkle-Patricia trie
+)
+
+// Config 决定状态 trie 用哪种实现
+type Config struct {
+	Scheme string
+}
+
+var DefaultConfig = &Config{
+	Scheme: RadixScheme,
+}
+
+// nodeRefs 解析一个节点，返回它引用的子节点、按hash单独存储的数据以及内嵌在节点里的值
+type nodeRefs func(data []byte) (children []hash.Hash, blobs []hash.Hash, values [][]byte, err error)
+
+// Database 是 trie 和磁盘之间的缓存层。
+// 修改过的节点和账户数据先放在 dirties 里，Commit 的时候用一个 batch 一次写入磁盘；
+// 读过的节点解码后放进 LRU，避免重复读库和反序列化。
+type Database struct {
+	lock     sync.Mutex
+	disk     kvstore.KVDatabase
+	dirties  map[hash.Hash][]byte
+	flushing map[hash.Hash][]byte //已经交给调用方的 batch，但可能还没有 Write
+	flushed  hash.Hash            //最后一次交给调用方的 batch 里的根
+	codes    map[hash.Hash][]byte //合约代码单独用 CodePrefix 存储，和 dirties 一样等待 Commit
+	oldCodes map[hash.Hash][]byte //和 flushing 一样
+	cleans   *lru
+
+	scheme string
+	refs   nodeRefs
+}
+
+func NewDatabase(disk kvstore.KVDatabase) *Database {
+	return NewDatabaseWithConfig(disk, DefaultConfig)
+}
+
+func NewDatabaseWithConfig(disk kvstore.KVDatabase, config *Config) *Database {
+	db := &Database{
+		disk:    disk,
+		dirties: make(map[hash.Hash][]byte),
+		codes:   make(map[hash.Hash][]byte),
+		cleans:  newLRU(defaultCacheSize),
+		scheme:  config.Scheme,
+	}
+	switch config.Scheme {
+	case MPTScheme:
+		db.refs = mptRefs
+	case RadixScheme, "":
+		db.scheme = RadixScheme
+		db.refs = radixRefs
+	default:
+		panic("unknown trie scheme: " + config.Scheme)
+	}
+	return db
+}
+
+// OpenTrie 按配置的实现打开一棵 trie，MPT 用 keccak256(key) 做路径
+func (db *Database) OpenTrie(root hash.Hash) (ITrie, error) {
+	if db.scheme == MPTScheme {
+		return NewSecureMPT(db, root)
+	}
+	return NewTrie(db, root)
+}
+
+func (db *Database) Scheme() string {
+	return db.scheme
+}
+
+// Node 按hash读取节点，返回的是副本，调用方可以随意修改
+func (db *Database) Node(h hash.Hash) (*TrieNode, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if node, ok := db.cleans.get(h); ok {
+		return node.copy(), nil
+	}
+	data, ok := db.lookup(h)
+	if !ok {
+		var err error
+		data, err = rawdb.ReadTrieNode(db.disk, h)
+		if err != nil {
+			return nil, err
+		}
+	}
+	node, err := TrieNodeFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	db.cleans.add(h, node)
+	return node.copy(), nil
+}
+
+// InsertNode 把节点放进内存，等待 Commit
+func (db *Database) InsertNode(node *TrieNode) hash.Hash {
+	data := node.Bytes()
+	h := sha3.Keccak256(data)
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.dirties[h] = data
+	db.cleans.add(h, node.copy())
+	return h
+}
+
+// Blob 读取按hash存储的原始数据（例如账户）
+func (db *Database) Blob(h hash.Hash) ([]byte, error) {
+	db.lock.Lock()
+	data, ok := db.lookup(h)
+	db.lock.Unlock()
+	if ok {
+		return data, nil
+	}
+	return rawdb.ReadTrieNode(db.disk, h)
+}
+
+// lookup 在还没有落盘的数据里查找，调用时需要持有锁
+func (db *Database) lookup(h hash.Hash) ([]byte, bool) {
+	if data, ok := db.dirties[h]; ok {
+		return data, true
+	}
+	data, ok := db.flushing[h]
+	return data, ok
+}
+
+// InsertBlob 把原始数据放进内存，等待 Commit
+func (db *Database) InsertBlob(h hash.Hash, data []byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.dirties[h] = data
+}
+
+// Code 按hash读取合约代码
+func (db *Database) Code(h hash.Hash) ([]byte, error) {
+	db.lock.Lock()
+	code, ok := db.codes[h]
+	if !ok {
+		code, ok = db.oldCodes[h]
+	}
+	db.lock.Unlock()
+	if ok {
+		return code, nil
+	}
+	return rawdb.ReadCode(db.disk, h)
+}
+
+// InsertCode 把合约代码放进内存，等待 Commit
+func (db *Database) InsertCode(h hash.Hash, code []byte) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.codes[h] = code
+}
+
+// DirtySize 返回还没有写入磁盘的条目数
+func (db *Database) DirtySize() int {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return len(db.dirties)
+}
+
+// LeafCallback 在提交时对每个叶子的数据调用，返回其中引用的子 trie 根和数据hash，
+// 例如账户里的 Root 和 CodeHash，这样账户 trie 提交时可以把存储 trie 和代码一起写入
+type LeafCallback func(value []byte) (roots []hash.Hash, blobs []hash.Hash)
+
+// Commit 把从 root 可达的脏节点（以及叶子指向的数据）用一个 batch 写入磁盘。
+// 同一个区块内被覆盖掉的中间节点不可达，直接丢弃，不会写盘。
+func (db *Database) Commit(root hash.Hash, onleaf LeafCallback) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	batch := db.disk.NewBatch()
+	if err := db.commit(root, batch, onleaf); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	db.dirties = make(map[hash.Hash][]byte)
+	db.flushing = nil
+	db.codes = make(map[hash.Hash][]byte)
+	db.oldCodes = nil
+	return nil
+}
+
+// CommitTo 和 Commit 一样，但是只把数据放进调用方的 batch，由调用方负责 Write，
+// 这样区块、收据、索引和状态可以在一次原子写里提交。
+// 确认写盘之前这些数据一直留在内存里，可以读到；调用方的 Write 失败时，下一次提交会把还需要的数据重新写一遍。
+func (db *Database) CommitTo(batch kvstore.Batch, root hash.Hash, onleaf LeafCallback) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	db.dropFlushed()
+	if err := db.commit(root, batch, onleaf); err != nil {
+		return err
+	}
+	if db.flushing == nil {
+		db.flushing = make(map[hash.Hash][]byte, len(db.dirties))
+	}
+	for h, data := range db.dirties {
+		db.flushing[h] = data
+	}
+	db.dirties = make(map[hash.Hash][]byte)
+	if db.oldCodes == nil {
+		db.oldCodes = make(map[hash.Hash][]byte, len(db.codes))
+	}
+	for h, code := range db.codes {
+		db.oldCodes[h] = code
+	}
+	db.codes = make(map[hash.Hash][]byte)
+	db.flushed = root
+	return nil
+}
+
+// dropFlushed 在上一次 CommitTo 的 batch 确认写盘之后释放 flushing。
+// batch 是原子写入的，它的根在磁盘上就说明整个 batch 都写进去了。调用时需要持有锁
+func (db *Database) dropFlushed() {
+	if db.flushing == nil {
+		return
+	}
+	if rawdb.HasTrieNode(db.disk, db.flushed) {
+		db.flushing = nil
+		db.oldCodes = nil
+	}
+}
+
+func (db *Database) commit(h hash.Hash, batch kvstore.Batch, onleaf LeafCallback) error {
+	data, ok := db.lookup(h)
+	if !ok {
+		return nil //已经在磁盘上了
+	}
+	children, blobs, values, err := db.refs(data)
+	if err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		blob, ok := db.lookup(b)
+		if !ok {
+			continue
+		}
+		if err := rawdb.WriteTrieNode(batch, b, blob); err != nil {
+			return err
+		}
+		values = append(values, blob)
+	}
+	if onleaf != nil {
+		for _, value := range values {
+			roots, blobs := onleaf(value)
+			for _, root := range roots {
+				//子 trie 的叶子不再回调
+				if err := db.commit(root, batch, nil); err != nil {
+					return err
+				}
+			}
+			for _, b := range blobs {
+				if err := db.commitCode(b, batch); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, child := range children {
+		if err := db.commit(child, batch, onleaf); err != nil {
+			return err
+		}
+	}
+	return rawdb.WriteTrieNode(batch, h, data)
+}
+
+func (db *Database) commitCode(h hash.Hash, batch kvstore.Batch) error {
+	code, ok := db.codes[h]
+	if !ok {
+		code, ok = db.oldCodes[h]
+	}
+	if ok {
+		return rawdb.WriteCode(batch, h, code)
+	}
+	return nil
+}
+
+// radixRefs 找出前缀树节点引用的子节点，叶子的 value 是单独按hash存储的数据
+func radixRefs(data []byte) (children []hash.Hash, blobs []hash.Hash, values [][]byte, err error) {
+	node, err := TrieNodeFromBytes(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, child := range node.Children {
+		children = append(children, child.Hash)
+	}
+	if node.Leaf {
+		blobs = append(blobs, node.Value)
+	}
+	return children, blobs, nil, nil
+}
@@ -0,0 +1,74 @@
+package trie
+
+// MPT 的key有三种表示：
+//   - keybytes: 原始的key
+//   - hex: 每个字节拆成两个半字节(nibble)，末尾可以带一个终止符 16，表示这个key指向的是值
+//   - compact: 以太坊黄皮书里的 hex-prefix 编码，用于节点序列化
+
+const terminator = 16
+
+func keybytesToHex(str []byte) []byte {
+	l := len(str)*2 + 1
+	nibbles := make([]byte, l)
+	for i, b := range str {
+		nibbles[i*2] = b / 16
+		nibbles[i*2+1] = b % 16
+	}
+	nibbles[l-1] = terminator
+	return nibbles
+}
+
+// hexToCompact 把 hex key 编码成 hex-prefix 格式，第一个字节的高4位标记奇偶和是否是叶子
+func hexToCompact(hex []byte) []byte {
+	terminatorFlag := byte(0)
+	if hasTerm(hex) {
+		terminatorFlag = 1
+		hex = hex[:len(hex)-1]
+	}
+	buf := make([]byte, len(hex)/2+1)
+	buf[0] = terminatorFlag << 5
+	if len(hex)&1 == 1 {
+		buf[0] |= 1 << 4
+		buf[0] |= hex[0]
+		hex = hex[1:]
+	}
+	decodeNibbles(hex, buf[1:])
+	return buf
+}
+
+func compactToHex(compact []byte) []byte {
+	if len(compact) == 0 {
+		return compact
+	}
+	base := keybytesToHex(compact)
+	// 去掉终止符
+	if base[0] < 2 {
+		base = base[:len(base)-1]
+	}
+	// 偶数长度时跳过两个标记 nibble，奇数时跳过一个
+	chop := 2 - base[0]&1
+	return base[chop:]
+}
+
+func decodeNibbles(nibbles []byte, bytes []byte) {
+	for bi, ni := 0, 0; ni < len(nibbles); bi, ni = bi+1, ni+2 {
+		bytes[bi] = nibbles[ni]<<4 | nibbles[ni+1]
+	}
+}
+
+func commonPrefix(a, b []byte) int {
+	i, length := 0, len(a)
+	if len(b) < length {
+		length = len(b)
+	}
+	for ; i < length; i++ {
+		if a[i] != b[i] {
+			break
+		}
+	}
+	return i
+}
+
+func hasTerm(s []byte) bool {
+	return len(s) > 0 && s[len(s)-1] == terminator
+}
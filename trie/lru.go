@@ -0,0 +1,49 @@
+package trie
+
+import (
+	"blockchain/utils/hash"
+	"container/list"
+)
+
+// lru 是一个固定容量的最近最少使用缓存，保存已经解码的节点
+type lru struct {
+	size  int
+	items map[hash.Hash]*list.Element
+	order *list.List
+}
+
+type lruEntry struct {
+	key  hash.Hash
+	node *TrieNode
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		items: make(map[hash.Hash]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (c *lru) get(key hash.Hash) (*TrieNode, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).node, true
+}
+
+func (c *lru) add(key hash.Hash, node *TrieNode) {
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		elem.Value.(*lruEntry).node = node
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, node: node})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
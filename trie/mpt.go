@@ -0,0 +1,495 @@
+package trie
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/utils/hash"
+	"blockchain/utils/rlp"
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// EmptyRootHash 是空 MPT 的根，即 keccak256(rlp(""))
+var EmptyRootHash = hash.HexToHash("0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+// MPT 是以太坊的十六进制 Merkle-Patricia trie，由 branch(fullNode)、extension/leaf(shortNode) 三种节点组成，
+// 编码方式和以太坊完全一致，所以同样的数据得到的根hash可以和以太坊的实现互相校验。
+// secure 为 true 时用 keccak256(key) 作为路径，和以太坊的状态 trie 一样。
+type MPT struct {
+	root   mptNode
+	db     *Database
+	secure bool
+}
+
+type (
+	mptNode interface {
+		cache() (hashNode, bool)
+	}
+	fullNode struct {
+		Children [17]mptNode //16个分支加上一个值
+		flags    nodeFlag
+	}
+	shortNode struct {
+		Key   []byte
+		Val   mptNode
+		flags nodeFlag
+	}
+	hashNode  []byte
+	valueNode []byte
+)
+
+// nodeFlag 缓存节点的hash，dirty 表示节点还没有写进 Database
+type nodeFlag struct {
+	hash  hashNode
+	dirty bool
+}
+
+func (n *fullNode) cache() (hashNode, bool)  { return n.flags.hash, n.flags.dirty }
+func (n *shortNode) cache() (hashNode, bool) { return n.flags.hash, n.flags.dirty }
+func (n hashNode) cache() (hashNode, bool)   { return nil, true }
+func (n valueNode) cache() (hashNode, bool)  { return nil, true }
+
+func (n *fullNode) copy() *fullNode   { cpy := *n; return &cpy }
+func (n *shortNode) copy() *shortNode { cpy := *n; return &cpy }
+
+// NewMPT 打开一棵以原始key为路径的 MPT
+func NewMPT(db *Database, root hash.Hash) (*MPT, error) {
+	t := &MPT{db: db}
+	if root != EmptyHash && root != EmptyRootHash {
+		n, err := t.resolveHash(root[:])
+		if err != nil {
+			return nil, err
+		}
+		t.root = n
+	}
+	return t, nil
+}
+
+// NewSecureMPT 打开一棵以 keccak256(key) 为路径的 MPT
+func NewSecureMPT(db *Database, root hash.Hash) (*MPT, error) {
+	t, err := NewMPT(db, root)
+	if err != nil {
+		return nil, err
+	}
+	t.secure = true
+	return t, nil
+}
+
+func (t *MPT) hexKey(key []byte) []byte {
+	if t.secure {
+		h := sha3.Keccak256(key)
+		key = h[:]
+	}
+	return keybytesToHex(key)
+}
+
+func (t *MPT) Load(key []byte) ([]byte, error) {
+	value, err := t.get(t.root, t.hexKey(key), 0)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (t *MPT) get(n mptNode, key []byte, pos int) ([]byte, error) {
+	switch n := n.(type) {
+	case nil:
+		return nil, nil
+	case valueNode:
+		return n, nil
+	case *shortNode:
+		if len(key)-pos < len(n.Key) || !bytes.Equal(n.Key, key[pos:pos+len(n.Key)]) {
+			return nil, nil
+		}
+		return t.get(n.Val, key, pos+len(n.Key))
+	case *fullNode:
+		return t.get(n.Children[key[pos]], key, pos+1)
+	case hashNode:
+		child, err := t.resolveHash(n)
+		if err != nil {
+			return nil, err
+		}
+		return t.get(child, key, pos)
+	default:
+		panic(fmt.Sprintf("invalid node: %T", n))
+	}
+}
+
+// Store 写入 key/value，value 为空时删除 key，和以太坊的语义一致
+func (t *MPT) Store(key []byte, value []byte) error {
+	k := t.hexKey(key)
+	if len(value) == 0 {
+		_, n, err := t.delete(t.root, k)
+		if err != nil {
+			return err
+		}
+		t.root = n
+		return nil
+	}
+	_, n, err := t.insert(t.root, k, valueNode(value))
+	if err != nil {
+		return err
+	}
+	t.root = n
+	return nil
+}
+
+func (t *MPT) insert(n mptNode, key []byte, value mptNode) (bool, mptNode, error) {
+	if len(key) == 0 {
+		if v, ok := n.(valueNode); ok {
+			return !bytes.Equal(v, value.(valueNode)), value, nil
+		}
+		return true, value, nil
+	}
+	switch n := n.(type) {
+	case *shortNode:
+		matchlen := commonPrefix(key, n.Key)
+		// key 完全包含了这个节点的路径，继续往下插入
+		if matchlen == len(n.Key) {
+			dirty, nn, err := t.insert(n.Val, key[matchlen:], value)
+			if !dirty || err != nil {
+				return false, n, err
+			}
+			return true, &shortNode{n.Key, nn, newFlag()}, nil
+		}
+		// 在分叉的地方创建一个 branch
+		branch := &fullNode{flags: newFlag()}
+		var err error
+		_, branch.Children[n.Key[matchlen]], err = t.insert(nil, n.Key[matchlen+1:], n.Val)
+		if err != nil {
+			return false, nil, err
+		}
+		_, branch.Children[key[matchlen]], err = t.insert(nil, key[matchlen+1:], value)
+		if err != nil {
+			return false, nil, err
+		}
+		if matchlen == 0 {
+			return true, branch, nil
+		}
+		// 公共前缀变成 extension
+		return true, &shortNode{key[:matchlen], branch, newFlag()}, nil
+
+	case *fullNode:
+		dirty, nn, err := t.insert(n.Children[key[0]], key[1:], value)
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		n = n.copy()
+		n.flags = newFlag()
+		n.Children[key[0]] = nn
+		return true, n, nil
+
+	case nil:
+		return true, &shortNode{key, value, newFlag()}, nil
+
+	case hashNode:
+		rn, err := t.resolveHash(n)
+		if err != nil {
+			return false, nil, err
+		}
+		dirty, nn, err := t.insert(rn, key, value)
+		if !dirty || err != nil {
+			return false, rn, err
+		}
+		return true, nn, nil
+
+	default:
+		panic(fmt.Sprintf("invalid node: %T", n))
+	}
+}
+
+func (t *MPT) delete(n mptNode, key []byte) (bool, mptNode, error) {
+	switch n := n.(type) {
+	case *shortNode:
+		matchlen := commonPrefix(key, n.Key)
+		if matchlen < len(n.Key) {
+			return false, n, nil //不存在
+		}
+		if matchlen == len(key) {
+			return true, nil, nil //整个节点删除
+		}
+		dirty, child, err := t.delete(n.Val, key[len(n.Key):])
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		// 子节点也是 shortNode 的话要合并路径，保证树的形状唯一
+		switch child := child.(type) {
+		case *shortNode:
+			return true, &shortNode{concat(n.Key, child.Key...), child.Val, newFlag()}, nil
+		default:
+			return true, &shortNode{n.Key, child, newFlag()}, nil
+		}
+
+	case *fullNode:
+		dirty, nn, err := t.delete(n.Children[key[0]], key[1:])
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		n = n.copy()
+		n.flags = newFlag()
+		n.Children[key[0]] = nn
+
+		if nn != nil {
+			return true, n, nil
+		}
+		// 检查 branch 是否只剩下一个孩子，是的话退化成 shortNode
+		pos := -1
+		for i, child := range &n.Children {
+			if child != nil {
+				if pos == -1 {
+					pos = i
+				} else {
+					pos = -2
+					break
+				}
+			}
+		}
+		if pos >= 0 {
+			if pos != terminator {
+				child, err := t.resolve(n.Children[pos])
+				if err != nil {
+					return false, nil, err
+				}
+				if child, ok := child.(*shortNode); ok {
+					k := append([]byte{byte(pos)}, child.Key...)
+					return true, &shortNode{k, child.Val, newFlag()}, nil
+				}
+			}
+			return true, &shortNode{[]byte{byte(pos)}, n.Children[pos], newFlag()}, nil
+		}
+		return true, n, nil
+
+	case valueNode:
+		return true, nil, nil
+
+	case nil:
+		return false, nil, nil
+
+	case hashNode:
+		rn, err := t.resolveHash(n)
+		if err != nil {
+			return false, nil, err
+		}
+		dirty, nn, err := t.delete(rn, key)
+		if !dirty || err != nil {
+			return false, rn, err
+		}
+		return true, nn, nil
+
+	default:
+		panic(fmt.Sprintf("invalid node: %T (%v)", n, key))
+	}
+}
+
+func concat(s1 []byte, s2 ...byte) []byte {
+	r := make([]byte, len(s1)+len(s2))
+	copy(r, s1)
+	copy(r[len(s1):], s2)
+	return r
+}
+
+func newFlag() nodeFlag {
+	return nodeFlag{dirty: true}
+}
+
+func (t *MPT) resolve(n mptNode) (mptNode, error) {
+	if n, ok := n.(hashNode); ok {
+		return t.resolveHash(n)
+	}
+	return n, nil
+}
+
+func (t *MPT) resolveHash(n hashNode) (mptNode, error) {
+	data, err := t.db.Blob(hash.BytesToHash(n))
+	if err != nil {
+		return nil, err
+	}
+	return decodeMPTNode(n, data)
+}
+
+// Root 计算根hash，同时把新产生的节点放进 Database，等待 Commit 写盘
+func (t *MPT) Root() hash.Hash {
+	if t.root == nil {
+		return EmptyRootHash
+	}
+	hashed, cached := t.hash(t.root, true)
+	t.root = cached
+	return hash.BytesToHash(hashed.(hashNode))
+}
+
+// hash 返回节点折叠之后的形式（hashNode，或者编码小于32字节时节点本身）以及缓存了hash的原节点
+func (t *MPT) hash(n mptNode, force bool) (mptNode, mptNode) {
+	if h, _ := n.cache(); h != nil {
+		return h, n
+	}
+	switch n := n.(type) {
+	case *shortNode:
+		collapsed, cached := n.copy(), n.copy()
+		collapsed.Key = hexToCompact(n.Key)
+		if _, ok := n.Val.(valueNode); !ok {
+			collapsed.Val, cached.Val = t.hash(n.Val, false)
+		}
+		hashed := t.store(collapsed, force)
+		if hn, ok := hashed.(hashNode); ok {
+			cached.flags = nodeFlag{hash: hn}
+		} else {
+			cached.flags.hash = nil
+		}
+		return hashed, cached
+	case *fullNode:
+		collapsed, cached := n.copy(), n.copy()
+		for i := 0; i < 16; i++ {
+			if n.Children[i] != nil {
+				collapsed.Children[i], cached.Children[i] = t.hash(n.Children[i], false)
+			}
+		}
+		hashed := t.store(collapsed, force)
+		if hn, ok := hashed.(hashNode); ok {
+			cached.flags = nodeFlag{hash: hn}
+		} else {
+			cached.flags.hash = nil
+		}
+		return hashed, cached
+	default:
+		return n, n
+	}
+}
+
+// store 编码折叠后的节点，小于32字节的节点直接内嵌在父节点里，否则按hash存进 Database
+func (t *MPT) store(n mptNode, force bool) mptNode {
+	enc := encodeMPTNode(n)
+	if len(enc) < 32 && !force {
+		return n
+	}
+	h := sha3.Keccak256(enc)
+	t.db.InsertBlob(h, enc)
+	return hashNode(h[:])
+}
+
+func encodeMPTNode(n mptNode) []byte {
+	var data []byte
+	switch n := n.(type) {
+	case *fullNode:
+		items := make([]interface{}, 17)
+		for i, child := range &n.Children {
+			items[i] = encodeRef(child)
+		}
+		data, _ = rlp.EncodeToBytes(items)
+	case *shortNode:
+		data, _ = rlp.EncodeToBytes([]interface{}{n.Key, encodeRef(n.Val)})
+	}
+	return data
+}
+
+func encodeRef(n mptNode) interface{} {
+	switch n := n.(type) {
+	case nil:
+		return []byte{}
+	case hashNode:
+		return []byte(n)
+	case valueNode:
+		return []byte(n)
+	default:
+		return rlp.RawValue(encodeMPTNode(n))
+	}
+}
+
+var errInvalidMPTNode = errors.New("invalid mpt node")
+
+func decodeMPTNode(h hashNode, buf []byte) (mptNode, error) {
+	elems, _, err := rlp.SplitList(buf)
+	if err != nil {
+		return nil, err
+	}
+	count, err := rlp.CountValues(elems)
+	if err != nil {
+		return nil, err
+	}
+	switch count {
+	case 2:
+		kbuf, rest, err := rlp.SplitString(elems)
+		if err != nil {
+			return nil, err
+		}
+		key := compactToHex(kbuf)
+		if hasTerm(key) {
+			val, _, err := rlp.SplitString(rest)
+			if err != nil {
+				return nil, err
+			}
+			return &shortNode{key, valueNode(val), nodeFlag{hash: h}}, nil
+		}
+		child, _, err := decodeRef(rest)
+		if err != nil {
+			return nil, err
+		}
+		return &shortNode{key, child, nodeFlag{hash: h}}, nil
+	case 17:
+		n := &fullNode{flags: nodeFlag{hash: h}}
+		for i := 0; i < 16; i++ {
+			child, rest, err := decodeRef(elems)
+			if err != nil {
+				return nil, err
+			}
+			n.Children[i], elems = child, rest
+		}
+		val, _, err := rlp.SplitString(elems)
+		if err != nil {
+			return nil, err
+		}
+		if len(val) > 0 {
+			n.Children[terminator] = valueNode(val)
+		}
+		return n, nil
+	default:
+		return nil, errInvalidMPTNode
+	}
+}
+
+func decodeRef(buf []byte) (mptNode, []byte, error) {
+	kind, val, rest, err := rlp.Split(buf)
+	if err != nil {
+		return nil, buf, err
+	}
+	switch {
+	case kind == rlp.List:
+		// 内嵌的小节点
+		size := len(buf) - len(rest)
+		n, err := decodeMPTNode(nil, buf[:size])
+		return n, rest, err
+	case kind == rlp.String && len(val) == 0:
+		return nil, rest, nil
+	case kind == rlp.String && len(val) == 32:
+		return hashNode(val), rest, nil
+	default:
+		return nil, nil, errInvalidMPTNode
+	}
+}
+
+// mptRefs 给 Database.Commit 用，找出一个 MPT 节点引用的子节点和叶子值（包括内嵌节点里的）
+func mptRefs(data []byte) (children []hash.Hash, blobs []hash.Hash, values [][]byte, err error) {
+	n, err := decodeMPTNode(nil, data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var walk func(n mptNode)
+	walk = func(n mptNode) {
+		switch n := n.(type) {
+		case *shortNode:
+			walk(n.Val)
+		case *fullNode:
+			for _, child := range &n.Children {
+				walk(child)
+			}
+		case hashNode:
+			children = append(children, hash.BytesToHash(n))
+		case valueNode:
+			values = append(values, n)
+		}
+	}
+	walk(n)
+	return children, nil, values, nil
+}
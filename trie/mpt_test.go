@@ -0,0 +1,209 @@
+package trie
+
+import (
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/hexutil"
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+type mptEntry struct {
+	key, value string
+}
+
+// root 来自以太坊 tests 仓库的 TrieTests（trieanyorder.json / trietest.json），
+// secure 是同样的数据以 keccak256(key) 为路径时的根，和 go-ethereum 的 StateTrie 计算结果一致
+var mptVectors = []struct {
+	name    string
+	entries []mptEntry
+	root    string
+	secure  string
+}{
+	{
+		name:    "singleItem",
+		entries: []mptEntry{{"A", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}},
+		root:    "0x1aec896bbb0488e33c88f5906b2c6b8001df3d984411063b38be7961f9f6c47f",
+		secure:  "0x9aab7327571ab25ae37127f9febd1e18284e7e3220a2381da0672c0bcdbad2b7",
+	},
+	{
+		name:    "dogs",
+		entries: []mptEntry{{"doe", "reindeer"}, {"dog", "puppy"}, {"dogglesworth", "cat"}},
+		root:    "0x8aad789dff2f538bca5d8ea56e8abe10f4c7ba3a5dea95fea4cd6e7c3a1168d3",
+		secure:  "0xd4cd937e4a4368d7931a9cf51686b7e10abb3dce38a39000fd7902a092b64585",
+	},
+	{
+		name:    "puppy",
+		entries: []mptEntry{{"do", "verb"}, {"horse", "stallion"}, {"doge", "coin"}, {"dog", "puppy"}},
+		root:    "0x5991bb8c6514148a29db676a14ac506cd2cd5775ace63c30a4fe457715e9ac84",
+		secure:  "0x29b235a58c3c25ab83010c327d5932bcf05324b7d6b1185e650798034783ca9d",
+	},
+	{
+		name:    "foo",
+		entries: []mptEntry{{"foo", "bar"}, {"food", "bass"}},
+		root:    "0x17beaa1648bafa633cda809c90c04af50fc8aed3cb40d16efbddee6fdf63c4c3",
+		secure:  "0x1385f23a33021025d9e87cca5c66c00de06178807b96a9acc92b7d651ccde842",
+	},
+	{
+		name:    "smallValues",
+		entries: []mptEntry{{"be", "e"}, {"dog", "puppy"}, {"bed", "d"}},
+		root:    "0x3f67c7a47520f79faa29255d2d3c084a7a6df0453116ed7232ff10277a8be68b",
+		secure:  "0x826a4f9f9054a3e980e54b20da992c24fa20467f1ca635115ef4917be66e746f",
+	},
+	{
+		name:    "testy",
+		entries: []mptEntry{{"test", "test"}, {"te", "testy"}},
+		root:    "0x8452568af70d8d140f58d941338542f645fcca50094b20f3c3d8c3df49337928",
+		secure:  "0xaea54fb6c80499674248a462864c420c9d9f3b3d38c879c12425bade1ad76552",
+	},
+	{
+		name:    "hex",
+		entries: []mptEntry{{"0x0045", "0x0123456789"}, {"0x4500", "0x9876543210"}},
+		root:    "0x285505fcabe84badc8aa310e2aae17eddc7d120aabec8a476902c8184b3a3503",
+		secure:  "0xbc11c02c8ab456db0c4d2728b6a2a6210d06f26a2ace4f7d8bdfc72ddf2630ab",
+	},
+	{
+		// 值为空表示删除
+		name: "emptyValues",
+		entries: []mptEntry{
+			{"do", "verb"}, {"ether", "wookiedoo"}, {"horse", "stallion"}, {"shaman", "horse"},
+			{"doge", "coin"}, {"ether", ""}, {"dog", "puppy"}, {"shaman", ""},
+		},
+		root:   "0x5991bb8c6514148a29db676a14ac506cd2cd5775ace63c30a4fe457715e9ac84",
+		secure: "0x29b235a58c3c25ab83010c327d5932bcf05324b7d6b1185e650798034783ca9d",
+	},
+}
+
+func vectorBytes(s string) []byte {
+	if strings.HasPrefix(s, "0x") {
+		return hexutil.MustDecode(s)
+	}
+	return []byte(s)
+}
+
+func newTestMPTDatabase(t testing.TB) *Database {
+	db := newTestLevelDB(t)
+	t.Cleanup(func() { db.Close() })
+	return NewDatabaseWithConfig(db, &Config{Scheme: MPTScheme})
+}
+
+func TestMPTVectors(t *testing.T) {
+	for _, vector := range mptVectors {
+		db := newTestMPTDatabase(t)
+		plain, _ := NewMPT(db, EmptyHash)
+		secure, _ := NewSecureMPT(db, EmptyHash)
+		for _, entry := range vector.entries {
+			plain.Store(vectorBytes(entry.key), vectorBytes(entry.value))
+			secure.Store(vectorBytes(entry.key), vectorBytes(entry.value))
+		}
+		if root := plain.Root(); root != hash.HexToHash(vector.root) {
+			t.Errorf("%s: root mismatch: have %x, want %s", vector.name, root, vector.root)
+		}
+		if root := secure.Root(); root != hash.HexToHash(vector.secure) {
+			t.Errorf("%s: secure root mismatch: have %x, want %s", vector.name, root, vector.secure)
+		}
+	}
+}
+
+func TestMPTEmptyRoot(t *testing.T) {
+	mpt, _ := NewMPT(newTestMPTDatabase(t), EmptyHash)
+	if root := mpt.Root(); root != EmptyRootHash {
+		t.Fatalf("empty root mismatch: have %x, want %x", root, EmptyRootHash)
+	}
+}
+
+// 插入再删除一部分，结果必须和只插入剩下的key一样，并且提交之后可以重新打开
+func TestMPTDeleteAndReopen(t *testing.T) {
+	disk := newTestLevelDB(t)
+	defer disk.Close()
+	db := NewDatabaseWithConfig(disk, &Config{Scheme: MPTScheme})
+
+	r := rand.New(rand.NewSource(1))
+	seen := make(map[string]bool)
+	keys := make([][]byte, 0, 300)
+	for len(keys) < cap(keys) {
+		key := make([]byte, 1+r.Intn(8))
+		r.Read(key)
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			keys = append(keys, key)
+		}
+	}
+	full, _ := NewMPT(db, EmptyHash)
+	partial, _ := NewMPT(db, EmptyHash)
+	for i, key := range keys {
+		full.Store(key, []byte{byte(i), 1})
+	}
+	for i, key := range keys {
+		if i%3 == 0 {
+			full.Store(key, nil)
+		}
+	}
+	for i, key := range keys {
+		if i%3 != 0 {
+			partial.Store(key, []byte{byte(i), 1})
+		}
+	}
+	for i, key := range keys {
+		if i%3 == 0 {
+			partial.Store(key, nil) //删除不存在的key不改变树
+		}
+	}
+	root := full.Root()
+	if root != partial.Root() {
+		t.Fatalf("root depends on history: %x != %x", root, partial.Root())
+	}
+	if err := db.Commit(root, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewMPT(NewDatabaseWithConfig(disk, &Config{Scheme: MPTScheme}), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		value, err := reopened.Load(key)
+		if i%3 == 0 {
+			if err != ErrNotFound {
+				t.Fatalf("deleted key %x still present: %x", key, value)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(value, []byte{byte(i), 1}) {
+			t.Fatalf("key %x: have %x, want %x (%v)", key, value, []byte{byte(i), 1}, err)
+		}
+	}
+}
+
+func TestMPTState(t *testing.T) {
+	disk := newTestLevelDB(t)
+	defer disk.Close()
+	config := &Config{Scheme: MPTScheme}
+
+	state := NewStateWithConfig(disk, EmptyHash, config)
+	addrs := randomAddresses(100)
+	for i, addr := range addrs {
+		state.Store(addr, types.Account{Amount: *uint256.NewInt(uint64(i))})
+	}
+	slot := hash.HexToHash("0x01")
+	state.SetState(addrs[0], slot, hash.HexToHash("0x2a"))
+	root, err := state.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := NewStateWithConfig(disk, root, config)
+	for i, addr := range addrs {
+		account, err := reopened.Load(addr)
+		if err != nil || account.Amount.Uint64() != uint64(i) {
+			t.Fatalf("account %x: have %+v, want amount %d (%v)", addr, account, i, err)
+		}
+	}
+	if value, _ := reopened.GetState(addrs[0], slot); value != hash.HexToHash("0x2a") {
+		t.Fatalf("storage mismatch: have %x", value)
+	}
+}
@@ -0,0 +1,187 @@
+package trie
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/kvstore"
+	"blockchain/statdb"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"errors"
+	"fmt"
+)
+
+var _ statdb.StatDB = (*State)(nil)
+
+// State 是世界状态：一棵以地址为key的账户 trie，每个账户再通过 Account.Root 拥有自己的存储 trie
+type State struct {
+	trie ITrie
+	db   *Database
+}
+
+func NewState(db kvstore.KVDatabase, root hash.Hash) *State {
+	return NewStateWithConfig(db, root, DefaultConfig)
+}
+
+func NewStateWithConfig(db kvstore.KVDatabase, root hash.Hash, config *Config) *State {
+	nodes := NewDatabaseWithConfig(db, config)
+	t, err := nodes.OpenTrie(root)
+	if err != nil {
+		panic(err)
+	}
+	return &State{
+		trie: t,
+		db:   nodes,
+	}
+}
+
+func (state *State) Root() hash.Hash {
+	return state.trie.Root()
+}
+
+// SetStatRoot 把状态切换到一个已经提交过的根，还没有提交的修改会被丢弃
+func (state *State) SetStatRoot(root hash.Hash) error {
+	t, err := state.db.OpenTrie(root)
+	if err != nil {
+		return err
+	}
+	state.trie = t
+	return nil
+}
+
+// Copy 在同一个 Database 上打开当前的根，还没有提交的节点也在 Database 里，所以不需要先提交
+func (state *State) Copy() statdb.StatDB {
+	t, err := state.db.OpenTrie(state.Root())
+	if err != nil {
+		panic(err)
+	}
+	return &State{
+		trie: t,
+		db:   state.db,
+	}
+}
+
+func (state *State) Pri() {
+	if t, ok := state.trie.(*Trie); ok {
+		fmt.Println("Path1:", t.root.Path)
+		fmt.Println("Children2:", t.root.Children)
+	}
+	fmt.Println("ROOT3:", state.Root())
+}
+
+func (state *State) Load(key types.Address) (types.Account, error) {
+	var account types.Account
+	data, err := state.trie.Load(key[:])
+	if err != nil {
+		return account, err
+	}
+	if decoded := types.AccountFromBytes(data); decoded != nil {
+		account = *decoded
+	}
+	return account, nil
+}
+
+func (state *State) Store(key types.Address, account types.Account) error {
+	return state.trie.Store(key[:], account.Bytes())
+}
+
+// storageTrie 打开账户的存储 trie，账户不存在或者还没有存储时返回空树
+func (state *State) storageTrie(account types.Account) (ITrie, error) {
+	return state.db.OpenTrie(account.Root)
+}
+
+// GetState 读取账户存储中 key 对应的值，不存在时返回零值
+func (state *State) GetState(addr types.Address, key hash.Hash) (hash.Hash, error) {
+	var value hash.Hash
+	account, err := state.Load(addr)
+	if errors.Is(err, ErrNotFound) {
+		return value, nil
+	} else if err != nil {
+		return value, err
+	}
+	if account.Root == EmptyHash || account.Root == EmptyRootHash {
+		return value, nil
+	}
+	storage, err := state.storageTrie(account)
+	if err != nil {
+		return value, err
+	}
+	data, err := storage.Load(key[:])
+	if errors.Is(err, ErrNotFound) {
+		return value, nil
+	} else if err != nil {
+		return value, err
+	}
+	copy(value[:], data)
+	return value, nil
+}
+
+// SetState 修改账户存储中 key 对应的值，并更新账户的 Root
+func (state *State) SetState(addr types.Address, key hash.Hash, value hash.Hash) error {
+	account, err := state.Load(addr)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	storage, err := state.storageTrie(account)
+	if err != nil {
+		return err
+	}
+	if err := storage.Store(key[:], value[:]); err != nil {
+		return err
+	}
+	account.Root = storage.Root()
+	return state.Store(addr, account)
+}
+
+// GetCode 读取账户的代码，代码按 CodeHash 存储
+func (state *State) GetCode(addr types.Address) ([]byte, error) {
+	account, err := state.Load(addr)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if account.CodeHash == EmptyHash {
+		return nil, nil
+	}
+	return state.db.Code(account.CodeHash)
+}
+
+// SetCode 保存代码并把账户的 CodeHash 指向它
+func (state *State) SetCode(addr types.Address, code []byte) error {
+	account, err := state.Load(addr)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	codeHash := sha3.Keccak256(code)
+	state.db.InsertCode(codeHash, code)
+	account.CodeHash = codeHash
+	return state.Store(addr, account)
+}
+
+// Commit 把内存中修改过的节点一次性写入数据库，一般每个区块调用一次。
+// 账户引用的存储 trie 和代码也在同一个 batch 里写入。
+func (state *State) Commit() (hash.Hash, error) {
+	root := state.Root()
+	return root, state.db.Commit(root, accountLeaf)
+}
+
+// CommitBatch 把状态的修改写进调用方的 batch，不会调用 Write
+func (state *State) CommitBatch(batch kvstore.Batch) (hash.Hash, error) {
+	root := state.Root()
+	return root, state.db.CommitTo(batch, root, accountLeaf)
+}
+
+func accountLeaf(value []byte) ([]hash.Hash, []hash.Hash) {
+	var roots, blobs []hash.Hash
+	account := types.AccountFromBytes(value)
+	if account == nil {
+		return nil, nil
+	}
+	if account.Root != EmptyHash && account.Root != EmptyRootHash {
+		roots = append(roots, account.Root)
+	}
+	if account.CodeHash != EmptyHash {
+		blobs = append(blobs, account.CodeHash)
+	}
+	return roots, blobs
+}
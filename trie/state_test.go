@@ -0,0 +1,221 @@
+package trie
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/kvstore"
+	"blockchain/rawdb"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"bytes"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func newTestLevelDB(tb testing.TB) *kvstore.LevelDB {
+	db, err := kvstore.NewLevelDB(tb.TempDir())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+func randomAddresses(n int) []types.Address {
+	r := rand.New(rand.NewSource(1))
+	addrs := make([]types.Address, n)
+	for i := range addrs {
+		r.Read(addrs[i][:])
+	}
+	return addrs
+}
+
+func TestStoreCommitReopen(t *testing.T) {
+	db := newTestLevelDB(t)
+	defer db.Close()
+
+	state := NewState(db, EmptyHash)
+	addrs := randomAddresses(500)
+	for i, addr := range addrs {
+		state.Store(addr, types.Account{Amount: *uint256.NewInt(uint64(i)), Nonce: 1})
+	}
+	for i, addr := range addrs {
+		account, err := state.Load(addr)
+		if err != nil {
+			t.Fatalf("load %x before commit: %v", addr, err)
+		}
+		if account.Amount.Uint64() != uint64(i) {
+			t.Fatalf("amount mismatch for %x: have %d, want %d", addr, account.Amount.Uint64(), i)
+		}
+	}
+	if rawdb.HasTrieNode(db, state.Root()) {
+		t.Fatal("root written to disk before commit")
+	}
+	root, err := state.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.db.DirtySize() != 0 {
+		t.Fatalf("dirty nodes left after commit: %d", state.db.DirtySize())
+	}
+
+	reopened := NewState(db, root)
+	if reopened.Root() != root {
+		t.Fatalf("root mismatch: have %x, want %x", reopened.Root(), root)
+	}
+	for i, addr := range addrs {
+		account, err := reopened.Load(addr)
+		if err != nil {
+			t.Fatalf("load %x after reopen: %v", addr, err)
+		}
+		if account.Amount.Uint64() != uint64(i) {
+			t.Fatalf("amount mismatch for %x: have %d, want %d", addr, account.Amount.Uint64(), i)
+		}
+	}
+}
+
+func TestStorageAndCode(t *testing.T) {
+	db := newTestLevelDB(t)
+	defer db.Close()
+
+	state := NewState(db, EmptyHash)
+	addrs := randomAddresses(3)
+	contract, other := addrs[0], addrs[1]
+	state.Store(contract, types.Account{Amount: *uint256.NewInt(10)})
+
+	keys := []hash.Hash{hash.HexToHash("0x01"), hash.HexToHash("0x02"), hash.HexToHash("0xff00")}
+	for i, key := range keys {
+		if err := state.SetState(contract, key, hash.BigToHash(big.NewInt(int64(i+1)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code := []byte{0x60, 0x00, 0x60, 0x00, 0xf3}
+	if err := state.SetCode(contract, code); err != nil {
+		t.Fatal(err)
+	}
+	account, _ := state.Load(contract)
+	if account.Amount.Uint64() != 10 || account.Root == EmptyHash || account.CodeHash != sha3.Keccak256(code) {
+		t.Fatalf("unexpected account after storage writes: %+v", account)
+	}
+	// 没有存储的账户读出来是零值
+	if value, err := state.GetState(other, keys[0]); err != nil || value != (hash.Hash{}) {
+		t.Fatalf("unexpected value for empty account: %x %v", value, err)
+	}
+
+	root, err := state.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened := NewState(db, root)
+	for i, key := range keys {
+		value, err := reopened.GetState(contract, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != hash.BigToHash(big.NewInt(int64(i+1))) {
+			t.Fatalf("slot %x: have %x, want %d", key, value, i+1)
+		}
+	}
+	if value, _ := reopened.GetState(contract, hash.HexToHash("0x03")); value != (hash.Hash{}) {
+		t.Fatalf("unset slot should be zero, have %x", value)
+	}
+	if stored, err := reopened.GetCode(contract); err != nil || !bytes.Equal(stored, code) {
+		t.Fatalf("code mismatch: have %x, want %x (%v)", stored, code, err)
+	}
+}
+
+// 状态和其他数据写进同一个 batch，Write 之前磁盘上什么都没有
+func TestCommitBatch(t *testing.T) {
+	db := newTestLevelDB(t)
+	defer db.Close()
+
+	state := NewState(db, EmptyHash)
+	addr := randomAddresses(1)[0]
+	state.Store(addr, types.Account{Amount: *uint256.NewInt(7)})
+
+	batch := db.NewBatch()
+	batch.Put([]byte("block"), []byte("data"))
+	root, err := state.CommitBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rawdb.HasTrieNode(db, root) {
+		t.Fatal("state written before batch write")
+	}
+	// 还没写盘的时候也能读到
+	if account, err := state.Load(addr); err != nil || account.Amount.Uint64() != 7 {
+		t.Fatalf("account not readable before write: %+v %v", account, err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if account, err := NewState(db, root).Load(addr); err != nil || account.Amount.Uint64() != 7 {
+		t.Fatalf("account not persisted: %+v %v", account, err)
+	}
+}
+
+// batch 没有写进去的时候，节点要留在内存里，下一次提交把它们一起写进去
+func TestCommitBatchNotWritten(t *testing.T) {
+	db := newTestLevelDB(t)
+	defer db.Close()
+
+	state := NewState(db, EmptyHash)
+	addrs := randomAddresses(2)
+	state.Store(addrs[0], types.Account{Amount: *uint256.NewInt(7)})
+	if _, err := state.CommitBatch(db.NewBatch()); err != nil {
+		t.Fatal(err)
+	}
+	//这个 batch 被丢掉了，没有 Write
+	state.Store(addrs[1], types.Account{Amount: *uint256.NewInt(8)})
+	batch := db.NewBatch()
+	root, err := state.CommitBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := NewState(db, root)
+	for i, addr := range addrs {
+		if account, err := reopened.Load(addr); err != nil || account.Amount.Uint64() != uint64(7+i) {
+			t.Fatalf("account %d not persisted: %+v %v", i, account, err)
+		}
+	}
+}
+
+const benchAccounts = 10000
+
+// 每次Store之后都写盘，相当于没有缓存时的行为
+func BenchmarkStoreCommitEach(b *testing.B) {
+	addrs := randomAddresses(benchAccounts)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db := newTestLevelDB(b)
+		state := NewState(db, EmptyHash)
+		b.StartTimer()
+		for j, addr := range addrs {
+			state.Store(addr, types.Account{Amount: *uint256.NewInt(uint64(j))})
+			state.Commit()
+		}
+		b.StopTimer()
+		db.Close()
+	}
+}
+
+// 所有更新在内存中完成，最后一次性提交
+func BenchmarkStoreCommitOnce(b *testing.B) {
+	addrs := randomAddresses(benchAccounts)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db := newTestLevelDB(b)
+		state := NewState(db, EmptyHash)
+		b.StartTimer()
+		for j, addr := range addrs {
+			state.Store(addr, types.Account{Amount: *uint256.NewInt(uint64(j))})
+		}
+		state.Commit()
+		b.StopTimer()
+		db.Close()
+	}
+}
@@ -2,14 +2,11 @@ package trie
 
 import (
 	"blockchain/crypto/sha3"
-	"blockchain/kvstore"
-	"blockchain/types"
+	"blockchain/statdb"
 	"blockchain/utils/hash"
 	"blockchain/utils/hexutil"
 	"blockchain/utils/rlp"
 	"bytes"
-	"errors"
-	"fmt"
 	"math/big"
 	"sort"
 	"strings"
@@ -17,15 +14,20 @@ import (
 
 var EmptyHash = hash.BigToHash(big.NewInt(0))
 
+var ErrNotFound = statdb.ErrNotFound
+
+// ITrie 是底层的 key/value trie，世界状态 State 在它上面按地址组织账户
 type ITrie interface {
-	Store(key []byte, account types.Account) error //key用来存地址，value是账户信息，序列化之后也是byte
-	Root() hash.Hash                               //返回默克尔根hash
-	Load(key []byte) (types.Account, error)        //查询功能
+	Store(key []byte, value []byte) error //key可以是地址或者存储槽，value是序列化之后的数据
+	Root() hash.Hash                      //返回默克尔根hash
+	Load(key []byte) ([]byte, error)      //查询功能
 }
 
-type State struct { //世界状态
-	root *TrieNode
-	db   kvstore.KVDatabase
+// Trie 是按十六进制路径压缩的前缀树，叶子保存 value 的hash，value 本身按hash存储
+type Trie struct {
+	root     *TrieNode
+	rootHash hash.Hash
+	db       *Database
 }
 
 type TrieNode struct {
@@ -62,30 +64,24 @@ func (children Children) Swap(i, j int) {
 	children[i], children[j] = children[j], children[i]
 }
 
-func NewState(db kvstore.KVDatabase, root hash.Hash) *State {
+func NewTrie(db *Database, root hash.Hash) (*Trie, error) {
 	if bytes.Equal(root[:], EmptyHash[:]) {
-
-		state := State{
+		t := &Trie{
 			db:   db,
 			root: NewTrieNode(),
 		}
-		state.SaveTrieNode(*NewTrieNode())
-		return &state
-
-	} else {
-		value, err := db.Get(root[:])
-		if err != nil {
-			panic(err)
-		}
-		node, err := TrieNodeFromBytes(value)
-		if err != nil {
-			panic(err)
-		}
-		return &State{
-			db:   db,
-			root: node,
-		}
+		t.rootHash = t.SaveTrieNode(*NewTrieNode())
+		return t, nil
 	}
+	node, err := db.Node(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Trie{
+		db:       db,
+		root:     node,
+		rootHash: root,
+	}, nil
 }
 
 func NewTrieNode() *TrieNode {
@@ -100,6 +96,15 @@ func TrieNodeFromBytes(data []byte) (*TrieNode, error) {
 
 	return &node, err
 }
+func (node *TrieNode) copy() *TrieNode {
+	cpy := *node
+	if node.Children != nil {
+		cpy.Children = make(Children, len(node.Children))
+		copy(cpy.Children, node.Children)
+	}
+	return &cpy
+}
+
 func (node *TrieNode) Sort() {
 	sort.Sort(node.Children)
 }
@@ -114,102 +119,85 @@ func (node *TrieNode) Hash() hash.Hash {
 	return sha3.Keccak256(data)
 }
 
-func (state *State) Root() hash.Hash {
-	return state.root.Hash()
+func (t *Trie) Root() hash.Hash {
+	return t.rootHash
 }
 
-func (state *State) Pri() {
-
-	fmt.Println("Path1:", state.root.Path)
-	fmt.Println("Children2:", state.root.Children)
-	fmt.Println("ROOT3:", state.Root())
-}
-
-func (state *State) Load(key types.Address) (types.Account, error) {
-	path := hexutil.Encode(key[:])
+func (t *Trie) Load(key []byte) ([]byte, error) {
+	path := hexutil.Encode(key)
 	path = path[2:]
-	paths, hashs := state.FindAncestors(path)
+	paths, hashs := t.FindAncestors(path)
 	matched := strings.Join(paths, "")
-	var account types.Account
 	if strings.EqualFold(path, matched) {
 		lastHash := hashs[len(hashs)-1]
-		leafNode, err := state.LoadTrieNodeByHash(lastHash)
+		leafNode, err := t.LoadTrieNodeByHash(lastHash)
 
 		if err != nil {
-			return account, err
+			return nil, err
 		}
 		if !leafNode.Leaf {
-			return account, errors.New("not found")
+			return nil, ErrNotFound
 		}
-
-		data, err := state.db.Get(leafNode.Value[:])
-		_ = rlp.DecodeBytes(data, &account)
-		return account, err
+		return t.db.Blob(leafNode.Value)
 	} else {
-		return account, errors.New("not found")
+		return nil, ErrNotFound
 	}
 }
 
-func (state *State) LoadTrieNodeByHash(hash hash.Hash) (*TrieNode, error) {
-	data, err := state.db.Get(hash[:])
-	if err != nil {
-		return nil, err
-	}
-	return TrieNodeFromBytes(data)
+func (t *Trie) LoadTrieNodeByHash(hash hash.Hash) (*TrieNode, error) {
+	return t.db.Node(hash)
 }
-func (state *State) SaveTrieNode(node TrieNode) {
-	h := node.Hash()
-	state.db.Put(h[:], node.Bytes())
+func (t *Trie) SaveTrieNode(node TrieNode) hash.Hash {
+	return t.db.InsertNode(&node)
 }
 
 // 自下向上更新trie
-func (state *State) UpdateTrie(node *TrieNode, hashes []hash.Hash) {
-	childHash := node.Hash()
+func (t *Trie) UpdateTrie(node *TrieNode, childHash hash.Hash, hashes []hash.Hash) {
 	childPath := node.Path
 	depth := len(hashes)
 	if depth == 1 {
-		state.root = node
+		t.root = node
+		t.rootHash = childHash
 	}
 
 	for i := depth - 2; i >= 0; i-- { //倒数第二个去找
-		current, _ := state.LoadTrieNodeByHash(hashes[i])
+		current, _ := t.LoadTrieNodeByHash(hashes[i])
 		for key, _ := range current.Children {
-			if strings.Contains(current.Children[key].Path, childPath) {
+			if current.Children[key].Hash == hashes[i+1] { //按旧的hash找到被修改的孩子
 				current.Children[key].Hash = childHash
 				current.Children[key].Path = childPath
-				state.SaveTrieNode(*current)
-				childHash = current.Hash()
+				childHash = t.SaveTrieNode(*current)
 				childPath = current.Path
 				break
 			}
 		}
 		if i == 0 {
-			state.root = current
+			t.root = current
+			t.rootHash = childHash
 		}
 	}
 }
 
-func (state *State) Store(key types.Address, account types.Account) error {
-	value := account.Bytes()
+func (t *Trie) Store(key []byte, value []byte) error {
 	valueHash := sha3.Keccak256(value)
-	state.db.Put(valueHash[:], value)
+	t.db.InsertBlob(valueHash, value)
 	//step1 find all ancients
-	path := hexutil.Encode(key[:])
+	path := hexutil.Encode(key)
 	path = path[2:]
 
-	paths, hashes := state.FindAncestors(path)
+	paths, hashes := t.FindAncestors(path)
 	prefix := strings.Join(paths, "")
 	depth := len(hashes)
-	node, _ := state.LoadTrieNodeByHash(hashes[depth-1])
+	node, _ := t.LoadTrieNodeByHash(hashes[depth-1])
 	if strings.EqualFold(prefix, path) {
 
 		//如果已经存在节点
 		node.Value = valueHash
 
-		state.SaveTrieNode(*node)
+		nodeHash := t.SaveTrieNode(*node)
 
 		//自下向上更新trie
-		state.UpdateTrie(node, hashes)
+		t.UpdateTrie(node, nodeHash, hashes)
 	} else {
 
 		if strings.EqualFold(node.Path, paths[depth-1]) {
@@ -219,21 +207,20 @@ func (state *State) Store(key types.Address, account types.Account) error {
 			leafNode.Leaf = true
 			leafNode.Path = leafPath
 			leafNode.Value = valueHash
-			state.SaveTrieNode(*leafNode)
-			leafHash := leafNode.Hash()
+			leafHash := t.SaveTrieNode(*leafNode)
 			node.Children = append(node.Children, NewChild(leafPath, leafHash))
-			node.Sort()               //完成当前节点的更新
-			state.SaveTrieNode(*node) //最后匹配的节点存下来
+			node.Sort()                       //完成当前节点的更新
+			nodeHash := t.SaveTrieNode(*node) //最后匹配的节点存下来
 
 			//自下向上更新trie
-			state.UpdateTrie(node, hashes)
+			t.UpdateTrie(node, nodeHash, hashes)
 		} else {
 			//不存在节点，但是是分叉
 
 			//第一个孩子
 			lastMatched := paths[len(paths)-1]
 			node.Path = node.Path[len(lastMatched):]
-			state.SaveTrieNode(*node)
+			nodeHash := t.SaveTrieNode(*node)
 
 			prefix := strings.Join(paths, "")
 			leafPath := path[len(prefix):]
@@ -243,27 +230,27 @@ func (state *State) Store(key types.Address, account types.Account) error {
 			leafNode.Leaf = true
 			leafNode.Path = leafPath
 			leafNode.Value = valueHash
-			state.SaveTrieNode(*leafNode)
+			leafHash := t.SaveTrieNode(*leafNode)
 
 			//孩子的父亲
 			newNode := NewTrieNode()
 			newNode.Path = lastMatched
 			newNode.Children = make(Children, 0)
-			newNode.Children = append(newNode.Children, NewChild(node.Path, node.Hash()), NewChild(leafNode.Path, leafNode.Hash()))
+			newNode.Children = append(newNode.Children, NewChild(node.Path, nodeHash), NewChild(leafNode.Path, leafHash))
 			newNode.Sort()
-			state.SaveTrieNode(*newNode)
+			newHash := t.SaveTrieNode(*newNode)
 			//自下向上更新trie
-			state.UpdateTrie(newNode, hashes)
+			t.UpdateTrie(newNode, newHash, hashes)
 		}
 	}
 	return nil
 }
 
-func (state *State) FindAncestors(path string) ([]string, []hash.Hash) { //返回所有的路径值，所有的hash值
-	current := state.root
+func (t *Trie) FindAncestors(path string) ([]string, []hash.Hash) { //返回所有的路径值，所有的hash值
+	current := t.root
 	paths, hashes := make([]string, 0), make([]hash.Hash, 0)
 	paths = append(paths, "")
-	hashes = append(hashes, state.Root())
+	hashes = append(hashes, t.Root())
 	prefix := ""
 	for {
 		flag := false
@@ -275,8 +262,7 @@ func (state *State) FindAncestors(path string) ([]string, []hash.Hash) { //返
 				paths = append(paths, child.Path)
 				hashes = append(hashes, child.Hash)
 				flag = true
-				data, _ := state.db.Get(child.Hash[:])
-				current, _ = TrieNodeFromBytes(data) //当前的current指过去
+				current, _ = t.db.Node(child.Hash) //当前的current指过去
 				break
 			} else if length > len(prefix) {
 				//部分不匹配
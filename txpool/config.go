@@ -0,0 +1,63 @@
+package txpool
+
+import (
+	"errors"
+	"time"
+)
+
+// 交易被拒绝的原因，RPC 会把它们原样返回给客户端
+var (
+	ErrAlreadyKnown         = errors.New("already known")
+	ErrInvalidSignature     = errors.New("invalid signature")
+	ErrOversizedData        = errors.New("oversized data")
+	ErrIntrinsicGas         = errors.New("intrinsic gas too low")
+	ErrGasLimit             = errors.New("exceeds block gas limit")
+	ErrNonceTooLow          = errors.New("nonce too low")
+	ErrInsufficientFunds    = errors.New("insufficient funds for gas * price + value")
+	ErrTxPoolOverflow       = errors.New("txpool is full")
+	ErrAccountLimitExceeded = errors.New("account limit exceeded")
+	ErrTipAboveFeeCap       = errors.New("max priority fee per gas higher than max fee per gas")
+	ErrUnderpriced          = errors.New("transaction underpriced")
+	ErrReplaceUnderpriced   = errors.New("replacement transaction underpriced")
+)
+
+// txMaxSize 是交易 RLP 编码之后的最大字节数，防止大交易占满内存和网络
+const txMaxSize = 128 * 1024
+
+// Config 限制交易池的大小，防止一个客户端发大量交易（特别是未来 nonce 的交易）把节点内存耗尽
+type Config struct {
+	GlobalSlots  int //所有账户 pending 交易的总数
+	AccountSlots int //每个账户 pending 交易的个数
+	GlobalQueue  int //所有账户 queue 交易的总数
+	AccountQueue int //每个账户 queue 交易的个数
+
+	Lifetime time.Duration //账户在这么长时间里没有新的 queue 交易，它的 queue 交易会被清掉
+
+	PriceLimit uint64 //接受交易的最低 gas price（EIP-1559 交易是最低小费）
+	PriceBump  uint64 //替换相同 nonce 的交易时，gas price 至少要提高的百分比
+
+	GasLimit uint64 //交易 gas 的上限，等于区块的 gas limit（txpool 不能引用 blockchain），0 表示不限制
+
+	NoSignatureCheck bool //不检查签名，只用于测试
+
+	Journal   string        //本地交易的日志文件，为空时不写日志，重启后交易池是空的
+	Rejournal time.Duration //重新生成日志的间隔，只保留还在交易池里的交易
+
+	Rebroadcast time.Duration //重新广播还没有被打包的本地交易的间隔
+}
+
+var DefaultConfig = &Config{
+	GlobalSlots:  4096,
+	AccountSlots: 16,
+	GlobalQueue:  1024,
+	AccountQueue: 64,
+	Lifetime:     3 * time.Hour,
+	PriceLimit:   1,
+	PriceBump:    10,
+	GasLimit:     1000000,
+	Rejournal:    time.Hour,
+	Rebroadcast:  10 * time.Minute,
+}
+
+// evictionInterval 检查过期 queue 交易的间隔
+const evictionInterval = time.Minute
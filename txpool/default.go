@@ -1,12 +1,17 @@
 package txpool
 
 import (
-	"blockchain/trie"
+	"blockchain/blockchain/eip1559"
+	"blockchain/statdb"
+	"blockchain/statemachine"
 	"blockchain/types"
+	"blockchain/utils/event"
 	"blockchain/utils/hash"
+	"blockchain/utils/math"
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 )
 
 const (
@@ -16,54 +21,203 @@ const (
 	Reset  = "\033[0m"
 )
 
-var mutex sync.Mutex
 var sharedData int
 
 type SortedTxs interface {
 	GasPrice() uint64
 	Push(tx *types.Transaction)
-	Replace(tx *types.Transaction)
+	Replace(tx *types.Transaction) *types.Transaction
 	Pop() *types.Transaction
 	Nonce() uint64
 }
 
+var _ TxPool = (*DefaultPool)(nil)
+
 type DefaultPool struct {
-	Stat *trie.State
+	Stat   statdb.StatDB
+	config Config
 
+	mu       sync.Mutex
 	all      map[hash.Hash]bool
-	txs      pendingTxs
 	pendings map[types.Address]pendingTxs
 	queue    map[types.Address]QueueSortedTxs
+	beats    map[types.Address]time.Time //账户最近一次有交易进入 queue 的时间
+	locals   map[types.Address]bool      //通过本节点 RPC 提交过本地交易的账户
+	baseFee  uint64                      //下一个区块的 base fee，交易按在它下面的小费排序
+	journal  *txJournal
+	txFeed   event.Feed[types.NewTxsEvent]
+
+	chainHeadCh  chan types.ChainHeadEvent
+	chainHeadSub event.Subscription
+	quit         chan struct{}
+	stopOnce     sync.Once
 }
 
-// 打印信息，测试用的
-func (pool *DefaultPool) PrintfPool() {
-	for i, txs := range pool.txs {
-		fmt.Println(i)
-		for j, tx := range *txs {
-			fmt.Printf("%d", j)
-			fmt.Println(" - tx:", tx)
+// blockChain 是交易池订阅新区块需要的接口
+type blockChain interface {
+	CurrentBlock() *types.Header
+	SubscribeChainHeadEvent(ch chan<- types.ChainHeadEvent) event.Subscription
+}
 
-		}
+type QueueSortedTxs []*types.Transaction
+
+func NewDefaultPool(state statdb.StatDB) *DefaultPool {
+	return NewDefaultPoolWithConfig(state, DefaultConfig)
+}
+
+func NewDefaultPoolWithConfig(state statdb.StatDB, config *Config) *DefaultPool {
+	pool := &DefaultPool{
+		Stat:        state.Copy(),
+		config:      *config,
+		all:         make(map[hash.Hash]bool),
+		pendings:    make(map[types.Address]pendingTxs),
+		queue:       make(map[types.Address]QueueSortedTxs),
+		beats:       make(map[types.Address]time.Time),
+		locals:      make(map[types.Address]bool),
+		chainHeadCh: make(chan types.ChainHeadEvent, 10),
+		quit:        make(chan struct{}),
 	}
-	for _, txs := range pool.pendings {
-		for _, tx := range txs {
-			for _, t := range *tx {
-				fmt.Println("pending:", t)
-			}
+	if config.Journal != "" {
+		pool.journal = newTxJournal(config.Journal)
+		pool.loadJournal()
+	}
+	go pool.loop()
+	return pool
+}
+
+// loadJournal 把上次运行留下的交易重新放回交易池，按当前的状态检查一遍，然后只保留还有效的交易重写日志
+func (pool *DefaultPool) loadJournal() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	total, dropped, err := pool.journal.load(pool.addLocal)
+	if err != nil {
+		fmt.Println(Red+"Failed to load transaction journal:", err)
+		fmt.Printf(Reset)
+	}
+	pool.reset(pool.Stat.Root(), nil)
+	if total > 0 {
+		fmt.Printf(Yellow+"Loaded %d transactions from journal, %d dropped\n", total, dropped)
+		fmt.Printf(Reset)
+	}
+	if err := pool.journal.rotate(pool.local()); err != nil {
+		fmt.Println(Red+"Failed to rotate transaction journal:", err)
+		fmt.Printf(Reset)
+	}
+}
+
+// local 返回本地账户在交易池里的所有交易，它们需要写进日志
+func (pool *DefaultPool) local() map[types.Address][]*types.Transaction {
+	txs := make(map[types.Address][]*types.Transaction)
+	for addr := range pool.locals {
+		if list := pool.txsFrom(addr); len(list) > 0 {
+			txs[addr] = list
 		}
 	}
-	fmt.Println("QUEUE:", pool.queue)
+	return txs
 }
 
-type QueueSortedTxs []*types.Transaction
+// Locals 返回本地账户
+func (pool *DefaultPool) Locals() []types.Address {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	locals := make([]types.Address, 0, len(pool.locals))
+	for addr := range pool.locals {
+		locals = append(locals, addr)
+	}
+	return locals
+}
+
+// IsLocal 判断交易是本地交易还是远程交易，本地账户发出的交易都是本地交易
+func (pool *DefaultPool) IsLocal(tx *types.Transaction) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.locals[tx.From()]
+}
+
+// SubscribeNewTxsEvent 订阅新进入交易池的交易，本地交易还会定期重新发出，交给网络层广播
+func (pool *DefaultPool) SubscribeNewTxsEvent(ch chan<- types.NewTxsEvent) event.Subscription {
+	return pool.txFeed.Subscribe(ch)
+}
+
+// SubscribeChainHeads 订阅新区块，每个新区块之后交易池切换到新的状态并清理交易
+func (pool *DefaultPool) SubscribeChainHeads(chain blockChain) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.chainHeadSub != nil {
+		pool.chainHeadSub.Unsubscribe()
+	}
+	pool.baseFee = eip1559.CalcBaseFee(chain.CurrentBlock())
+	pool.chainHeadSub = chain.SubscribeChainHeadEvent(pool.chainHeadCh)
+}
+
+// Stop 停止交易池的后台任务，可以多次调用
+func (pool *DefaultPool) Stop() {
+	pool.stopOnce.Do(func() {
+		pool.mu.Lock()
+		if pool.chainHeadSub != nil {
+			pool.chainHeadSub.Unsubscribe()
+		}
+		if pool.journal != nil {
+			pool.journal.close()
+		}
+		pool.mu.Unlock()
+		close(pool.quit)
+	})
+}
 
-func NewDefaultPool(state *trie.State) *DefaultPool {
-	return &DefaultPool{
-		Stat:     state,
-		all:      make(map[hash.Hash]bool),
-		pendings: make(map[types.Address]pendingTxs),
-		queue:    make(map[types.Address]QueueSortedTxs),
+func (pool *DefaultPool) loop() {
+	evict := time.NewTicker(evictionInterval)
+	defer evict.Stop()
+	rebroadcastInterval := pool.config.Rebroadcast
+	if rebroadcastInterval <= 0 {
+		rebroadcastInterval = DefaultConfig.Rebroadcast
+	}
+	rebroadcast := time.NewTicker(rebroadcastInterval)
+	defer rebroadcast.Stop()
+	var rejournal <-chan time.Time
+	if pool.journal != nil {
+		interval := pool.config.Rejournal
+		if interval <= 0 {
+			interval = DefaultConfig.Rejournal
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		rejournal = ticker.C
+	}
+	for {
+		select {
+		case ev := <-pool.chainHeadCh:
+			pool.mu.Lock()
+			pool.baseFee = eip1559.CalcBaseFee(ev.Header)
+			pool.reset(ev.Header.Root, nil)
+			pool.mu.Unlock()
+		case <-evict.C:
+			pool.mu.Lock()
+			pool.expireQueued(time.Now())
+			pool.mu.Unlock()
+		case <-rebroadcast.C:
+			pool.mu.Lock()
+			var txs []*types.Transaction
+			for addr := range pool.locals {
+				for _, blk := range pool.pendings[addr] {
+					txs = append(txs, *blk...)
+				}
+			}
+			pool.mu.Unlock()
+			//不能拿着锁发送，订阅者可能正在调用交易池
+			if len(txs) > 0 {
+				pool.txFeed.Send(types.NewTxsEvent{Txs: txs})
+			}
+		case <-rejournal:
+			pool.mu.Lock()
+			if err := pool.journal.rotate(pool.local()); err != nil {
+				fmt.Println(Red+"Failed to rotate transaction journal:", err)
+				fmt.Printf(Reset)
+			}
+			pool.mu.Unlock()
+		case <-pool.quit:
+			return
+		}
 	}
 }
 
@@ -83,13 +237,17 @@ type DefaultSortedTxs []*types.Transaction
 func (sorted *DefaultSortedTxs) Push(tx *types.Transaction) {
 	*sorted = append(*sorted, tx)
 }
-func (sorted DefaultSortedTxs) Replace(tx *types.Transaction) {
 
+// Replace 用 tx 替换相同 nonce 的交易，返回被替换的交易，没有相同 nonce 的交易时返回nil。
+// 是否允许替换由交易池按 price bump 决定
+func (sorted DefaultSortedTxs) Replace(tx *types.Transaction) *types.Transaction {
 	for key, value := range sorted {
-		if value.Nonce() == tx.Nonce() && tx.GasPrice() > value.GasPrice() {
+		if value.Nonce() == tx.Nonce() {
 			sorted[key] = tx
+			return value
 		}
 	}
+	return nil
 }
 func (sorted *DefaultSortedTxs) Pop() *types.Transaction {
 	if len(*sorted) > 1 {
@@ -124,34 +282,68 @@ func (sorted DefaultSortedTxs) GasPrice() uint64 {
 	return 0
 }
 
+// pendingTxs 是一个账户可以执行的交易，按 nonce 排列
 type pendingTxs []*DefaultSortedTxs
 
-func (p pendingTxs) Len() int {
-	return len(p)
+// SetStatRoot 把交易池看到的状态切换到新的状态根，然后重新检查所有交易
+func (pool *DefaultPool) SetStatRoot(root hash.Hash) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.reset(root, nil)
 }
 
-func (p pendingTxs) Less(i, j int) bool {
-	return p[i].GasPrice() < p[j].GasPrice()
+// NewTx 把远程交易加进交易池，受所有限制约束，被拒绝时返回原因，例如 ErrNonceTooLow、ErrInsufficientFunds
+func (pool *DefaultPool) NewTx(tx *types.Transaction) error {
+	if !pool.config.NoSignatureCheck && !tx.Verify() {
+		return ErrInvalidSignature
+	}
+	pool.mu.Lock()
+	err := pool.add(tx, false)
+	pool.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	pool.txFeed.Send(types.NewTxsEvent{Txs: []*types.Transaction{tx}})
+	return nil
 }
-func (p pendingTxs) Swap(i, j int) {
-	mutex.Lock()
-	defer mutex.Unlock()
-	p[i], p[j] = p[j], p[i]
+
+// AddLocal 把本节点用户提交的交易加进交易池。本地交易不受最低 gas price 限制，不会因为价格低被挤掉，
+// 会写进日志并且定期重新广播
+func (pool *DefaultPool) AddLocal(tx *types.Transaction) error {
+	if !pool.config.NoSignatureCheck && !tx.Verify() {
+		return ErrInvalidSignature
+	}
+	pool.mu.Lock()
+	err := pool.addLocal(tx)
+	if err == nil && pool.journal != nil {
+		if err := pool.journal.insert(tx); err != nil {
+			fmt.Println(Red+"Failed to journal transaction:", err)
+			fmt.Printf(Reset)
+		}
+	}
+	pool.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	pool.txFeed.Send(types.NewTxsEvent{Txs: []*types.Transaction{tx}})
+	return nil
 }
 
-func (pool DefaultPool) SetStatRoot(root hash.Hash) {
-	// pool.Stat.SetStatRoot(root)
+// addLocal 加入本地交易并把发送方记为本地账户，日志里的交易不带签名，重新加载时也走这里。调用时需要持有锁
+func (pool *DefaultPool) addLocal(tx *types.Transaction) error {
+	if err := pool.add(tx, true); err != nil {
+		return err
+	}
+	pool.locals[tx.From()] = true
+	return nil
 }
 
-func (pool *DefaultPool) NewTx(tx *types.Transaction) {
-	mutex.Lock()
-	defer mutex.Unlock()
+// add 是 NewTx 和 AddLocal 去掉签名检查的部分，调用时需要持有锁
+func (pool *DefaultPool) add(tx *types.Transaction, local bool) error {
+	local = local || pool.locals[tx.From()]
 	account, _ := pool.Stat.Load(tx.From())
-
-	if account.Nonce >= tx.Nonce() {
-		fmt.Println(Red + "Invalid nonce, transaction discarded")
-		fmt.Printf(Reset)
-		return
+	if err := pool.validateTx(tx, account, local); err != nil {
+		return err
 	}
 
 	nonce := account.Nonce
@@ -166,99 +358,480 @@ func (pool *DefaultPool) NewTx(tx *types.Transaction) {
 	}
 	if tx.Nonce() > nonce+1 {
 		// 加到queue
+		if err := pool.addQueueTx(tx, local); err != nil {
+			return err
+		}
 		fmt.Println(Yellow + "Transaction add Queue")
 		fmt.Printf(Reset)
-		pool.addQueueTx(tx)
-
 	} else if tx.Nonce() == nonce+1 {
 		// 加到pending，判断是否有queue的交易可以pop
-		pool.pushPendingTx(tx)
+		if err := pool.pushPendingTx(tx, local); err != nil {
+			return err
+		}
 		fmt.Println(Yellow + "Received and added new transaction to the pool")
 		fmt.Printf(Reset)
 	} else {
 		// replace
-		pool.replacePendingTx(tx)
+		if err := pool.replacePendingTx(tx); err != nil {
+			return err
+		}
 		fmt.Println(Yellow + "Replace transaction")
 		fmt.Printf(Reset)
 	}
+	return nil
+}
 
+// validateTx 按交易池当前的状态检查交易，签名由调用方检查；本地交易不检查最低 gas price
+func (pool *DefaultPool) validateTx(tx *types.Transaction, account types.Account, local bool) error {
+	if pool.all[tx.Hash()] {
+		return ErrAlreadyKnown
+	}
+	if tx.Size() > txMaxSize {
+		return ErrOversizedData
+	}
+	if tx.GasFeeCap() < tx.GasTipCap() {
+		return ErrTipAboveFeeCap
+	}
+	if !local && tx.GasTipCap() < pool.config.PriceLimit {
+		return ErrUnderpriced
+	}
+	if tx.Gas < statemachine.IntrinsicGas(tx) {
+		return ErrIntrinsicGas
+	}
+	// 超过区块 gas limit 的交易永远打包不了
+	if pool.config.GasLimit != 0 && tx.Gas > pool.config.GasLimit {
+		return ErrGasLimit
+	}
+	if tx.Nonce() <= account.Nonce {
+		return ErrNonceTooLow
+	}
+	// 余额要付得起这个账户在交易池里所有的交易，被替换的交易不算。费用超过 256 位的交易谁也付不起
+	spent, overflow := tx.Cost()
+	if overflow {
+		return ErrInsufficientFunds
+	}
+	for _, other := range pool.txsFrom(tx.From()) {
+		if other.Nonce() == tx.Nonce() {
+			continue
+		}
+		cost, overflow := other.Cost()
+		if overflow {
+			return ErrInsufficientFunds
+		}
+		if spent, overflow = math.SafeAddU256(spent, cost); overflow {
+			return ErrInsufficientFunds
+		}
+	}
+	if spent.Gt(&account.Amount) {
+		return ErrInsufficientFunds
+	}
+	return nil
 }
 
-func (pool *DefaultPool) replacePendingTx(tx *types.Transaction) {
+// txsFrom 返回账户在 pending 和 queue 里的交易
+func (pool *DefaultPool) txsFrom(addr types.Address) []*types.Transaction {
+	var txs []*types.Transaction
+	for _, blk := range pool.pendings[addr] {
+		txs = append(txs, *blk...)
+	}
+	return append(txs, pool.queue[addr]...)
+}
 
-	blks := pool.pendings[tx.From()]
-	for _, blk := range blks {
-		if blk.Nonce() >= tx.Nonce() {
-			if blk.GasPrice() > tx.GasPrice() {
-				blk.Replace(tx)
-				sort.Sort(blks)
-				break
-			}
+// canReplace 新交易的 GasFeeCap 和 GasTipCap 都至少要比旧交易高 PriceBump%，防止用很小的加价反复替换交易刷屏
+func (pool *DefaultPool) canReplace(old, tx *types.Transaction) bool {
+	if tx.GasFeeCap() <= old.GasFeeCap() || tx.GasTipCap() <= old.GasTipCap() {
+		return false
+	}
+	//价格很高时乘法会溢出，门槛超过 uint64 的交易不可能被替换
+	feeThreshold, overflow := math.SafeMul(old.GasFeeCap(), 100+pool.config.PriceBump)
+	if overflow {
+		return false
+	}
+	tipThreshold, overflow := math.SafeMul(old.GasTipCap(), 100+pool.config.PriceBump)
+	if overflow {
+		return false
+	}
+	return tx.GasFeeCap() >= feeThreshold/100 && tx.GasTipCap() >= tipThreshold/100
+}
 
+// cheaper 比较两笔交易在当前 base fee 下给矿工的小费，小费一样时比较 GasFeeCap
+func (pool *DefaultPool) cheaper(a, b *types.Transaction) bool {
+	tipA, tipB := effectiveTip(a, pool.baseFee), effectiveTip(b, pool.baseFee)
+	if tipA != tipB {
+		return tipA < tipB
+	}
+	return a.GasFeeCap() < b.GasFeeCap()
+}
+
+// replacePendingTx 替换 pending 里相同 nonce 的交易，旧交易从所有索引里删掉
+func (pool *DefaultPool) replacePendingTx(tx *types.Transaction) error {
+	for _, blk := range pool.pendings[tx.From()] {
+		if blk.Nonce() != tx.Nonce() {
+			continue
+		}
+		if !pool.canReplace((*blk)[0], tx) {
+			return ErrReplaceUnderpriced
+		}
+		old := blk.Replace(tx)
+		delete(pool.all, old.Hash())
+		pool.all[tx.Hash()] = true
+		return nil
+	}
+	return ErrNonceTooLow
+}
+
+func (pool *DefaultPool) pushPendingTx(tx *types.Transaction, local bool) error {
+	from := tx.From()
+	if len(pool.pendings[from]) >= pool.config.AccountSlots {
+		return ErrAccountLimitExceeded
+	}
+	for pool.pendingCount() >= pool.config.GlobalSlots {
+		//挤掉 gas price 最低的远程交易，只能挤掉账户 nonce 最大的那笔，不然会在 nonce 中间留下空洞。
+		//本地交易不管价格都可以挤掉远程交易
+		victim := pool.cheapestPending(from)
+		if victim == nil {
+			return ErrTxPoolOverflow
 		}
+		if !local && !pool.cheaper(victim, tx) {
+			return ErrUnderpriced
+		}
+		pool.removePendingTail(victim.From())
 	}
+	pool.addPending(tx)
+	pool.promoteQueued(from)
+	return nil
+}
+
+func (pool *DefaultPool) addPending(tx *types.Transaction) {
+	blk := &DefaultSortedTxs{tx}
+	pool.pendings[tx.From()] = append(pool.pendings[tx.From()], blk)
+	pool.all[tx.Hash()] = true
 }
 
-func (pool *DefaultPool) pushPendingTx(tx *types.Transaction) {
-	blks := pool.pendings[tx.From()]
+// promoteQueued 把 queue 里接在 pending 后面的交易移到 pending，pending 满了就留在 queue 里
+func (pool *DefaultPool) promoteQueued(from types.Address) {
+	blks := pool.pendings[from]
 	if len(blks) == 0 {
-		blk := &DefaultSortedTxs{tx}
-		blks = append(blks, blk)
-		pool.pendings[tx.From()] = blks
-		pool.txs = append(pool.txs, blk)
-		sort.Sort(pool.txs)
-	} else {
-		blk := &DefaultSortedTxs{tx}
-		blks = append(blks, blk)
-		pool.pendings[tx.From()] = blks
-		pool.txs = append(pool.txs, blk)
-		sort.Sort(pool.txs)
+		return
 	}
-	//TODO 更新queue中可以pop到pending中的交易
-	queueTxs := pool.queue[tx.From()]
-	nonece := tx.Nonce()
-	for key, queueTx := range queueTxs {
-		if queueTx.Nonce() == nonece+1 {
-			nonece++
-			queueTxs = append(queueTxs[:key], queueTxs[key+1:]...)
-			pool.queue[tx.From()] = queueTxs
-			pool.pushPendingTx(queueTx)
+	nonce := blks[len(blks)-1].Nonce()
+	queueTxs := pool.queue[from]
+	for len(queueTxs) > 0 && queueTxs[0].Nonce() == nonce+1 {
+		if len(pool.pendings[from]) >= pool.config.AccountSlots || pool.pendingCount() >= pool.config.GlobalSlots {
+			break
 		}
+		pool.addPending(queueTxs[0])
+		queueTxs = queueTxs[1:]
+		nonce++
 	}
+	pool.setQueue(from, queueTxs)
 }
 
-func (pool DefaultPool) addQueueTx(tx *types.Transaction) {
-	txs := pool.queue[tx.From()]
+func (pool *DefaultPool) addQueueTx(tx *types.Transaction, local bool) error {
+	from := tx.From()
+	for i, old := range pool.queue[from] {
+		if old.Nonce() != tx.Nonce() {
+			continue
+		}
+		if !pool.canReplace(old, tx) {
+			return ErrReplaceUnderpriced
+		}
+		pool.queue[from][i] = tx
+		pool.beats[from] = time.Now()
+		delete(pool.all, old.Hash())
+		pool.all[tx.Hash()] = true
+		return nil
+	}
+	if len(pool.queue[from]) >= pool.config.AccountQueue {
+		return ErrAccountLimitExceeded
+	}
+	for pool.queueCount() >= pool.config.GlobalQueue {
+		victim := pool.cheapestQueued(from)
+		if victim == nil {
+			return ErrTxPoolOverflow
+		}
+		if !local && !pool.cheaper(victim, tx) {
+			return ErrUnderpriced
+		}
+		pool.removeQueueTail(victim.From())
+	}
+	txs := pool.queue[from]
 	txs = append(txs, tx)
 	sort.Sort(txs)
-	pool.queue[tx.From()] = txs
+	pool.queue[from] = txs
+	pool.beats[from] = time.Now()
+	pool.all[tx.Hash()] = true
+	return nil
 }
 
-func (pool *DefaultPool) Pop() *types.Transaction {
-	mutex.Lock()
-	defer mutex.Unlock()
-	if len(pool.txs) == 0 {
-		return nil
+func (pool *DefaultPool) setQueue(from types.Address, txs QueueSortedTxs) {
+	if len(txs) == 0 {
+		delete(pool.queue, from)
+		delete(pool.beats, from)
+		return
 	}
+	pool.queue[from] = txs
+}
 
-	tx := pool.txs[0].Pop()
-	pools := pool.pendings[tx.From()]
+func (pool *DefaultPool) pendingCount() int {
+	count := 0
+	for _, blks := range pool.pendings {
+		count += len(blks)
+	}
+	return count
+}
 
-	if pools != nil {
+func (pool *DefaultPool) queueCount() int {
+	count := 0
+	for _, txs := range pool.queue {
+		count += len(txs)
+	}
+	return count
+}
 
-		if len(pools) > 0 {
-			//fmt.Printf("pools length: %d\n", len(pools))
+// cheapestPending 在每个远程账户 nonce 最大的 pending 交易里找 gas price 最低的，不包括 exclude 账户
+func (pool *DefaultPool) cheapestPending(exclude types.Address) *types.Transaction {
+	var cheapest *types.Transaction
+	for addr, blks := range pool.pendings {
+		if addr == exclude || pool.locals[addr] || len(blks) == 0 {
+			continue
+		}
+		tail := *blks[len(blks)-1]
+		if len(tail) == 0 {
+			continue
+		}
+		if cheapest == nil || pool.cheaper(tail[0], cheapest) {
+			cheapest = tail[0]
+		}
+	}
+	return cheapest
+}
 
-			pool.pendings[tx.From()] = pool.pendings[tx.From()][1:]
+func (pool *DefaultPool) cheapestQueued(exclude types.Address) *types.Transaction {
+	var cheapest *types.Transaction
+	for addr, txs := range pool.queue {
+		if addr == exclude || pool.locals[addr] || len(txs) == 0 {
+			continue
+		}
+		if tail := txs[len(txs)-1]; cheapest == nil || pool.cheaper(tail, cheapest) {
+			cheapest = tail
 		}
 	}
-	if tx != nil {
-		pool.txs = pool.txs[1:]
+	return cheapest
+}
+
+func (pool *DefaultPool) removePendingTail(addr types.Address) {
+	blks := pool.pendings[addr]
+	last := blks[len(blks)-1]
+	if len(blks) == 1 {
+		delete(pool.pendings, addr)
+	} else {
+		pool.pendings[addr] = blks[:len(blks)-1]
+	}
+	for _, tx := range *last {
+		delete(pool.all, tx.Hash())
+	}
+}
+
+func (pool *DefaultPool) removeQueueTail(addr types.Address) {
+	txs := pool.queue[addr]
+	delete(pool.all, txs[len(txs)-1].Hash())
+	pool.setQueue(addr, txs[:len(txs)-1])
+}
+
+// expireQueued 清掉在 Lifetime 内没有新 queue 交易的远程账户的 queue 交易
+func (pool *DefaultPool) expireQueued(now time.Time) {
+	for addr, beat := range pool.beats {
+		if pool.locals[addr] || now.Sub(beat) <= pool.config.Lifetime {
+			continue
+		}
+		for _, tx := range pool.queue[addr] {
+			delete(pool.all, tx.Hash())
+		}
+		delete(pool.queue, addr)
+		delete(pool.beats, addr)
 	}
+}
 
-	return tx
+// Pending 返回每个账户可以执行的交易，按 nonce 排列，交给 NewTxsByPriceAndNonce 排序打包
+func (pool *DefaultPool) Pending() map[types.Address][]*types.Transaction {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.pending()
 }
 
-func (pool DefaultPool) NotifyTxEvent(txs []*types.Transaction) {
+func (pool *DefaultPool) pending() map[types.Address][]*types.Transaction {
+	pending := make(map[types.Address][]*types.Transaction, len(pool.pendings))
+	for addr, blks := range pool.pendings {
+		txs := make([]*types.Transaction, 0, len(blks))
+		for _, blk := range blks {
+			txs = append(txs, *blk...)
+		}
+		pending[addr] = txs
+	}
+	return pending
+}
 
+// PendingAccount 返回执行完账户所有 pending 交易之后的账户：nonce 是最后一笔 pending 交易的 nonce，
+// 余额减去了这些交易最多需要的费用。客户端用它连续发送多笔交易
+func (pool *DefaultPool) PendingAccount(addr types.Address) types.Account {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	account, _ := pool.Stat.Load(addr)
+	for _, blk := range pool.pendings[addr] {
+		for _, tx := range *blk {
+			account.Nonce = tx.Nonce()
+			cost, overflow := tx.Cost()
+			if balance, underflow := math.SafeSubU256(&account.Amount, cost); overflow || underflow {
+				account.Amount.Clear()
+			} else {
+				account.Amount = *balance
+			}
+		}
+	}
+	return account
+}
+
+// Status 返回 pending 和 queue 里交易的个数
+func (pool *DefaultPool) Status() (int, int) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.pendingCount(), pool.queueCount()
+}
+
+// Content 返回交易池里所有的交易，按发送方分组，每组按 nonce 排列
+func (pool *DefaultPool) Content() (map[types.Address][]*types.Transaction, map[types.Address][]*types.Transaction) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	queued := make(map[types.Address][]*types.Transaction, len(pool.queue))
+	for addr, txs := range pool.queue {
+		queued[addr] = append([]*types.Transaction(nil), txs...)
+	}
+	return pool.pending(), queued
+}
+
+// ContentFrom 返回一个账户 pending 和 queue 里的交易，按 nonce 排列
+func (pool *DefaultPool) ContentFrom(addr types.Address) ([]*types.Transaction, []*types.Transaction) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var pending []*types.Transaction
+	for _, blk := range pool.pendings[addr] {
+		pending = append(pending, *blk...)
+	}
+	return pending, append([]*types.Transaction(nil), pool.queue[addr]...)
+}
+
+// Get 按hash查找交易池里的交易，不存在时返回nil
+func (pool *DefaultPool) Get(h hash.Hash) *types.Transaction {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if !pool.all[h] {
+		return nil
+	}
+	for _, blks := range pool.pendings {
+		for _, blk := range blks {
+			for _, tx := range *blk {
+				if tx.Hash() == h {
+					return tx
+				}
+			}
+		}
+	}
+	for _, txs := range pool.queue {
+		for _, tx := range txs {
+			if tx.Hash() == h {
+				return tx
+			}
+		}
+	}
+	return nil
+}
+
+// NotifyTxEvent 把这些交易从交易池里删掉，例如它们已经被别的节点打包了
+func (pool *DefaultPool) NotifyTxEvent(txs []*types.Transaction) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	drop := make(map[hash.Hash]bool, len(txs))
+	for _, tx := range txs {
+		drop[tx.Hash()] = true
+	}
+	pool.reset(pool.Stat.Root(), drop)
+}
+
+// reset 用 root 对应的状态重新整理交易池：删掉已经被打包的、nonce 过期的、余额付不起的交易以及 drop 里的交易，
+// 每个账户从状态里的 nonce 开始连续的交易放进 pending，其余的放进 queue
+func (pool *DefaultPool) reset(root hash.Hash, drop map[hash.Hash]bool) {
+	if root != pool.Stat.Root() {
+		state := pool.Stat.Copy()
+		if err := state.SetStatRoot(root); err != nil {
+			fmt.Println(Red+"Reset txpool failed:", err)
+			fmt.Printf(Reset)
+			return
+		}
+		pool.Stat = state
+	}
+
+	txs := make(map[types.Address][]*types.Transaction)
+	for addr, blks := range pool.pendings {
+		for _, blk := range blks {
+			txs[addr] = append(txs[addr], *blk...)
+		}
+	}
+	for addr, queued := range pool.queue {
+		txs[addr] = append(txs[addr], queued...)
+	}
+	beats := pool.beats
+	pool.all = make(map[hash.Hash]bool)
+	pool.pendings = make(map[types.Address]pendingTxs)
+	pool.queue = make(map[types.Address]QueueSortedTxs)
+	pool.beats = make(map[types.Address]time.Time)
+
+	for addr, list := range txs {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Nonce() < list[j].Nonce() })
+		account, _ := pool.Stat.Load(addr)
+		next := account.Nonce + 1
+		var queued QueueSortedTxs
+		for _, tx := range list {
+			if cost, overflow := tx.Cost(); drop[tx.Hash()] || tx.Nonce() < next || overflow || cost.Gt(&account.Amount) {
+				continue
+			}
+			if tx.Nonce() == next && len(pool.pendings[addr]) < pool.config.AccountSlots {
+				blk := &DefaultSortedTxs{tx}
+				pool.pendings[addr] = append(pool.pendings[addr], blk)
+				pool.all[tx.Hash()] = true
+				next++
+				continue
+			}
+			if len(queued) > 0 && queued[len(queued)-1].Nonce() == tx.Nonce() {
+				continue //同一个 nonce 只留一笔
+			}
+			if len(queued) < pool.config.AccountQueue {
+				queued = append(queued, tx)
+				pool.all[tx.Hash()] = true
+			}
+		}
+		if len(queued) > 0 {
+			pool.queue[addr] = queued
+			if beat, ok := beats[addr]; ok {
+				pool.beats[addr] = beat
+			} else {
+				pool.beats[addr] = time.Now()
+			}
+		}
+	}
+	//本地交易不会被挤掉，只剩本地交易时允许超过限制
+	for pool.pendingCount() > pool.config.GlobalSlots {
+		victim := pool.cheapestPending(types.Address{})
+		if victim == nil {
+			break
+		}
+		pool.removePendingTail(victim.From())
+	}
+	for pool.queueCount() > pool.config.GlobalQueue {
+		victim := pool.cheapestQueued(types.Address{})
+		if victim == nil {
+			break
+		}
+		pool.removeQueueTail(victim.From())
+	}
 }
@@ -0,0 +1,535 @@
+package txpool
+
+import (
+	"blockchain/crypto"
+	"blockchain/statdb"
+	"blockchain/types"
+	"blockchain/utils/event"
+	"blockchain/utils/math"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/holiman/uint256"
+)
+
+func newTestPool(t *testing.T, config *Config, accounts ...types.Address) *DefaultPool {
+	pool, _ := newTestPoolWithState(t, config, accounts...)
+	return pool
+}
+
+// newTestPoolWithState 同时返回链上的状态，修改并提交之后可以用来模拟新区块
+func newTestPoolWithState(t *testing.T, config *Config, accounts ...types.Address) (*DefaultPool, statdb.StatDB) {
+	state := statdb.NewMemoryStatDB()
+	for _, addr := range accounts {
+		state.Store(addr, types.Account{Amount: *uint256.NewInt(1000000)})
+	}
+	state.Commit()
+	pool := NewDefaultPoolWithConfig(state, config)
+	t.Cleanup(pool.Stop)
+	return pool, state
+}
+
+func testTx(from types.Address, nonce, gasPrice uint64) *types.Transaction {
+	return types.NewTransaction(nonce, types.Address{0xff}, from, uint256.NewInt(1), 21000, gasPrice, nil)
+}
+
+func testConfig() *Config {
+	return &Config{
+		GlobalSlots:  4,
+		AccountSlots: 2,
+		GlobalQueue:  4,
+		AccountQueue: 2,
+		Lifetime:     time.Hour,
+
+		NoSignatureCheck: true,
+	}
+}
+
+func (pool *DefaultPool) counts() (pending int, queued int) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.pendingCount(), pool.queueCount()
+}
+
+func TestAccountLimits(t *testing.T) {
+	a := types.Address{0x1}
+	pool := newTestPool(t, testConfig(), a)
+
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		if err := pool.NewTx(testTx(a, nonce, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.NewTx(testTx(a, 3, 1)); !errors.Is(err, ErrAccountLimitExceeded) {
+		t.Fatalf("pending over account limit: have %v, want %v", err, ErrAccountLimitExceeded)
+	}
+	for nonce := uint64(10); nonce <= 11; nonce++ {
+		if err := pool.NewTx(testTx(a, nonce, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.NewTx(testTx(a, 12, 1)); !errors.Is(err, ErrAccountLimitExceeded) {
+		t.Fatalf("queue over account limit: have %v, want %v", err, ErrAccountLimitExceeded)
+	}
+	if pending, queued := pool.counts(); pending != 2 || queued != 2 {
+		t.Fatalf("counts mismatch: pending %d queued %d", pending, queued)
+	}
+}
+
+// 交易池满了之后，更贵的交易挤掉最便宜账户 nonce 最大的交易，更便宜的交易被拒绝
+func TestGlobalEviction(t *testing.T) {
+	a, b, c := types.Address{0x1}, types.Address{0x2}, types.Address{0x3}
+	pool := newTestPool(t, testConfig(), a, b, c)
+
+	pool.NewTx(testTx(a, 1, 5))
+	pool.NewTx(testTx(a, 2, 5))
+	pool.NewTx(testTx(b, 1, 2))
+	pool.NewTx(testTx(b, 2, 1))
+
+	if err := pool.NewTx(testTx(c, 1, 1)); !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("cheap tx in full pool: have %v, want %v", err, ErrUnderpriced)
+	}
+	cheap := testTx(b, 2, 1)
+	if err := pool.NewTx(testTx(c, 1, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if pool.all[cheap.Hash()] {
+		t.Fatal("cheapest tail not evicted")
+	}
+	if pending, _ := pool.counts(); pending != 4 {
+		t.Fatalf("pending count mismatch: have %d, want 4", pending)
+	}
+	// b 的 nonce 1 还在，nonce 2 可以重新进来（如果价格够高）
+	if len(pool.pendings[b]) != 1 || pool.pendings[b][0].Nonce() != 1 {
+		t.Fatalf("nonce gap after eviction: %v", pool.pendings[b])
+	}
+}
+
+func TestQueueEviction(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	config := testConfig()
+	config.GlobalQueue = 2
+	pool := newTestPool(t, config, a, b)
+
+	pool.NewTx(testTx(a, 5, 1))
+	pool.NewTx(testTx(a, 6, 1))
+	if err := pool.NewTx(testTx(b, 5, 1)); !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("have %v, want %v", err, ErrUnderpriced)
+	}
+	if err := pool.NewTx(testTx(b, 5, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.queue[a]) != 1 || pool.queue[a][0].Nonce() != 5 {
+		t.Fatalf("wrong queued tx evicted: %v", pool.queue[a])
+	}
+}
+
+func TestQueuedLifetime(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	pool := newTestPool(t, testConfig(), a, b)
+
+	pool.NewTx(testTx(a, 5, 1))
+	pool.NewTx(testTx(b, 5, 1))
+	pool.mu.Lock()
+	pool.beats[a] = time.Now().Add(-2 * time.Hour)
+	pool.expireQueued(time.Now())
+	pool.mu.Unlock()
+
+	if _, ok := pool.queue[a]; ok {
+		t.Fatal("stale queued txs not dropped")
+	}
+	if pool.all[testTx(a, 5, 1).Hash()] {
+		t.Fatal("stale tx still in all")
+	}
+	if len(pool.queue[b]) != 1 {
+		t.Fatal("fresh queued txs dropped")
+	}
+}
+
+// queue 里的交易在 pending 有空位时才会被提升
+func TestPromotionRespectsLimits(t *testing.T) {
+	a := types.Address{0x1}
+	pool := newTestPool(t, testConfig(), a)
+
+	pool.NewTx(testTx(a, 2, 1))
+	pool.NewTx(testTx(a, 3, 1))
+	if err := pool.NewTx(testTx(a, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if pending, queued := pool.counts(); pending != 2 || queued != 1 {
+		t.Fatalf("counts mismatch: pending %d queued %d", pending, queued)
+	}
+}
+
+func pendingNonces(pool *DefaultPool, addr types.Address) []uint64 {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var nonces []uint64
+	for _, blk := range pool.pendings[addr] {
+		nonces = append(nonces, blk.Nonce())
+	}
+	return nonces
+}
+
+func queuedNonces(pool *DefaultPool, addr types.Address) []uint64 {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var nonces []uint64
+	for _, tx := range pool.queue[addr] {
+		nonces = append(nonces, tx.Nonce())
+	}
+	return nonces
+}
+
+func equalNonces(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 新区块之后，被打包的交易被删掉，付不起的交易被删掉并且后面的交易退回 queue，接上的 queue 交易被提升
+func TestResetAfterBlock(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	config := testConfig()
+	config.AccountSlots = 8
+	pool, state := newTestPoolWithState(t, config, a, b)
+
+	for _, nonce := range []uint64{1, 2, 3, 5} {
+		pool.NewTx(testTx(a, nonce, 1))
+	}
+	for _, nonce := range []uint64{1, 2, 3} {
+		pool.NewTx(testTx(b, nonce, 1))
+	}
+
+	// 区块打包了 a 的 1、2 和 a 的一笔不在交易池里的 nonce 3，b 的余额被转走了
+	state.Store(a, types.Account{Amount: *uint256.NewInt(1000000), Nonce: 3})
+	state.Store(b, types.Account{Amount: *uint256.NewInt(0)})
+	root, _ := state.Commit()
+	pool.SetStatRoot(root)
+
+	if have := pendingNonces(pool, a); len(have) != 0 {
+		t.Fatalf("a pending mismatch: %v", have)
+	}
+	if have := queuedNonces(pool, a); !equalNonces(have, []uint64{5}) {
+		t.Fatalf("a queue mismatch: %v", have)
+	}
+	if have := pendingNonces(pool, b); len(have) != 0 {
+		t.Fatalf("b pending not dropped: %v", have)
+	}
+	if pool.all[testTx(a, 1, 1).Hash()] || pool.all[testTx(b, 1, 1).Hash()] {
+		t.Fatal("dropped txs still in all")
+	}
+
+	// a 的 nonce 4 接上之后 5 被提升
+	if err := pool.NewTx(testTx(a, 4, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if have := pendingNonces(pool, a); !equalNonces(have, []uint64{4, 5}) {
+		t.Fatalf("a pending after promotion: %v", have)
+	}
+}
+
+// reset 之后 queue 里已经接上的交易直接进入 pending
+func TestResetPromotesQueued(t *testing.T) {
+	a := types.Address{0x1}
+	pool, state := newTestPoolWithState(t, testConfig(), a)
+
+	pool.NewTx(testTx(a, 3, 1))
+	state.Store(a, types.Account{Amount: *uint256.NewInt(1000000), Nonce: 2})
+	root, _ := state.Commit()
+	pool.SetStatRoot(root)
+
+	if have := pendingNonces(pool, a); !equalNonces(have, []uint64{3}) {
+		t.Fatalf("queued tx not promoted: pending %v queue %v", have, queuedNonces(pool, a))
+	}
+}
+
+type testChain struct {
+	feed event.Feed[types.ChainHeadEvent]
+}
+
+func (c *testChain) CurrentBlock() *types.Header {
+	return &types.Header{}
+}
+
+func (c *testChain) SubscribeChainHeadEvent(ch chan<- types.ChainHeadEvent) event.Subscription {
+	return c.feed.Subscribe(ch)
+}
+
+func TestSubscribeChainHeads(t *testing.T) {
+	a := types.Address{0x1}
+	pool, state := newTestPoolWithState(t, testConfig(), a)
+	chain := new(testChain)
+	pool.SubscribeChainHeads(chain)
+
+	pool.NewTx(testTx(a, 1, 1))
+	state.Store(a, types.Account{Amount: *uint256.NewInt(1000000), Nonce: 1})
+	root, _ := state.Commit()
+	chain.feed.Send(types.ChainHeadEvent{Header: &types.Header{Root: root}})
+
+	deadline := time.Now().Add(time.Second)
+	for len(pendingNonces(pool, a)) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool not reset after new head")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// 停止之后不会再阻塞区块链
+	pool.Stop()
+	chain.feed.Send(types.ChainHeadEvent{Header: &types.Header{Root: root}})
+}
+
+func TestReplaceByFee(t *testing.T) {
+	a := types.Address{0x1}
+	config := testConfig()
+	config.PriceBump = 10
+	pool, state := newTestPoolWithState(t, config, a)
+	state.Store(a, types.Account{Amount: *uint256.NewInt(100000000)})
+	root, _ := state.Commit()
+	pool.SetStatRoot(root)
+
+	for _, tx := range []*types.Transaction{testTx(a, 1, 100), testTx(a, 5, 100)} {
+		if err := pool.NewTx(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, nonce := range []uint64{1, 5} {
+		old := testTx(a, nonce, 100)
+		if err := pool.NewTx(testTx(a, nonce, 105)); !errors.Is(err, ErrReplaceUnderpriced) {
+			t.Fatalf("nonce %d: small bump: have %v, want %v", nonce, err, ErrReplaceUnderpriced)
+		}
+		if err := pool.NewTx(testTx(a, nonce, 90)); !errors.Is(err, ErrReplaceUnderpriced) {
+			t.Fatalf("nonce %d: cheaper replacement: have %v, want %v", nonce, err, ErrReplaceUnderpriced)
+		}
+		replacement := testTx(a, nonce, 110)
+		if err := pool.NewTx(replacement); err != nil {
+			t.Fatalf("nonce %d: replacement rejected: %v", nonce, err)
+		}
+		if pool.all[old.Hash()] || !pool.all[replacement.Hash()] {
+			t.Fatalf("nonce %d: all not updated", nonce)
+		}
+	}
+	if pending, queued := pool.counts(); pending != 1 || queued != 1 {
+		t.Fatalf("counts mismatch after replacement: pending %d queued %d", pending, queued)
+	}
+	if pending := pool.Pending()[a]; len(pending) != 1 || pending[0].GasPrice() != 110 {
+		t.Fatalf("pending %v, want the replacement", pending)
+	}
+}
+
+// 价格接近 MaxUint64 时计算门槛不能溢出，加价 1 不能替换交易
+func TestReplaceByFeeOverflow(t *testing.T) {
+	a := types.Address{0x1}
+	config := testConfig()
+	config.PriceBump = 10
+	pool, state := newTestPoolWithState(t, config, a)
+	state.Store(a, types.Account{Amount: *math.MaxUint256})
+	root, _ := state.Commit()
+	pool.SetStatRoot(root)
+
+	price := uint64(1) << 63
+	if err := pool.NewTx(testTx(a, 1, price)); err != nil {
+		t.Fatal(err)
+	}
+	for _, bump := range []uint64{price + 1, ^uint64(0)} {
+		if err := pool.NewTx(testTx(a, 1, bump)); !errors.Is(err, ErrReplaceUnderpriced) {
+			t.Fatalf("price %d: have %v, want %v", bump, err, ErrReplaceUnderpriced)
+		}
+	}
+}
+
+func TestValidateTx(t *testing.T) {
+	a := types.Address{0x1}
+	config := testConfig()
+	config.PriceLimit = 2
+	config.GasLimit = 100000
+	pool, state := newTestPoolWithState(t, config, a)
+	state.Store(a, types.Account{Amount: *uint256.NewInt(110000), Nonce: 1})
+	root, _ := state.Commit()
+	pool.SetStatRoot(root)
+
+	if err := pool.NewTx(testTx(a, 2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		tx  *types.Transaction
+		err error
+	}{
+		{testTx(a, 2, 2), ErrAlreadyKnown},
+		{testTx(a, 1, 2), ErrNonceTooLow},
+		{testTx(a, 3, 1), ErrUnderpriced},
+		{types.NewTransaction(3, types.Address{0xff}, a, uint256.NewInt(1), 20999, 2, nil), ErrIntrinsicGas},
+		{types.NewTransaction(3, types.Address{0xff}, a, uint256.NewInt(1), 100001, 2, nil), ErrGasLimit},
+		// value 加上gas费超过 256 位，不能回绕成一个很小的费用
+		{types.NewTransaction(3, types.Address{0xff}, a, math.MaxUint256, 21000, 2, nil), ErrInsufficientFunds},
+		// 余额 110000 只够付两笔 42001 的交易
+		{testTx(a, 3, 2), nil},
+		{testTx(a, 4, 2), ErrInsufficientFunds},
+		// 替换不会重复计算被替换的交易
+		{testTx(a, 3, 3), nil},
+	}
+	for i, test := range tests {
+		if err := pool.NewTx(test.tx); !errors.Is(err, test.err) {
+			t.Fatalf("test %d: have %v, want %v", i, err, test.err)
+		}
+	}
+}
+
+func TestSignatureCheck(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	a := types.PubKeyToAddress(crypto.FromECDSAPub(&key.PublicKey))
+	config := testConfig()
+	config.NoSignatureCheck = false
+	pool := newTestPool(t, config, a)
+
+	if err := pool.NewTx(testTx(a, 1, 1)); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("unsigned tx: have %v, want %v", err, ErrInvalidSignature)
+	}
+	signed, _ := types.SignTx(testTx(a, 1, 1), key)
+	if err := pool.NewTx(signed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 本地交易不受最低 gas price 限制，不会被挤掉也不会过期
+func TestLocalTransactions(t *testing.T) {
+	a, b, c, d := types.Address{0x1}, types.Address{0x2}, types.Address{0x3}, types.Address{0x4}
+	config := testConfig()
+	config.PriceLimit = 2
+	pool := newTestPool(t, config, a, b, c, d)
+
+	if err := pool.NewTx(testTx(a, 1, 1)); !errors.Is(err, ErrUnderpriced) {
+		t.Fatalf("cheap remote tx: have %v, want %v", err, ErrUnderpriced)
+	}
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		if err := pool.AddLocal(testTx(a, nonce, 1)); err != nil {
+			t.Fatalf("cheap local tx rejected: %v", err)
+		}
+		if err := pool.NewTx(testTx(b, nonce, 5)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !pool.IsLocal(testTx(a, 1, 1)) || pool.IsLocal(testTx(b, 1, 5)) {
+		t.Fatal("wrong transaction class")
+	}
+	// 交易池满了，远程交易只能挤掉远程交易，本地交易不管价格都可以挤掉远程交易
+	if err := pool.NewTx(testTx(c, 1, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if !equalNonces(pendingNonces(pool, a), []uint64{1, 2}) || !equalNonces(pendingNonces(pool, b), []uint64{1}) {
+		t.Fatalf("wrong tx evicted: a %v b %v", pendingNonces(pool, a), pendingNonces(pool, b))
+	}
+	if err := pool.AddLocal(testTx(d, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(pendingNonces(pool, b)) != 0 {
+		t.Fatalf("local tx did not evict remote: b %v", pendingNonces(pool, b))
+	}
+
+	pool.AddLocal(testTx(a, 5, 1))
+	pool.mu.Lock()
+	pool.beats[a] = time.Now().Add(-2 * time.Hour)
+	pool.expireQueued(time.Now())
+	pool.mu.Unlock()
+	if !equalNonces(queuedNonces(pool, a), []uint64{5}) {
+		t.Fatal("local queued txs expired")
+	}
+}
+
+// 本地 pending 交易定期重新发出
+func TestRebroadcastLocals(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	config := testConfig()
+	config.Rebroadcast = 10 * time.Millisecond
+	pool := newTestPool(t, config, a, b)
+
+	pool.AddLocal(testTx(a, 1, 1))
+	pool.NewTx(testTx(b, 1, 1))
+
+	ch := make(chan types.NewTxsEvent, 1)
+	sub := pool.SubscribeNewTxsEvent(ch)
+	defer sub.Unsubscribe()
+	select {
+	case ev := <-ch:
+		if len(ev.Txs) != 1 || ev.Txs[0].From() != a {
+			t.Fatalf("rebroadcast %v, want only the local tx", ev.Txs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("local txs not rebroadcast")
+	}
+}
+
+func TestContent(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	pool := newTestPool(t, testConfig(), a, b)
+	pool.NewTx(testTx(a, 2, 1))
+	pool.NewTx(testTx(a, 1, 1))
+	pool.NewTx(testTx(b, 5, 1))
+
+	if pending, queued := pool.Status(); pending != 2 || queued != 1 {
+		t.Fatalf("status mismatch: pending %d queued %d", pending, queued)
+	}
+	pending, queued := pool.Content()
+	if len(pending[a]) != 2 || pending[a][0].Nonce() != 1 || pending[a][1].Nonce() != 2 {
+		t.Fatalf("pending content mismatch: %v", pending)
+	}
+	if len(queued[b]) != 1 || len(pending[b]) != 0 {
+		t.Fatalf("queued content mismatch: %v", queued)
+	}
+	if p, q := pool.ContentFrom(b); len(p) != 0 || len(q) != 1 || q[0].Nonce() != 5 {
+		t.Fatalf("content from mismatch: %v %v", p, q)
+	}
+	if tx := pool.Get(testTx(b, 5, 1).Hash()); tx == nil || tx.Nonce() != 5 {
+		t.Fatalf("get mismatch: %v", tx)
+	}
+	if tx := pool.Get(testTx(b, 6, 1).Hash()); tx != nil {
+		t.Fatalf("unexpected tx %v", tx)
+	}
+}
+
+func TestPendingAccount(t *testing.T) {
+	a := types.Address{0x1}
+	pool := newTestPool(t, testConfig(), a)
+	pool.NewTx(testTx(a, 1, 1))
+	pool.NewTx(testTx(a, 2, 1))
+	pool.NewTx(testTx(a, 5, 1)) //queue 里的交易不算
+
+	account := pool.PendingAccount(a)
+	if account.Nonce != 2 {
+		t.Fatalf("pending nonce mismatch: have %d, want 2", account.Nonce)
+	}
+	if want := uint64(1000000 - 2*21001); account.Amount.Uint64() != want {
+		t.Fatalf("pending balance mismatch: have %d, want %d", account.Amount.Uint64(), want)
+	}
+	if account := pool.PendingAccount(types.Address{0x2}); account.Nonce != 0 || account.Amount.Uint64() != 0 {
+		t.Fatalf("unknown account: %+v", account)
+	}
+}
+
+func TestDynamicFeeTx(t *testing.T) {
+	a := types.Address{0x1}
+	pool := newTestPool(t, testConfig(), a)
+	dynTx := func(feeCap, tipCap uint64) *types.Transaction {
+		return types.NewDynamicFeeTransaction(1, types.Address{0xff}, a, uint256.NewInt(1), 21000, feeCap, tipCap, nil)
+	}
+	if err := pool.NewTx(dynTx(5, 6)); !errors.Is(err, ErrTipAboveFeeCap) {
+		t.Fatalf("have %v, want %v", err, ErrTipAboveFeeCap)
+	}
+	if err := pool.NewTx(dynTx(10, 2)); err != nil {
+		t.Fatal(err)
+	}
+	// 替换交易的 fee cap 和小费都要提高
+	if err := pool.NewTx(dynTx(20, 2)); !errors.Is(err, ErrReplaceUnderpriced) {
+		t.Fatalf("have %v, want %v", err, ErrReplaceUnderpriced)
+	}
+	if err := pool.NewTx(dynTx(20, 3)); err != nil {
+		t.Fatal(err)
+	}
+}
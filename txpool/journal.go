@@ -0,0 +1,103 @@
+package txpool
+
+import (
+	"blockchain/types"
+	"blockchain/utils/rlp"
+	"errors"
+	"io"
+	"os"
+)
+
+// errNoActiveJournal 表示日志还没有打开，replay 的时候不会把交易重新写回日志
+var errNoActiveJournal = errors.New("no active journal")
+
+// txJournal 把本地提交的交易追加写到磁盘上，节点重启之后可以重新放回交易池。
+// 文件就是一串 RLP 编码的交易，崩溃时最后一条可能只写了一半，加载时忽略它
+type txJournal struct {
+	path   string
+	writer io.WriteCloser
+}
+
+func newTxJournal(path string) *txJournal {
+	return &txJournal{path: path}
+}
+
+// load 读出日志里的所有交易交给 add，返回读出的交易数和被 add 拒绝的交易数。
+// 文件不存在不是错误；读到损坏的数据时停止，已经读出的交易照常加入
+func (journal *txJournal) load(add func(tx *types.Transaction) error) (int, int, error) {
+	input, err := os.Open(journal.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer input.Close()
+
+	stream := rlp.NewStream(input, 0)
+	total, dropped := 0, 0
+	for {
+		tx := new(types.Transaction)
+		if err := stream.Decode(tx); err != nil {
+			if errors.Is(err, io.EOF) {
+				return total, dropped, nil
+			}
+			return total, dropped, err
+		}
+		total++
+		if add(tx) != nil {
+			dropped++
+		}
+	}
+}
+
+// insert 把一笔交易追加到日志末尾
+func (journal *txJournal) insert(tx *types.Transaction) error {
+	if journal.writer == nil {
+		return errNoActiveJournal
+	}
+	return rlp.Encode(journal.writer, tx)
+}
+
+// rotate 用交易池里还在的交易重新生成日志，先写临时文件再改名，中途崩溃不会丢掉旧的日志
+func (journal *txJournal) rotate(all map[types.Address][]*types.Transaction) error {
+	if journal.writer != nil {
+		if err := journal.writer.Close(); err != nil {
+			return err
+		}
+		journal.writer = nil
+	}
+	replacement, err := os.OpenFile(journal.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, txs := range all {
+		for _, tx := range txs {
+			if err := rlp.Encode(replacement, tx); err != nil {
+				replacement.Close()
+				return err
+			}
+		}
+	}
+	if err := replacement.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(journal.path+".new", journal.path); err != nil {
+		return err
+	}
+	sink, err := os.OpenFile(journal.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	journal.writer = sink
+	return nil
+}
+
+func (journal *txJournal) close() error {
+	var err error
+	if journal.writer != nil {
+		err = journal.writer.Close()
+		journal.writer = nil
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package txpool
+
+import (
+	"blockchain/statdb"
+	"blockchain/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+// 重启之后日志里的交易回到交易池，已经失效的交易被丢掉，日志只保留剩下的交易
+func TestJournalReplay(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	config := testConfig()
+	config.Journal = filepath.Join(t.TempDir(), "transactions.rlp")
+
+	state := statdb.NewMemoryStatDB()
+	state.Store(a, types.Account{Amount: *uint256.NewInt(1000000)})
+	state.Store(b, types.Account{Amount: *uint256.NewInt(1000000)})
+	state.Commit()
+
+	pool := NewDefaultPoolWithConfig(state, config)
+	pool.AddLocal(testTx(a, 1, 1))
+	pool.AddLocal(testTx(a, 2, 1))
+	pool.AddLocal(testTx(b, 1, 1))
+	pool.AddLocal(testTx(b, 5, 1))
+	pool.Stop()
+
+	// a 的第一笔交易已经上链
+	state.Store(a, types.Account{Amount: *uint256.NewInt(1000000), Nonce: 1})
+	state.Commit()
+
+	pool = NewDefaultPoolWithConfig(state, config)
+	if !equalNonces(pendingNonces(pool, a), []uint64{2}) {
+		t.Fatalf("a pending mismatch: %v", pendingNonces(pool, a))
+	}
+	if !equalNonces(pendingNonces(pool, b), []uint64{1}) || !equalNonces(queuedNonces(pool, b), []uint64{5}) {
+		t.Fatalf("b mismatch: pending %v queued %v", pendingNonces(pool, b), queuedNonces(pool, b))
+	}
+	pool.Stop()
+
+	// 加载之后日志被重写，只剩下 3 笔交易
+	journal := newTxJournal(config.Journal)
+	total, _, err := journal.load(func(*types.Transaction) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Fatalf("journal has %d txs after rotation, want 3", total)
+	}
+}
+
+// 最后一条只写了一半的日志不影响前面的交易
+func TestJournalTruncated(t *testing.T) {
+	a := types.Address{0x1}
+	config := testConfig()
+	config.Journal = filepath.Join(t.TempDir(), "transactions.rlp")
+
+	pool, state := newTestPoolWithState(t, config, a)
+	pool.AddLocal(testTx(a, 1, 1))
+	pool.AddLocal(testTx(a, 2, 1))
+	pool.Stop()
+
+	data, err := os.ReadFile(config.Journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.Journal, data[:len(data)-5], 0644); err != nil {
+		t.Fatal(err)
+	}
+	pool = NewDefaultPoolWithConfig(state, config)
+	defer pool.Stop()
+	if !equalNonces(pendingNonces(pool, a), []uint64{1}) {
+		t.Fatalf("pending mismatch: %v", pendingNonces(pool, a))
+	}
+}
@@ -0,0 +1,107 @@
+package txpool
+
+import (
+	"blockchain/types"
+	"bytes"
+	"container/heap"
+)
+
+// priceHeap 是每个账户下一笔交易组成的大根堆，在 baseFee 下矿工得到的小费高的在堆顶
+type priceHeap struct {
+	baseFee uint64
+	list    []*types.Transaction
+}
+
+func (h *priceHeap) Len() int { return len(h.list) }
+
+func (h *priceHeap) Less(i, j int) bool {
+	tip1, tip2 := effectiveTip(h.list[i], h.baseFee), effectiveTip(h.list[j], h.baseFee)
+	if tip1 != tip2 {
+		return tip1 > tip2
+	}
+	//小费一样时按地址排，保证结果是确定的
+	from1, from2 := h.list[i].From(), h.list[j].From()
+	return bytes.Compare(from1[:], from2[:]) < 0
+}
+
+func (h *priceHeap) Swap(i, j int) { h.list[i], h.list[j] = h.list[j], h.list[i] }
+
+func (h *priceHeap) Push(x any) {
+	h.list = append(h.list, x.(*types.Transaction))
+}
+
+func (h *priceHeap) Pop() any {
+	old := h.list
+	n := len(old)
+	tx := old[n-1]
+	old[n-1] = nil
+	h.list = old[:n-1]
+	return tx
+}
+
+// effectiveTip 是交易在 baseFee 下给矿工的小费，付不起 baseFee 时为 0
+func effectiveTip(tx *types.Transaction, baseFee uint64) uint64 {
+	tip, err := tx.EffectiveGasTip(baseFee)
+	if err != nil {
+		return 0
+	}
+	return tip
+}
+
+// TxsByPriceAndNonce 按矿工得到的小费从高到低给出交易，同一个账户的交易始终按 nonce 顺序给出。
+// 堆里只放每个账户的下一笔交易，取走之后才把这个账户的下一笔放进堆，所以不会打乱 nonce 顺序。
+type TxsByPriceAndNonce struct {
+	txs   map[types.Address][]*types.Transaction //每个账户剩下的交易，按 nonce 排好
+	heads *priceHeap
+}
+
+// NewTxsByPriceAndNonce 的 txs 里每个账户的交易必须已经按 nonce 排好，例如 TxPool.Pending 的结果。
+// baseFee 是要打包的区块的 base fee，下一笔交易付不起 baseFee 的账户整个跳过。txs 会被修改。
+func NewTxsByPriceAndNonce(txs map[types.Address][]*types.Transaction, baseFee uint64) *TxsByPriceAndNonce {
+	heads := &priceHeap{baseFee: baseFee, list: make([]*types.Transaction, 0, len(txs))}
+	for from, list := range txs {
+		if len(list) == 0 || list[0].GasFeeCap() < baseFee {
+			delete(txs, from)
+			continue
+		}
+		heads.list = append(heads.list, list[0])
+		txs[from] = list[1:]
+	}
+	heap.Init(heads)
+	return &TxsByPriceAndNonce{
+		txs:   txs,
+		heads: heads,
+	}
+}
+
+// Peek 返回当前小费最高的交易，没有交易时返回nil
+func (t *TxsByPriceAndNonce) Peek() *types.Transaction {
+	if t.heads.Len() == 0 {
+		return nil
+	}
+	return t.heads.list[0]
+}
+
+// Shift 用同一个账户的下一笔交易替换堆顶，交易执行成功之后调用
+func (t *TxsByPriceAndNonce) Shift() {
+	if t.heads.Len() == 0 {
+		return
+	}
+	from := t.heads.list[0].From()
+	if list := t.txs[from]; len(list) > 0 && list[0].GasFeeCap() >= t.heads.baseFee {
+		t.heads.list[0], t.txs[from] = list[0], list[1:]
+		heap.Fix(t.heads, 0)
+		return
+	}
+	delete(t.txs, from)
+	heap.Pop(t.heads)
+}
+
+// Pop 丢掉堆顶账户剩下的所有交易，堆顶交易无效时调用，这个账户后面的交易也都执行不了
+func (t *TxsByPriceAndNonce) Pop() {
+	if t.heads.Len() == 0 {
+		return
+	}
+	delete(t.txs, t.heads.list[0].From())
+	heap.Pop(t.heads)
+}
@@ -0,0 +1,125 @@
+package txpool
+
+import (
+	"blockchain/types"
+	"math/rand"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestTxsByPriceAndNonceSimple(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	// a 的第二笔交易很贵，但是必须等 a 的第一笔
+	txs := NewTxsByPriceAndNonce(map[types.Address][]*types.Transaction{
+		a: {testTx(a, 1, 1), testTx(a, 2, 10)},
+		b: {testTx(b, 1, 5)},
+	}, 0)
+	want := []*types.Transaction{testTx(b, 1, 5), testTx(a, 1, 1), testTx(a, 2, 10)}
+	for i, w := range want {
+		tx := txs.Peek()
+		if tx == nil || tx.Hash() != w.Hash() {
+			t.Fatalf("tx %d mismatch: have %v, want %v", i, tx, w)
+		}
+		txs.Shift()
+	}
+	if tx := txs.Peek(); tx != nil {
+		t.Fatalf("unexpected tx %v", tx)
+	}
+}
+
+// 随机生成交易，检查每次给出的都是当前各账户下一笔交易里最贵的，并且账户内 nonce 连续递增
+func TestTxsByPriceAndNonceRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	groups := make(map[types.Address][]*types.Transaction)
+	total := 0
+	for i := 0; i < 50; i++ {
+		addr := types.Address{byte(i + 1)}
+		n := r.Intn(10) + 1
+		for nonce := 1; nonce <= n; nonce++ {
+			groups[addr] = append(groups[addr], testTx(addr, uint64(nonce), uint64(r.Intn(100))))
+		}
+		total += n
+	}
+	expected := make(map[types.Address][]*types.Transaction)
+	for addr, list := range groups {
+		expected[addr] = append([]*types.Transaction(nil), list...)
+	}
+
+	txs := NewTxsByPriceAndNonce(groups, 0)
+	count := 0
+	for tx := txs.Peek(); tx != nil; tx = txs.Peek() {
+		from := tx.From()
+		if tx.Hash() != expected[from][0].Hash() {
+			t.Fatalf("nonce order broken for %x: have %d, want %d", from, tx.Nonce(), expected[from][0].Nonce())
+		}
+		for addr, list := range expected {
+			if addr != from && len(list) > 0 && list[0].GasPrice() > tx.GasPrice() {
+				t.Fatalf("tx with price %d returned before head of %x with price %d", tx.GasPrice(), addr, list[0].GasPrice())
+			}
+		}
+		expected[from] = expected[from][1:]
+		txs.Shift()
+		count++
+	}
+	if count != total {
+		t.Fatalf("returned %d txs, want %d", count, total)
+	}
+}
+
+// Pop 丢掉整个账户
+func TestTxsByPriceAndNoncePop(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	txs := NewTxsByPriceAndNonce(map[types.Address][]*types.Transaction{
+		a: {testTx(a, 1, 10), testTx(a, 2, 10)},
+		b: {testTx(b, 1, 5)},
+	}, 0)
+	txs.Pop()
+	if tx := txs.Peek(); tx == nil || tx.From() != b {
+		t.Fatalf("have %v, want b's tx", tx)
+	}
+	txs.Shift()
+	if tx := txs.Peek(); tx != nil {
+		t.Fatalf("unexpected tx %v", tx)
+	}
+}
+
+// 交易池的 Pending 交给 TxsByPriceAndNonce，按价格取每个账户的下一笔
+func TestPoolPendingOrder(t *testing.T) {
+	a, b := types.Address{0x1}, types.Address{0x2}
+	pool := newTestPool(t, testConfig(), a, b)
+	pool.NewTx(testTx(a, 1, 1))
+	pool.NewTx(testTx(a, 2, 10))
+	pool.NewTx(testTx(b, 1, 5))
+
+	txs := NewTxsByPriceAndNonce(pool.Pending(), 0)
+	for i, want := range []*types.Transaction{testTx(b, 1, 5), testTx(a, 1, 1), testTx(a, 2, 10)} {
+		if tx := txs.Peek(); tx == nil || tx.Hash() != want.Hash() {
+			t.Fatalf("tx %d: have %v, want %v", i, tx, want)
+		}
+		txs.Shift()
+	}
+	if tx := txs.Peek(); tx != nil {
+		t.Fatalf("unexpected tx %v", tx)
+	}
+}
+
+// 按 base fee 之后的小费排序，付不起 base fee 的账户被跳过
+func TestTxsByPriceAndNonceBaseFee(t *testing.T) {
+	a, b, c := types.Address{0x1}, types.Address{0x2}, types.Address{0x3}
+	txA := types.NewDynamicFeeTransaction(1, types.Address{0xff}, a, uint256.NewInt(1), 21000, 100, 3, nil) //小费 3
+	txB := types.NewTransaction(1, types.Address{0xff}, b, uint256.NewInt(1), 21000, 15, nil)               //小费 5
+	txC := types.NewDynamicFeeTransaction(1, types.Address{0xff}, c, uint256.NewInt(1), 21000, 9, 9, nil)   //付不起 base fee
+	txs := NewTxsByPriceAndNonce(map[types.Address][]*types.Transaction{
+		a: {txA}, b: {txB}, c: {txC},
+	}, 10)
+	for i, want := range []*types.Transaction{txB, txA} {
+		if tx := txs.Peek(); tx == nil || tx.Hash() != want.Hash() {
+			t.Fatalf("tx %d: have %v, want %v", i, tx, want)
+		}
+		txs.Shift()
+	}
+	if tx := txs.Peek(); tx != nil {
+		t.Fatalf("unexpected tx %v", tx)
+	}
+}
@@ -6,8 +6,15 @@ import (
 )
 
 type TxPool interface {
-	NewTx(tx *types.Transaction)
-	PoP() *types.Transaction
+	NewTx(tx *types.Transaction) error               //加入远程交易，被拒绝时返回原因
+	AddLocal(tx *types.Transaction) error            //加入本节点用户提交的交易
+	Pending() map[types.Address][]*types.Transaction //每个账户可以执行的交易，按 nonce 排列
+	PendingAccount(addr types.Address) types.Account //执行完所有 pending 交易之后的 nonce 和余额
+	Status() (pending int, queued int)
+	Content() (pending map[types.Address][]*types.Transaction, queued map[types.Address][]*types.Transaction)
+	ContentFrom(addr types.Address) (pending []*types.Transaction, queued []*types.Transaction)
+	Get(h hash.Hash) *types.Transaction //不在交易池里时返回nil
+	IsLocal(tx *types.Transaction) bool
 	SetStatRoot(root hash.Hash)
 	NotifyTxEvent(txs []*types.Transaction)
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/hexutil"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/holiman/uint256"
+)
+
+var errTxNotFound = errors.New("transaction not found")
+
+// RPCTransaction 是查询接口返回的交易
+type RPCTransaction struct {
+	Hash     string       `json:"hash"`
+	From     string       `json:"from"`
+	To       string       `json:"to,omitempty"` //创建合约的交易没有 To
+	Nonce    uint64       `json:"nonce"`
+	Value    *uint256.Int `json:"value"`
+	Gas      uint64       `json:"gas"`
+	GasPrice uint64       `json:"gasPrice"`
+	Local    bool         `json:"local"`
+	Input    string       `json:"input,omitempty"`
+
+	Type                 uint8  `json:"type"`
+	MaxFeePerGas         uint64 `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas uint64 `json:"maxPriorityFeePerGas,omitempty"`
+}
+
+// TxPoolStatusResponse 是 TXPOOL_STATUS 的结果
+type TxPoolStatusResponse struct {
+	Pending int `json:"pending"`
+	Queued  int `json:"queued"`
+}
+
+// TxPoolContentResponse 是 TXPOOL_CONTENT 的结果，交易按发送方和 nonce 分组
+type TxPoolContentResponse struct {
+	Pending map[string]map[string]*RPCTransaction `json:"pending"`
+	Queued  map[string]map[string]*RPCTransaction `json:"queued"`
+}
+
+// TxPoolContentFromResponse 是 TXPOOL_CONTENT_FROM 的结果，交易按 nonce 分组
+type TxPoolContentFromResponse struct {
+	Pending map[string]*RPCTransaction `json:"pending"`
+	Queued  map[string]*RPCTransaction `json:"queued"`
+}
+
+func (n *node) newRPCTransaction(tx *types.Transaction) *RPCTransaction {
+	from := tx.From()
+	rpcTx := &RPCTransaction{
+		Hash:     tx.Hash().Hex(),
+		From:     hexutil.Encode(from[:]),
+		Nonce:    tx.Nonce(),
+		Value:    tx.Value(),
+		Gas:      tx.Gas,
+		GasPrice: tx.GasPrice(),
+		Local:    n.blockchain.Txpool.IsLocal(tx),
+		Type:     tx.Type(),
+	}
+	if to := tx.To(); to != nil {
+		rpcTx.To = hexutil.Encode(to[:])
+	}
+	if len(tx.Input()) > 0 {
+		rpcTx.Input = hexutil.Encode(tx.Input())
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		rpcTx.MaxFeePerGas = tx.GasFeeCap()
+		rpcTx.MaxPriorityFeePerGas = tx.GasTipCap()
+	}
+	return rpcTx
+}
+
+func (n *node) groupByNonce(txs []*types.Transaction) map[string]*RPCTransaction {
+	group := make(map[string]*RPCTransaction, len(txs))
+	for _, tx := range txs {
+		group[strconv.FormatUint(tx.Nonce(), 10)] = n.newRPCTransaction(tx)
+	}
+	return group
+}
+
+func (n *node) groupBySender(txs map[types.Address][]*types.Transaction) map[string]map[string]*RPCTransaction {
+	group := make(map[string]map[string]*RPCTransaction, len(txs))
+	for addr, list := range txs {
+		group[hexutil.Encode(addr[:])] = n.groupByNonce(list)
+	}
+	return group
+}
+
+func (n *node) handleTxPoolStatusRequest(conn net.Conn) {
+	pending, queued := n.blockchain.Txpool.Status()
+	writeResponse(conn, TxPoolStatusResponse{Pending: pending, Queued: queued})
+}
+
+func (n *node) handleTxPoolContentRequest(conn net.Conn) {
+	pending, queued := n.blockchain.Txpool.Content()
+	writeResponse(conn, TxPoolContentResponse{
+		Pending: n.groupBySender(pending),
+		Queued:  n.groupBySender(queued),
+	})
+}
+
+func (n *node) handleTxPoolContentFromRequest(conn net.Conn, address string) {
+	addr, err := parseAddress(address)
+	if err != nil {
+		writeResponse(conn, TransactionResponse{Error: err.Error()})
+		return
+	}
+	pending, queued := n.blockchain.Txpool.ContentFrom(addr)
+	writeResponse(conn, TxPoolContentFromResponse{
+		Pending: n.groupByNonce(pending),
+		Queued:  n.groupByNonce(queued),
+	})
+}
+
+func (n *node) handleTxPoolGetRequest(conn net.Conn, txHash string) {
+	h, err := hexutil.Decode(txHash)
+	if err != nil || len(h) != len(hash.Hash{}) {
+		writeResponse(conn, TransactionResponse{Error: "invalid transaction hash"})
+		return
+	}
+	tx := n.blockchain.Txpool.Get(hash.BytesToHash(h))
+	if tx == nil {
+		writeResponse(conn, TransactionResponse{Error: errTxNotFound.Error()})
+		return
+	}
+	writeResponse(conn, n.newRPCTransaction(tx))
+}
+
+// parseAddress 解析十六进制地址，长度不对时返回错误
+func parseAddress(s string) (types.Address, error) {
+	var addr types.Address
+	b, err := hexutil.Decode(s)
+	if err != nil {
+		return addr, err
+	}
+	if len(b) != len(addr) {
+		return addr, errors.New("invalid address length")
+	}
+	copy(addr[:], b)
+	return addr, nil
+}
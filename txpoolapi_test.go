@@ -0,0 +1,116 @@
+package main
+
+import (
+	"blockchain/types"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestTxPoolQueries(t *testing.T) {
+	var (
+		sender    = testAddress("0x9B682e9770C315f43954e37D8880a6Be815A3E53")
+		recipient = types.Address{0x42}
+		n         = newTestNode(t)
+	)
+	pending := types.NewTransaction(1, recipient, sender, uint256.NewInt(1), 21000, 0, nil)
+	queued := types.NewTransaction(3, recipient, sender, uint256.NewInt(1), 21000, 0, nil)
+	n.blockchain.Txpool.AddLocal(pending)
+	n.blockchain.Txpool.NewTx(queued)
+
+	server, client := net.Pipe()
+	defer client.Close()
+	go n.handleConnection(server)
+	reader := bufio.NewReader(client)
+	query := func(request string, response interface{}) {
+		if _, err := fmt.Fprintln(client, request); err != nil {
+			t.Fatal(err)
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(line, response); err != nil {
+			t.Fatalf("%s: %v", request, err)
+		}
+	}
+
+	var status TxPoolStatusResponse
+	query("TXPOOL_STATUS", &status)
+	if status.Pending != 1 || status.Queued != 1 {
+		t.Fatalf("status mismatch: %+v", status)
+	}
+
+	var content TxPoolContentResponse
+	query("TXPOOL_CONTENT", &content)
+	from := "0x9b682e9770c315f43954e37d8880a6be815a3e53"
+	if tx := content.Pending[from]["1"]; tx == nil || tx.Hash != pending.Hash().Hex() || !tx.Local {
+		t.Fatalf("pending content mismatch: %+v", content.Pending)
+	}
+	if tx := content.Queued[from]["3"]; tx == nil || tx.Hash != queued.Hash().Hex() {
+		t.Fatalf("queued content mismatch: %+v", content.Queued)
+	}
+
+	var contentFrom TxPoolContentFromResponse
+	query("TXPOOL_CONTENT_FROM "+from, &contentFrom)
+	if len(contentFrom.Pending) != 1 || len(contentFrom.Queued) != 1 {
+		t.Fatalf("content from mismatch: %+v", contentFrom)
+	}
+	var invalid TransactionResponse
+	query("TXPOOL_CONTENT_FROM 0x42", &invalid)
+	if invalid.Error == "" {
+		t.Fatal("invalid address accepted")
+	}
+
+	var tx RPCTransaction
+	query("TXPOOL_GET "+queued.Hash().Hex(), &tx)
+	if tx.Nonce != 3 || tx.From != from {
+		t.Fatalf("get mismatch: %+v", tx)
+	}
+	var missing TransactionResponse
+	query("TXPOOL_GET "+types.NewTransaction(9, recipient, sender, uint256.NewInt(1), 21000, 0, nil).Hash().Hex(), &missing)
+	if missing.Error != errTxNotFound.Error() {
+		t.Fatalf("have %q, want %q", missing.Error, errTxNotFound)
+	}
+}
+
+func TestAccountStatusTags(t *testing.T) {
+	var (
+		sender = "0x9B682e9770C315f43954e37D8880a6Be815A3E53"
+		n      = newTestNode(t)
+	)
+	for nonce := uint64(1); nonce <= 2; nonce++ {
+		n.blockchain.Txpool.NewTx(types.NewTransaction(nonce, types.Address{0x42}, testAddress(sender), uint256.NewInt(10), 21000, 0, nil))
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	go n.handleConnection(server)
+	reader := bufio.NewReader(client)
+	query := func(request string) (AccountStatusResponse, TransactionResponse) {
+		fmt.Fprintln(client, request)
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status AccountStatusResponse
+		var errResp TransactionResponse
+		json.Unmarshal(line, &status)
+		json.Unmarshal(line, &errResp)
+		return status, errResp
+	}
+	for _, request := range []string{"GET_ACCOUNT_STATUS " + sender, "GET_ACCOUNT_STATUS " + sender + " latest"} {
+		if status, _ := query(request); status.Nonce != 0 || status.Balance == nil || status.Balance.Uint64() != devAccountBalance {
+			t.Fatalf("%s: %+v", request, status)
+		}
+	}
+	if status, _ := query("GET_ACCOUNT_STATUS " + sender + " pending"); status.Nonce != 2 || status.Balance == nil || status.Balance.Uint64() != devAccountBalance-20 {
+		t.Fatalf("pending status mismatch: %+v", status)
+	}
+	if _, resp := query("GET_ACCOUNT_STATUS " + sender + " earliest"); resp.Error == "" {
+		t.Fatal("unknown tag accepted")
+	}
+}
@@ -3,10 +3,12 @@ package types
 import (
 	"blockchain/utils/hash"
 	"blockchain/utils/rlp"
+
+	"github.com/holiman/uint256"
 )
 
 type Account struct {
-	Amount   uint64
+	Amount   uint256.Int //余额是 256 位的，加减都要检查溢出
 	Nonce    uint64
 	CodeHash hash.Hash
 	Root     hash.Hash
@@ -2,6 +2,8 @@ package types
 
 import (
 	"blockchain/crypto/sha3"
+	"blockchain/utils/hash"
+	"blockchain/utils/rlp"
 )
 
 type Address [20]byte
@@ -12,3 +14,26 @@ func PubKeyToAddress(pub []byte) Address {
 	copy(address[:], h[12:])
 	return address
 }
+
+// CreateAddress 是 b 用 nonce 创建的合约地址：keccak256(rlp([b, nonce])) 的后 20 字节
+func CreateAddress(b Address, nonce uint64) Address {
+	data, _ := rlp.EncodeToBytes([]interface{}{b, nonce})
+	h := sha3.Keccak256(data)
+	var address Address
+	copy(address[:], h[12:])
+	return address
+}
+
+// CreateAddress2 是 b 用 salt 和初始化代码的hash创建的合约地址（CREATE2）：
+// keccak256(0xff ++ b ++ salt ++ inithash) 的后 20 字节，和 nonce 无关，部署之前就能算出来
+func CreateAddress2(b Address, salt hash.Hash, inithash []byte) Address {
+	data := make([]byte, 0, 1+len(b)+len(salt)+len(inithash))
+	data = append(data, 0xff)
+	data = append(data, b[:]...)
+	data = append(data, salt[:]...)
+	data = append(data, inithash...)
+	h := sha3.Keccak256(data)
+	var address Address
+	copy(address[:], h[12:])
+	return address
+}
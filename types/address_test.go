@@ -0,0 +1,56 @@
+package types
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/utils/hash"
+	"blockchain/utils/hexutil"
+	"testing"
+)
+
+func TestCreateAddress(t *testing.T) {
+	var from Address
+	copy(from[:], hexutil.MustDecode("0x970e8128ab834e8eac17ab8e3812f010678cf791"))
+	for nonce, want := range []string{
+		"0x333c3310824b7c685133f2bedb2ca4b8b4df633d",
+		"0x8bda78331c916a08481428e4b07c96d3e916d165",
+		"0xc9ddedf451bc62ce88bf9292afb13df35b670699",
+	} {
+		if have := CreateAddress(from, uint64(nonce)); hexutil.Encode(have[:]) != want {
+			t.Errorf("nonce %d: have %x, want %s", nonce, have, want)
+		}
+	}
+}
+
+// EIP-1014 的例子
+func TestCreateAddress2(t *testing.T) {
+	for i, tt := range []struct {
+		origin, salt, code, want string
+	}{
+		{
+			"0x0000000000000000000000000000000000000000",
+			"0x0000000000000000000000000000000000000000000000000000000000000000",
+			"0x00",
+			"0x4d1a2e2bb4f88f0250f26ffff098b0b30b26bf38",
+		},
+		{
+			"0xdeadbeef00000000000000000000000000000000",
+			"0x0000000000000000000000000000000000000000000000000000000000000000",
+			"0x00",
+			"0xb928f69bb1d91cd65274e3c79d8986362984fda3",
+		},
+		{
+			"0x00000000000000000000000000000000deadbeef",
+			"0x00000000000000000000000000000000000000000000000000000000cafebabe",
+			"0xdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef",
+			"0x1d8bfdc5d46dc4f61d6b6115972536ebe6a8854c",
+		},
+	} {
+		var origin Address
+		copy(origin[:], hexutil.MustDecode(tt.origin))
+		codeHash := sha3.Keccak256(hexutil.MustDecode(tt.code))
+		have := CreateAddress2(origin, hash.BytesToHash(hexutil.MustDecode(tt.salt)), codeHash[:])
+		if hexutil.Encode(have[:]) != tt.want {
+			t.Errorf("test %d: have %x, want %s", i, have, tt.want)
+		}
+	}
+}
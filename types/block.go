@@ -0,0 +1,53 @@
+package types
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/utils/hash"
+	"blockchain/utils/rlp"
+)
+
+type Header struct {
+	Root       hash.Hash
+	ParentHash hash.Hash
+	Height     uint64
+	Coinbase   Address
+	Timestamp  uint64
+	Nonce      uint64
+	//TODO: Add difficulty
+
+	//旧的区块没有这些字段，编码和hash都不变
+	GasLimit uint64 `rlp:"optional"`
+	GasUsed  uint64 `rlp:"optional"`
+	BaseFee  uint64 `rlp:"optional"` //每单位gas被销毁的费用，按父区块的 GasUsed 调整
+}
+
+type Body struct {
+	Transactions []Transaction
+	Receiptions  []Receiption
+}
+
+func (header Header) Hash() hash.Hash {
+	data, _ := rlp.EncodeToBytes(header)
+	return sha3.Keccak256(data)
+}
+
+func NewHeader(parent Header) *Header {
+	return &Header{
+		Root:       parent.Root,
+		ParentHash: parent.Hash(),
+		Height:     parent.Height + 1,
+	}
+}
+
+func NewBlockBody() *Body {
+	return &Body{
+		Transactions: make([]Transaction, 0),
+		Receiptions:  make([]Receiption, 0),
+	}
+}
+
+// ChainHeadEvent 在新区块成为最新区块之后发出
+type ChainHeadEvent struct {
+	Header *Header
+	Body   *Body
+}
@@ -0,0 +1,10 @@
+package types
+
+import "blockchain/utils/hash"
+
+// Log 是合约执行 LOG 指令留下的记录，Topics 用来检索，Data 是任意数据
+type Log struct {
+	Address Address
+	Topics  []hash.Hash
+	Data    []byte
+}
@@ -1,17 +1,51 @@
 package types
 
 import (
+	"blockchain/crypto"
 	"blockchain/crypto/secp256k1"
 	"blockchain/crypto/sha3"
 	"blockchain/utils/hash"
+	"blockchain/utils/math"
 	"blockchain/utils/rlp"
+	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"math/big"
+
+	"github.com/holiman/uint256"
+)
+
+const (
+	LegacyTxType     = 0 //只有 GasPrice
+	DynamicFeeTxType = 2 //EIP-1559 交易，GasFeeCap 是愿意付的最高单价，GasTipCap 是最多给矿工的小费
+)
+
+var ErrGasFeeCapTooLow = errors.New("max fee per gas less than block base fee")
+
+const (
+	ReceiptStatusSuccessful = 0
+	ReceiptStatusFailed     = 1 //交易执行失败，修改被回滚，但是gas费照常扣除
 )
 
 type Receiption struct {
-	TxHash hash.Hash
-	Status int
+	TxHash  hash.Hash
+	Status  uint64
+	GasUsed uint64
+
+	Logs []*Log `rlp:"optional"` //合约执行留下的日志，执行失败时为空；旧的收据没有这个字段
+
+	ContractAddress Address `rlp:"optional"` //创建合约的交易得到的合约地址，其他交易为零值，编码不变
+}
+
+// Hash 是收据 RLP 编码的hash，验证区块时用它比较收据
+func (r Receiption) Hash() hash.Hash {
+	data, _ := rlp.EncodeToBytes(r)
+	return sha3.Keccak256(data)
+}
+
+// NewTxsEvent 在交易进入交易池或者本地交易需要重新广播时发出
+type NewTxsEvent struct {
+	Txs []*Transaction
 }
 
 type Transaction struct {
@@ -20,13 +54,19 @@ type Transaction struct {
 }
 
 type Txdata struct {
-	Sender   Address //测试使用，当发送签名交易的时候需要删除
-	To       Address
+	Sender   Address  //测试使用，当发送签名交易的时候需要删除
+	To       *Address `rlp:"nil"` //nil 表示创建合约；非 nil 的地址编码和以前一样，零地址仍然是普通转账
 	Nonce    uint64
-	Value    uint64
+	Value    uint256.Int
 	Gas      uint64
 	GasPrice uint64
-	//Input    []byte
+
+	//EIP-1559 交易的字段，旧的交易没有这些字段，编码和hash都不变
+	Type      uint8  `rlp:"optional"`
+	GasFeeCap uint64 `rlp:"optional"` //maxFeePerGas
+	GasTipCap uint64 `rlp:"optional"` //maxPriorityFeePerGas
+
+	Input []byte `rlp:"optional"` //调用合约的参数，没有 Input 的交易编码不变
 }
 
 type signature struct {
@@ -34,16 +74,14 @@ type signature struct {
 	V    uint8
 }
 
-func NewTransaction(nonce uint64, to Address, sender Address, value uint64, gas uint64, gasPrice uint64, input []byte) *Transaction {
-	return &Transaction{
+func NewTransaction(nonce uint64, to Address, sender Address, value *uint256.Int, gas uint64, gasPrice uint64, input []byte) *Transaction {
+	tx := &Transaction{
 		Txdata: Txdata{
 			Nonce:    nonce,
-			To:       to,
+			To:       &to,
 			Sender:   sender,
-			Value:    value,
 			Gas:      gas,
 			GasPrice: gasPrice,
-			//Input:    input,
 		},
 
 		signature: signature{
@@ -52,38 +90,149 @@ func NewTransaction(nonce uint64, to Address, sender Address, value uint64, gas
 			V: 0,
 		},
 	}
+	if value != nil {
+		tx.Txdata.Value.Set(value)
+	}
+	//空的 Input 保持为 nil，否则编码之后会多出一个字段，hash 就变了
+	if len(input) > 0 {
+		tx.Txdata.Input = input
+	}
+	return tx
+}
+
+// NewContractCreation 创建部署合约的交易，input 是初始化代码，它的返回值保存为合约的代码，
+// 合约地址由发送方和交易的 nonce 决定
+func NewContractCreation(nonce uint64, sender Address, value *uint256.Int, gas uint64, gasPrice uint64, input []byte) *Transaction {
+	tx := NewTransaction(nonce, Address{}, sender, value, gas, gasPrice, input)
+	tx.Txdata.To = nil
+	return tx
+}
+
+// NewDynamicFeeTransaction 创建 EIP-1559 交易，每单位gas实际付 min(gasFeeCap, baseFee+gasTipCap)，
+// 其中 baseFee 部分被销毁，剩下的小费给矿工
+func NewDynamicFeeTransaction(nonce uint64, to Address, sender Address, value *uint256.Int, gas uint64, gasFeeCap uint64, gasTipCap uint64, input []byte) *Transaction {
+	tx := NewTransaction(nonce, to, sender, value, gas, 0, input)
+	tx.Txdata.Type = DynamicFeeTxType
+	tx.Txdata.GasFeeCap = gasFeeCap
+	tx.Txdata.GasTipCap = gasTipCap
+	return tx
 }
 
 func (tx Transaction) From() Address {
 	return tx.Txdata.Sender
 }
-func (tx Transaction) To() Address {
-	return tx.Txdata.To
+
+// To 返回接收方地址的副本，创建合约的交易返回 nil
+func (tx Transaction) To() *Address {
+	if tx.Txdata.To == nil {
+		return nil
+	}
+	to := *tx.Txdata.To
+	return &to
 }
 
-func (tx Transaction) Value() uint64 {
-	return tx.Txdata.Value
+func (tx Transaction) Value() *uint256.Int {
+	return new(uint256.Int).Set(&tx.Txdata.Value)
+}
+func (tx Transaction) Input() []byte {
+	return tx.Txdata.Input
 }
 func (tx Transaction) Nonce() uint64 {
 	return tx.Txdata.Nonce
 }
+func (tx Transaction) Type() uint8 {
+	return tx.Txdata.Type
+}
+
+// GasPrice 是每单位gas最多付的价格，EIP-1559 交易就是 GasFeeCap
 func (tx Transaction) GasPrice() uint64 {
+	if tx.Txdata.Type == DynamicFeeTxType {
+		return tx.Txdata.GasFeeCap
+	}
 	return tx.Txdata.GasPrice
 }
+
+// GasFeeCap 是每单位gas最多付的价格，旧的交易就是 GasPrice
+func (tx Transaction) GasFeeCap() uint64 {
+	return tx.GasPrice()
+}
+
+// GasTipCap 是每单位gas最多给矿工的小费，旧的交易整个 GasPrice 都可以是小费
+func (tx Transaction) GasTipCap() uint64 {
+	if tx.Txdata.Type == DynamicFeeTxType {
+		return tx.Txdata.GasTipCap
+	}
+	return tx.Txdata.GasPrice
+}
+
+// EffectiveGasTip 是在 baseFee 下矿工实际得到的每单位gas小费，GasFeeCap 付不起 baseFee 时返回错误
+func (tx Transaction) EffectiveGasTip(baseFee uint64) (uint64, error) {
+	feeCap := tx.GasFeeCap()
+	if feeCap < baseFee {
+		return 0, ErrGasFeeCapTooLow
+	}
+	if tip := tx.GasTipCap(); tip < feeCap-baseFee {
+		return tip, nil
+	}
+	return feeCap - baseFee, nil
+}
+
+// Cost 是发送方执行这笔交易最多需要的余额，value 太大的时候超过 256 位，overflow 为 true，任何余额都付不起
+func (tx Transaction) Cost() (cost *uint256.Int, overflow bool) {
+	return math.SafeAddU256(&tx.Txdata.Value, math.MulU64(tx.Txdata.Gas, tx.GasFeeCap()))
+}
 func (tx Transaction) Hash() hash.Hash {
 	data, _ := rlp.EncodeToBytes(tx)
 	return sha3.Keccak256(data)
 }
 
+// Size 是交易 RLP 编码之后的字节数
+func (tx Transaction) Size() int {
+	data, _ := rlp.EncodeToBytes(tx)
+	return len(data)
+}
+
+// SigHash 是签名的消息，即交易数据的 RLP 编码的hash
+func (tx Transaction) SigHash() hash.Hash {
+	data, _ := rlp.EncodeToBytes(tx.Txdata)
+	return sha3.Keccak256(data)
+}
+
+// WithSignature 返回带有签名的交易副本，sig 是 [R || S || V] 格式的 65 字节签名，V 为 0 或 1
+func (tx *Transaction) WithSignature(sig []byte) (*Transaction, error) {
+	if len(sig) != 65 {
+		return nil, fmt.Errorf("wrong size for signature: got %d, want 65", len(sig))
+	}
+	cpy := *tx
+	cpy.signature = signature{
+		R: new(big.Int).SetBytes(sig[:32]),
+		S: new(big.Int).SetBytes(sig[32:64]),
+		V: sig[64],
+	}
+	return &cpy, nil
+}
+
+// SignTx 用私钥对交易签名
+func SignTx(tx *Transaction, prv *ecdsa.PrivateKey) (*Transaction, error) {
+	h := tx.SigHash()
+	sig, err := crypto.Sign(h[:], prv)
+	if err != nil {
+		return nil, err
+	}
+	return tx.WithSignature(sig)
+}
+
+// Verify 从签名恢复出公钥，检查它对应的地址是不是交易的发送方
 func (tx Transaction) Verify() bool {
-	txdata := tx.Txdata
-	toSign, err := rlp.EncodeToBytes(txdata)
-	fmt.Println(toSign, err)
-	msg := sha3.Keccak256(toSign)
+	r, s := tx.signature.R, tx.signature.S
+	if r == nil || s == nil || r.BitLen() > 256 || s.BitLen() > 256 {
+		return false
+	}
+	msg := tx.SigHash()
 	sig := make([]byte, 65)
-	sig = append(sig, tx.signature.R.Bytes()...)
-	sig = append(sig, tx.signature.S.Bytes()...)
-	sig = append(sig, tx.signature.V)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:64])
+	sig[64] = tx.signature.V
 
 	pubKey, err := secp256k1.RecoverPubkey(msg[:], sig)
 	if err != nil {
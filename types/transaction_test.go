@@ -0,0 +1,61 @@
+package types
+
+import (
+	"blockchain/crypto"
+	"blockchain/utils/rlp"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	from := PubKeyToAddress(crypto.FromECDSAPub(&key.PublicKey))
+
+	tx, err := SignTx(NewTransaction(1, Address{0x42}, from, uint256.NewInt(10), 21000, 1, nil), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.Verify() {
+		t.Fatal("valid signature rejected")
+	}
+	// 改了交易内容或者发送方之后签名无效
+	forged := *tx
+	forged.Txdata.Value.SetUint64(1000)
+	if forged.Verify() {
+		t.Fatal("signature valid for modified tx")
+	}
+	other := *tx
+	other.Txdata.Sender = Address{0x1}
+	if other.Verify() {
+		t.Fatal("signature valid for another sender")
+	}
+	if NewTransaction(1, Address{0x42}, from, uint256.NewInt(10), 21000, 1, nil).Verify() {
+		t.Fatal("unsigned tx accepted")
+	}
+}
+
+// 创建合约的交易 To 编码成空串，解码之后仍然是 nil；转给零地址的交易不受影响
+func TestContractCreationEncoding(t *testing.T) {
+	create := NewContractCreation(1, Address{0x1}, nil, 100000, 1, []byte{0x60, 0x00})
+	transfer := NewTransaction(1, Address{}, Address{0x1}, nil, 100000, 1, []byte{0x60, 0x00})
+	if create.Hash() == transfer.Hash() {
+		t.Fatal("creation has the same hash as a transfer to the zero address")
+	}
+	for _, tx := range []*Transaction{create, transfer} {
+		data, err := rlp.EncodeToBytes(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Transaction
+		if err := rlp.DecodeBytes(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Hash() != tx.Hash() {
+			t.Fatalf("hash changed after decoding: have %x, want %x", decoded.Hash(), tx.Hash())
+		}
+		if (decoded.To() == nil) != (tx.To() == nil) {
+			t.Fatalf("to mismatch after decoding: have %v, want %v", decoded.To(), tx.To())
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package event
+
+import "sync"
+
+// Subscription 取消订阅之后不会再收到事件
+type Subscription interface {
+	Unsubscribe()
+}
+
+// Feed 把事件发给所有订阅者，零值可以直接使用
+type Feed[T any] struct {
+	lock sync.Mutex
+	subs map[*feedSub[T]]struct{}
+}
+
+type feedSub[T any] struct {
+	feed *Feed[T]
+	ch   chan<- T
+	once sync.Once
+	quit chan struct{}
+}
+
+// Subscribe 之后事件会发到 ch，订阅者要及时读取，不然 Send 会阻塞
+func (f *Feed[T]) Subscribe(ch chan<- T) Subscription {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[*feedSub[T]]struct{})
+	}
+	sub := &feedSub[T]{feed: f, ch: ch, quit: make(chan struct{})}
+	f.subs[sub] = struct{}{}
+	return sub
+}
+
+// Send 把事件依次发给每个订阅者，返回收到事件的订阅者个数
+func (f *Feed[T]) Send(value T) int {
+	f.lock.Lock()
+	subs := make([]*feedSub[T], 0, len(f.subs))
+	for sub := range f.subs {
+		subs = append(subs, sub)
+	}
+	f.lock.Unlock()
+
+	sent := 0
+	for _, sub := range subs {
+		select {
+		case sub.ch <- value:
+			sent++
+		case <-sub.quit:
+		}
+	}
+	return sent
+}
+
+func (sub *feedSub[T]) Unsubscribe() {
+	sub.once.Do(func() {
+		sub.feed.lock.Lock()
+		delete(sub.feed.subs, sub)
+		sub.feed.lock.Unlock()
+		close(sub.quit)
+	})
+}
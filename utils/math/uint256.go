@@ -0,0 +1,27 @@
+package math
+
+import "github.com/holiman/uint256"
+
+// MaxUint256 is the largest value a 256 bit unsigned integer can hold.
+var MaxUint256 = new(uint256.Int).SetAllOne()
+
+// SafeAddU256 returns x+y and checks for overflow.
+func SafeAddU256(x, y *uint256.Int) (*uint256.Int, bool) {
+	return new(uint256.Int).AddOverflow(x, y)
+}
+
+// SafeSubU256 returns x-y and checks for overflow.
+func SafeSubU256(x, y *uint256.Int) (*uint256.Int, bool) {
+	return new(uint256.Int).SubOverflow(x, y)
+}
+
+// SafeMulU256 returns x*y and checks for overflow.
+func SafeMulU256(x, y *uint256.Int) (*uint256.Int, bool) {
+	return new(uint256.Int).MulOverflow(x, y)
+}
+
+// MulU64 returns x*y as a 256 bit integer. The product of two 64 bit
+// numbers always fits, so this never overflows.
+func MulU64(x, y uint64) *uint256.Int {
+	return new(uint256.Int).Mul(uint256.NewInt(x), uint256.NewInt(y))
+}
@@ -0,0 +1,51 @@
+package math
+
+import (
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestOverflowU256(t *testing.T) {
+	one := uint256.NewInt(1)
+	two := uint256.NewInt(2)
+	for i, test := range []struct {
+		x        *uint256.Int
+		y        *uint256.Int
+		overflow bool
+		op       operation
+	}{
+		// add operations
+		{MaxUint256, one, true, add},
+		{new(uint256.Int).Sub(MaxUint256, one), one, false, add},
+
+		// sub operations
+		{new(uint256.Int), one, true, sub},
+		{one, one, false, sub},
+
+		// mul operations
+		{MaxUint256, two, true, mul},
+		{MaxUint256, one, false, mul},
+		{uint256.NewInt(MaxUint64), uint256.NewInt(MaxUint64), false, mul},
+	} {
+		var overflows bool
+		switch test.op {
+		case sub:
+			_, overflows = SafeSubU256(test.x, test.y)
+		case add:
+			_, overflows = SafeAddU256(test.x, test.y)
+		case mul:
+			_, overflows = SafeMulU256(test.x, test.y)
+		}
+		if test.overflow != overflows {
+			t.Errorf("%d failed. Expected test to be %v, got %v", i, test.overflow, overflows)
+		}
+	}
+}
+
+func TestMulU64(t *testing.T) {
+	want, _ := uint256.FromDecimal("340282366920938463426481119284349108225") // (2^64-1)^2
+	if have := MulU64(MaxUint64, MaxUint64); !have.Eq(want) {
+		t.Fatalf("product mismatch: have %s, want %s", have, want)
+	}
+}
@@ -0,0 +1,71 @@
+package vm
+
+import (
+	"blockchain/types"
+
+	"github.com/holiman/uint256"
+)
+
+// Contract 是一次调用正在执行的合约：代码、参数和剩下的gas
+type Contract struct {
+	Caller  types.Address
+	Address types.Address
+	Value   *uint256.Int
+	Code    []byte
+	Input   []byte
+	Gas     uint64
+
+	jumpdests []bool //代码里哪些位置是可以跳转的 JUMPDEST，第一次跳转时才分析
+}
+
+func NewContract(caller, address types.Address, value *uint256.Int, gas uint64, code []byte) *Contract {
+	return &Contract{
+		Caller:  caller,
+		Address: address,
+		Value:   value,
+		Code:    code,
+		Gas:     gas,
+	}
+}
+
+// GetOp 返回位置 n 的指令，超出代码的部分都是 STOP
+func (c *Contract) GetOp(n uint64) OpCode {
+	if n < uint64(len(c.Code)) {
+		return OpCode(c.Code[n])
+	}
+	return STOP
+}
+
+// UseGas 扣除 gas，不够时返回 false，剩下的gas不变
+func (c *Contract) UseGas(gas uint64) bool {
+	if c.Gas < gas {
+		return false
+	}
+	c.Gas -= gas
+	return true
+}
+
+// validJumpdest 检查 dest 是不是一条 JUMPDEST 指令，PUSH 的数据里的 0x5b 不算
+func (c *Contract) validJumpdest(dest *uint256.Int) bool {
+	udest, overflow := dest.Uint64WithOverflow()
+	if overflow || udest >= uint64(len(c.Code)) {
+		return false
+	}
+	if c.jumpdests == nil {
+		c.jumpdests = analyseJumpdests(c.Code)
+	}
+	return c.jumpdests[udest]
+}
+
+func analyseJumpdests(code []byte) []bool {
+	dests := make([]bool, len(code))
+	for pc := 0; pc < len(code); pc++ {
+		op := OpCode(code[pc])
+		if op == JUMPDEST {
+			dests[pc] = true
+		} else if op >= PUSH1 && op <= PUSH32 {
+			pc += int(op - PUSH1 + 1)
+		}
+	}
+	return dests
+}
@@ -0,0 +1,224 @@
+package vm
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/statdb"
+	"blockchain/types"
+	"blockchain/utils/math"
+	"errors"
+
+	"github.com/holiman/uint256"
+)
+
+// BlockContext 是合约能读到的区块信息
+type BlockContext struct {
+	Coinbase types.Address
+	Height   uint64
+	Time     uint64
+	GasLimit uint64
+	BaseFee  uint64
+}
+
+func NewBlockContext(header *types.Header) BlockContext {
+	return BlockContext{
+		Coinbase: header.Coinbase,
+		Height:   header.Height,
+		Time:     header.Timestamp,
+		GasLimit: header.GasLimit,
+		BaseFee:  header.BaseFee,
+	}
+}
+
+// TxContext 是合约能读到的交易信息
+type TxContext struct {
+	Origin   types.Address
+	GasPrice uint64
+}
+
+// EVM 在一笔交易里执行合约代码。合约的存储就是账户的存储 trie，通过 StatDB 的 GetState/SetState 读写；
+// 每次调用之前打一个快照，调用失败时回滚这次调用的所有修改、退款和日志。
+// EVM 不是线程安全的，每笔交易用一个新的 EVM
+type EVM struct {
+	Context BlockContext
+	TxContext
+
+	state statdb.JournaledStatDB
+	depth int
+
+	refund      uint64       //清空存储累计的退款，交易结束时由状态机按上限退还
+	logs        []*types.Log //LOG 指令留下的日志
+	returnData  []byte       //最近一次 CALL 或 CREATE 的返回数据
+	callGasTemp uint64       //gasCall 算出来的转给被调用合约的gas
+}
+
+func NewEVM(blockCtx BlockContext, txCtx TxContext, state statdb.JournaledStatDB) *EVM {
+	return &EVM{
+		Context:   blockCtx,
+		TxContext: txCtx,
+		state:     state,
+	}
+}
+
+// Refund 返回执行过程中累计的退款
+func (evm *EVM) Refund() uint64 {
+	return evm.refund
+}
+
+// Logs 返回执行过程中留下的日志，失败的调用留下的日志已经被丢弃
+func (evm *EVM) Logs() []*types.Log {
+	return evm.logs
+}
+
+type checkpoint struct {
+	snapshot int
+	refund   uint64
+	logs     int
+}
+
+func (evm *EVM) checkpoint() checkpoint {
+	return checkpoint{evm.state.Snapshot(), evm.refund, len(evm.logs)}
+}
+
+func (evm *EVM) revert(cp checkpoint) {
+	evm.state.RevertToSnapshot(cp.snapshot)
+	evm.refund = cp.refund
+	evm.logs = evm.logs[:cp.logs]
+}
+
+// Call 从 caller 向 addr 转 value，addr 有代码时用 input 执行它，返回执行结果和剩下的gas。
+// 出错时这次调用的修改都被回滚；除了 ErrExecutionReverted 以外，出错时gas全部用光
+func (evm *EVM) Call(caller, addr types.Address, input []byte, gas uint64, value *uint256.Int) (ret []byte, leftOverGas uint64, err error) {
+	if evm.depth > CallCreateDepth {
+		return nil, gas, ErrDepth
+	}
+	cp := evm.checkpoint()
+	if err := evm.transfer(caller, addr, value); err != nil {
+		evm.revert(cp)
+		return nil, gas, err
+	}
+	code, err := evm.state.GetCode(addr)
+	if err != nil {
+		evm.revert(cp)
+		return nil, gas, err
+	}
+	if len(code) == 0 {
+		return nil, gas, nil
+	}
+	contract := NewContract(caller, addr, value, gas, code)
+	ret, err = evm.run(contract, input)
+	if err != nil {
+		evm.revert(cp)
+		if err != ErrExecutionReverted {
+			contract.Gas = 0
+		}
+	}
+	return ret, contract.Gas, err
+}
+
+// Create 用 caller 的下一个 nonce 创建合约，code 是初始化代码，它的返回值是合约的代码
+func (evm *EVM) Create(caller types.Address, code []byte, gas uint64, value *uint256.Int) (ret []byte, contractAddr types.Address, leftOverGas uint64, err error) {
+	nonce, err := evm.incNonce(caller)
+	if err != nil {
+		return nil, types.Address{}, gas, err
+	}
+	return evm.create(caller, code, gas, value, types.CreateAddress(caller, nonce))
+}
+
+// Create2 和 Create 一样，但是地址由 salt 和初始化代码的hash决定，和 nonce 无关
+func (evm *EVM) Create2(caller types.Address, code []byte, gas uint64, value *uint256.Int, salt *uint256.Int) (ret []byte, contractAddr types.Address, leftOverGas uint64, err error) {
+	if _, err := evm.incNonce(caller); err != nil {
+		return nil, types.Address{}, gas, err
+	}
+	codeHash := sha3.Keccak256(code)
+	return evm.create(caller, code, gas, value, types.CreateAddress2(caller, salt.Bytes32(), codeHash[:]))
+}
+
+// CreateAt 在 address 上创建合约，不修改 caller 的 nonce。
+// 创建合约的交易用它：状态机已经增加了发送方的 nonce，地址由交易的 nonce 算出
+func (evm *EVM) CreateAt(caller types.Address, code []byte, gas uint64, value *uint256.Int, address types.Address) (ret []byte, leftOverGas uint64, err error) {
+	ret, _, leftOverGas, err = evm.create(caller, code, gas, value, address)
+	return ret, leftOverGas, err
+}
+
+// incNonce 增加 caller 的 nonce 并返回新的 nonce，新的 nonce 用来算合约地址
+func (evm *EVM) incNonce(caller types.Address) (uint64, error) {
+	account, err := evm.state.Load(caller)
+	if err != nil {
+		return 0, err
+	}
+	if account.Nonce == ^uint64(0) {
+		return 0, ErrNonceUintOverflow
+	}
+	account.Nonce++
+	return account.Nonce, evm.state.Store(caller, account)
+}
+
+func (evm *EVM) create(caller types.Address, code []byte, gas uint64, value *uint256.Int, address types.Address) ([]byte, types.Address, uint64, error) {
+	if evm.depth > CallCreateDepth {
+		return nil, types.Address{}, gas, ErrDepth
+	}
+	//地址上已经有合约或者发过交易
+	account, err := evm.state.Load(address)
+	if err != nil && !errors.Is(err, statdb.ErrNotFound) {
+		return nil, types.Address{}, gas, err
+	}
+	existing, err := evm.state.GetCode(address)
+	if err != nil {
+		return nil, types.Address{}, gas, err
+	}
+	if account.Nonce != 0 || len(existing) != 0 {
+		return nil, types.Address{}, 0, ErrContractAddressCollision
+	}
+	cp := evm.checkpoint()
+	if err := evm.transfer(caller, address, value); err != nil {
+		evm.revert(cp)
+		return nil, types.Address{}, gas, err
+	}
+	contract := NewContract(caller, address, value, gas, code)
+	ret, err := evm.run(contract, nil)
+	if err == nil && len(ret) > MaxCodeSize {
+		err = ErrMaxCodeSizeExceeded
+	}
+	if err == nil && len(ret) > 0 {
+		if contract.UseGas(uint64(len(ret)) * CreateDataGas) {
+			err = evm.state.SetCode(address, ret)
+		} else {
+			err = ErrCodeStoreOutOfGas
+		}
+	}
+	if err != nil {
+		evm.revert(cp)
+		if err != ErrExecutionReverted {
+			contract.Gas = 0
+		}
+	}
+	return ret, address, contract.Gas, err
+}
+
+// transfer 从 from 转 value 给 to，余额不够或者 to 的余额溢出时返回错误，由调用方回滚。
+// value 为 0 时也会写入 to，这样转账交易的状态和以前一样
+func (evm *EVM) transfer(from, to types.Address, value *uint256.Int) error {
+	account, err := evm.state.Load(from)
+	if err != nil {
+		return err
+	}
+	balance, overflow := math.SafeSubU256(&account.Amount, value)
+	if overflow {
+		return ErrInsufficientBalance
+	}
+	account.Amount = *balance
+	if err := evm.state.Store(from, account); err != nil {
+		return err
+	}
+
+	toAccount, err := evm.state.Load(to)
+	if err != nil {
+		toAccount = types.Account{}
+	}
+	balance, overflow = math.SafeAddU256(&toAccount.Amount, value)
+	if overflow {
+		return ErrBalanceOverflow
+	}
+	toAccount.Amount = *balance
+	return evm.state.Store(to, toAccount)
+}
@@ -0,0 +1,200 @@
+package vm
+
+import (
+	"blockchain/statdb"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/math"
+	"errors"
+
+	"github.com/holiman/uint256"
+)
+
+// 各条指令的gas，大体上是以太坊 Istanbul 的价格，SSTORE 用的是更简单的 Petersburg 规则
+const (
+	GasQuickStep   uint64 = 2
+	GasFastestStep uint64 = 3
+	GasFastStep    uint64 = 5
+	GasMidStep     uint64 = 8
+	GasSlowStep    uint64 = 10
+
+	MemoryGas        uint64 = 3   //每个字的内存
+	QuadCoeffDiv     uint64 = 512 //内存的平方项的除数
+	CopyGas          uint64 = 3   //复制数据时每个字
+	Keccak256Gas     uint64 = 30
+	Keccak256WordGas uint64 = 6
+	ExpGas           uint64 = 10
+	ExpByteGas       uint64 = 50 //指数的每个字节
+	BalanceGas       uint64 = 700
+	ExtcodeSizeGas   uint64 = 700
+	SloadGas         uint64 = 800
+	SstoreSetGas     uint64 = 20000 //把 0 改成非 0
+	SstoreResetGas   uint64 = 5000  //其他的修改
+	SstoreRefundGas  uint64 = 15000 //把非 0 清空时退还
+	JumpdestGas      uint64 = 1
+	LogGas           uint64 = 375
+	LogTopicGas      uint64 = 375
+	LogDataGas       uint64 = 8 //日志数据的每个字节
+	CreateGas        uint64 = 32000
+	CreateDataGas    uint64 = 200 //创建合约时保存代码的每个字节
+	CallGas          uint64 = 700
+	CallValueGas     uint64 = 9000  //CALL 转账的额外费用
+	CallNewAccount   uint64 = 25000 //CALL 向不存在的账户转账的额外费用
+	CallStipend      uint64 = 2300  //CALL 转账时免费给被调用合约的gas
+
+	CallCreateDepth = 1024  //调用的最大深度
+	MaxCodeSize     = 24576 //合约代码的最大长度（EIP-170）
+)
+
+type (
+	gasFunc        func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error)
+	memorySizeFunc func(stack *Stack) (size uint64, overflow bool)
+)
+
+func toWordSize(size uint64) uint64 {
+	if size > ^uint64(0)-31 {
+		return ^uint64(0)/32 + 1
+	}
+	return (size + 31) / 32
+}
+
+// memoryGasCost 是把内存扩展到 newMemSize 需要补交的gas，总价是 3*字数 + 字数²/512
+func memoryGasCost(mem *Memory, newMemSize uint64) (uint64, error) {
+	if newMemSize == 0 {
+		return 0, nil
+	}
+	//再大的话字数的平方会溢出
+	if newMemSize > 0x1FFFFFFFE0 {
+		return 0, ErrGasUintOverflow
+	}
+	words := toWordSize(newMemSize)
+	newMemSize = words * 32
+	if newMemSize <= uint64(mem.Len()) {
+		return 0, nil
+	}
+	total := words*MemoryGas + words*words/QuadCoeffDiv
+	fee := total - mem.lastGasCost
+	mem.lastGasCost = total
+	return fee, nil
+}
+
+func gasMemory(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	return memoryGasCost(mem, memorySize)
+}
+
+// memoryWordGas 返回按字收费的指令的gas：内存扩展加上 size 个字节的字数乘以 wordGas
+func memoryWordGas(mem *Memory, memorySize uint64, size *uint256.Int, wordGas uint64) (uint64, error) {
+	gas, err := memoryGasCost(mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	words, overflow := size.Uint64WithOverflow()
+	if overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if words, overflow = math.SafeMul(toWordSize(words), wordGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if gas, overflow = math.SafeAdd(gas, words); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+func gasCopy(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	return memoryWordGas(mem, memorySize, stack.Back(2), CopyGas)
+}
+
+func gasKeccak256(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	return memoryWordGas(mem, memorySize, stack.Back(1), Keccak256WordGas)
+}
+
+// gasCreate2 除了内存还要按字为初始化代码的hash付费
+func gasCreate2(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	return memoryWordGas(mem, memorySize, stack.Back(2), Keccak256WordGas)
+}
+
+func gasExp(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	expByteLen := uint64((stack.Back(1).BitLen() + 7) / 8)
+	return expByteLen * ExpByteGas, nil
+}
+
+// gasSStore 按修改之前的值收费，把非 0 清空时记一笔退款
+func gasSStore(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	key, value := stack.Back(0), stack.Back(1)
+	current, err := evm.state.GetState(contract.Address, key.Bytes32())
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case current == (hash.Hash{}) && !value.IsZero():
+		return SstoreSetGas, nil
+	case current != (hash.Hash{}) && value.IsZero():
+		evm.refund += SstoreRefundGas
+		return SstoreResetGas, nil
+	default:
+		return SstoreResetGas, nil
+	}
+}
+
+func makeGasLog(n uint64) gasFunc {
+	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+		size, overflow := stack.Back(1).Uint64WithOverflow()
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+		gas, err := memoryGasCost(mem, memorySize)
+		if err != nil {
+			return 0, err
+		}
+		if gas, overflow = math.SafeAdd(gas, LogGas+n*LogTopicGas); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		dataGas, overflow := math.SafeMul(size, LogDataGas)
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+		if gas, overflow = math.SafeAdd(gas, dataGas); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+// gasCall 是 CALL 除了固定的 CallGas 以外的费用：转账、内存扩展，以及转给被调用合约的gas。
+// 转给被调用合约的gas最多是剩下的 63/64（EIP-150），算出来放在 evm.callGasTemp 里给 opCall 用
+func gasCall(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	var gas uint64
+	if !stack.Back(2).IsZero() {
+		address := types.Address(stack.Back(1).Bytes20())
+		if _, err := evm.state.Load(address); errors.Is(err, statdb.ErrNotFound) {
+			gas += CallNewAccount
+		}
+		gas += CallValueGas
+	}
+	memoryGas, err := memoryGasCost(mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	var overflow bool
+	if gas, overflow = math.SafeAdd(gas, memoryGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if contract.Gas < gas {
+		return 0, ErrOutOfGas
+	}
+	evm.callGasTemp = callGas(contract.Gas-gas, stack.Back(0))
+	if gas, overflow = math.SafeAdd(gas, evm.callGasTemp); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+// callGas 是实际转给被调用合约的gas：请求的gas和剩下的 63/64 中较小的一个
+func callGas(available uint64, requested *uint256.Int) uint64 {
+	gas := available - available/64
+	if !requested.IsUint64() || gas < requested.Uint64() {
+		return gas
+	}
+	return requested.Uint64()
+}
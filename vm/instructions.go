@@ -0,0 +1,561 @@
+package vm
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/types"
+	"blockchain/utils/hash"
+
+	"github.com/holiman/uint256"
+)
+
+// scope 是一次调用的内存、栈和合约
+type scope struct {
+	memory   *Memory
+	stack    *Stack
+	contract *Contract
+}
+
+type executionFunc func(pc *uint64, evm *EVM, scope *scope) ([]byte, error)
+
+func opAdd(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.Add(&x, y)
+	return nil, nil
+}
+
+func opSub(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.Sub(&x, y)
+	return nil, nil
+}
+
+func opMul(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.Mul(&x, y)
+	return nil, nil
+}
+
+func opDiv(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.Div(&x, y)
+	return nil, nil
+}
+
+func opSdiv(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.SDiv(&x, y)
+	return nil, nil
+}
+
+func opMod(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.Mod(&x, y)
+	return nil, nil
+}
+
+func opSmod(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.SMod(&x, y)
+	return nil, nil
+}
+
+func opAddmod(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y, z := scope.stack.pop(), scope.stack.pop(), scope.stack.peek()
+	z.AddMod(&x, &y, z)
+	return nil, nil
+}
+
+func opMulmod(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y, z := scope.stack.pop(), scope.stack.pop(), scope.stack.peek()
+	z.MulMod(&x, &y, z)
+	return nil, nil
+}
+
+func opExp(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	base, exponent := scope.stack.pop(), scope.stack.peek()
+	exponent.Exp(&base, exponent)
+	return nil, nil
+}
+
+func opSignExtend(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	back, num := scope.stack.pop(), scope.stack.peek()
+	num.ExtendSign(num, &back)
+	return nil, nil
+}
+
+func opLt(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	setBool(y, x.Lt(y))
+	return nil, nil
+}
+
+func opGt(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	setBool(y, x.Gt(y))
+	return nil, nil
+}
+
+func opSlt(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	setBool(y, x.Slt(y))
+	return nil, nil
+}
+
+func opSgt(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	setBool(y, x.Sgt(y))
+	return nil, nil
+}
+
+func opEq(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	setBool(y, x.Eq(y))
+	return nil, nil
+}
+
+func opIszero(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x := scope.stack.peek()
+	setBool(x, x.IsZero())
+	return nil, nil
+}
+
+func setBool(x *uint256.Int, b bool) {
+	if b {
+		x.SetOne()
+	} else {
+		x.Clear()
+	}
+}
+
+func opAnd(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.And(&x, y)
+	return nil, nil
+}
+
+func opOr(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.Or(&x, y)
+	return nil, nil
+}
+
+func opXor(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x, y := scope.stack.pop(), scope.stack.peek()
+	y.Xor(&x, y)
+	return nil, nil
+}
+
+func opNot(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x := scope.stack.peek()
+	x.Not(x)
+	return nil, nil
+}
+
+func opByte(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	th, val := scope.stack.pop(), scope.stack.peek()
+	val.Byte(&th)
+	return nil, nil
+}
+
+func opSHL(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	shift, value := scope.stack.pop(), scope.stack.peek()
+	if shift.LtUint64(256) {
+		value.Lsh(value, uint(shift.Uint64()))
+	} else {
+		value.Clear()
+	}
+	return nil, nil
+}
+
+func opSHR(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	shift, value := scope.stack.pop(), scope.stack.peek()
+	if shift.LtUint64(256) {
+		value.Rsh(value, uint(shift.Uint64()))
+	} else {
+		value.Clear()
+	}
+	return nil, nil
+}
+
+func opSAR(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	shift, value := scope.stack.pop(), scope.stack.peek()
+	if shift.GtUint64(255) {
+		if value.Sign() >= 0 {
+			value.Clear()
+		} else {
+			value.SetAllOne()
+		}
+		return nil, nil
+	}
+	value.SRsh(value, uint(shift.Uint64()))
+	return nil, nil
+}
+
+func opKeccak256(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	offset, size := scope.stack.pop(), scope.stack.peek()
+	data := scope.memory.GetPtr(offset.Uint64(), size.Uint64())
+	h := sha3.Keccak256(data)
+	size.SetBytes(h[:])
+	return nil, nil
+}
+
+func opAddress(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(new(uint256.Int).SetBytes(scope.contract.Address[:]))
+	return nil, nil
+}
+
+func opBalance(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	slot := scope.stack.peek()
+	account, _ := evm.state.Load(types.Address(slot.Bytes20()))
+	slot.Set(&account.Amount)
+	return nil, nil
+}
+
+func opOrigin(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(new(uint256.Int).SetBytes(evm.Origin[:]))
+	return nil, nil
+}
+
+func opCaller(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(new(uint256.Int).SetBytes(scope.contract.Caller[:]))
+	return nil, nil
+}
+
+func opCallValue(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(new(uint256.Int).Set(scope.contract.Value))
+	return nil, nil
+}
+
+func opCallDataLoad(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	x := scope.stack.peek()
+	if offset, overflow := x.Uint64WithOverflow(); !overflow {
+		x.SetBytes(getData(scope.contract.Input, offset, 32))
+	} else {
+		x.Clear()
+	}
+	return nil, nil
+}
+
+func opCallDataSize(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(uint64(len(scope.contract.Input))))
+	return nil, nil
+}
+
+func opCallDataCopy(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	memOffset, dataOffset, length := scope.stack.pop(), scope.stack.pop(), scope.stack.pop()
+	offset, overflow := dataOffset.Uint64WithOverflow()
+	if overflow {
+		offset = ^uint64(0)
+	}
+	scope.memory.Set(memOffset.Uint64(), length.Uint64(), getData(scope.contract.Input, offset, length.Uint64()))
+	return nil, nil
+}
+
+func opCodeSize(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(uint64(len(scope.contract.Code))))
+	return nil, nil
+}
+
+func opCodeCopy(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	memOffset, codeOffset, length := scope.stack.pop(), scope.stack.pop(), scope.stack.pop()
+	offset, overflow := codeOffset.Uint64WithOverflow()
+	if overflow {
+		offset = ^uint64(0)
+	}
+	scope.memory.Set(memOffset.Uint64(), length.Uint64(), getData(scope.contract.Code, offset, length.Uint64()))
+	return nil, nil
+}
+
+func opGasprice(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(evm.GasPrice))
+	return nil, nil
+}
+
+func opExtCodeSize(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	slot := scope.stack.peek()
+	code, err := evm.state.GetCode(types.Address(slot.Bytes20()))
+	if err != nil {
+		return nil, err
+	}
+	slot.SetUint64(uint64(len(code)))
+	return nil, nil
+}
+
+func opReturnDataSize(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(uint64(len(evm.returnData))))
+	return nil, nil
+}
+
+func opReturnDataCopy(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	memOffset, dataOffset, length := scope.stack.pop(), scope.stack.pop(), scope.stack.pop()
+	offset, overflow := dataOffset.Uint64WithOverflow()
+	if overflow {
+		return nil, ErrReturnDataOutOfBounds
+	}
+	//和 CALLDATACOPY 不同，越界读取是错误
+	end := offset + length.Uint64()
+	if end < offset || uint64(len(evm.returnData)) < end {
+		return nil, ErrReturnDataOutOfBounds
+	}
+	scope.memory.Set(memOffset.Uint64(), length.Uint64(), evm.returnData[offset:end])
+	return nil, nil
+}
+
+func opCoinbase(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(new(uint256.Int).SetBytes(evm.Context.Coinbase[:]))
+	return nil, nil
+}
+
+func opTimestamp(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(evm.Context.Time))
+	return nil, nil
+}
+
+func opNumber(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(evm.Context.Height))
+	return nil, nil
+}
+
+func opGasLimit(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(evm.Context.GasLimit))
+	return nil, nil
+}
+
+func opSelfBalance(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	account, _ := evm.state.Load(scope.contract.Address)
+	scope.stack.push(new(uint256.Int).Set(&account.Amount))
+	return nil, nil
+}
+
+func opBaseFee(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(evm.Context.BaseFee))
+	return nil, nil
+}
+
+func opPop(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.pop()
+	return nil, nil
+}
+
+func opMload(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	v := scope.stack.peek()
+	v.SetBytes(scope.memory.GetPtr(v.Uint64(), 32))
+	return nil, nil
+}
+
+func opMstore(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	mStart, val := scope.stack.pop(), scope.stack.pop()
+	scope.memory.Set32(mStart.Uint64(), &val)
+	return nil, nil
+}
+
+func opMstore8(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	off, val := scope.stack.pop(), scope.stack.pop()
+	scope.memory.store[off.Uint64()] = byte(val.Uint64())
+	return nil, nil
+}
+
+func opSload(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	loc := scope.stack.peek()
+	val, err := evm.state.GetState(scope.contract.Address, loc.Bytes32())
+	if err != nil {
+		return nil, err
+	}
+	loc.SetBytes(val[:])
+	return nil, nil
+}
+
+func opSstore(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	loc, val := scope.stack.pop(), scope.stack.pop()
+	return nil, evm.state.SetState(scope.contract.Address, loc.Bytes32(), val.Bytes32())
+}
+
+func opJump(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	pos := scope.stack.pop()
+	if !scope.contract.validJumpdest(&pos) {
+		return nil, ErrInvalidJump
+	}
+	*pc = pos.Uint64()
+	return nil, nil
+}
+
+func opJumpi(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	pos, cond := scope.stack.pop(), scope.stack.pop()
+	if cond.IsZero() {
+		*pc++
+		return nil, nil
+	}
+	if !scope.contract.validJumpdest(&pos) {
+		return nil, ErrInvalidJump
+	}
+	*pc = pos.Uint64()
+	return nil, nil
+}
+
+func opJumpdest(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	return nil, nil
+}
+
+func opPc(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(*pc))
+	return nil, nil
+}
+
+func opMsize(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(uint64(scope.memory.Len())))
+	return nil, nil
+}
+
+func opGas(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(uint256.NewInt(scope.contract.Gas))
+	return nil, nil
+}
+
+func opPush0(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	scope.stack.push(new(uint256.Int))
+	return nil, nil
+}
+
+// makePush 读出指令后面的 size 个字节，代码结尾不够的部分补 0
+func makePush(size uint64) executionFunc {
+	return func(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+		start := *pc + 1
+		scope.stack.push(new(uint256.Int).SetBytes(getData(scope.contract.Code, start, size)))
+		*pc += size
+		return nil, nil
+	}
+}
+
+func makeDup(n int) executionFunc {
+	return func(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+		scope.stack.dup(n)
+		return nil, nil
+	}
+}
+
+// makeSwap 交换栈顶和往下第 n 个元素
+func makeSwap(n int) executionFunc {
+	n++
+	return func(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+		scope.stack.swap(n)
+		return nil, nil
+	}
+}
+
+func makeLog(n int) executionFunc {
+	return func(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+		mStart, mSize := scope.stack.pop(), scope.stack.pop()
+		topics := make([]hash.Hash, n)
+		for i := 0; i < n; i++ {
+			topic := scope.stack.pop()
+			topics[i] = topic.Bytes32()
+		}
+		evm.logs = append(evm.logs, &types.Log{
+			Address: scope.contract.Address,
+			Topics:  topics,
+			Data:    scope.memory.GetCopy(mStart.Uint64(), mSize.Uint64()),
+		})
+		return nil, nil
+	}
+}
+
+// opCreate 把剩下的gas除了 1/64 都交给初始化代码，失败时压入 0
+func opCreate(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	value, offset, size := scope.stack.pop(), scope.stack.pop(), scope.stack.pop()
+	input := scope.memory.GetCopy(offset.Uint64(), size.Uint64())
+	gas := scope.contract.Gas
+	gas -= gas / 64
+	scope.contract.UseGas(gas)
+
+	res, addr, returnGas, err := evm.Create(scope.contract.Address, input, gas, &value)
+	stackValue := new(uint256.Int)
+	if err == nil {
+		stackValue.SetBytes(addr[:])
+	}
+	scope.stack.push(stackValue)
+	scope.contract.Gas += returnGas
+
+	if err == ErrExecutionReverted {
+		evm.returnData = res
+		return res, nil
+	}
+	evm.returnData = nil
+	return nil, nil
+}
+
+// opCreate2 和 opCreate 一样，多一个 salt 参数
+func opCreate2(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	endowment, offset, size, salt := scope.stack.pop(), scope.stack.pop(), scope.stack.pop(), scope.stack.pop()
+	input := scope.memory.GetCopy(offset.Uint64(), size.Uint64())
+	gas := scope.contract.Gas
+	gas -= gas / 64
+	scope.contract.UseGas(gas)
+
+	res, addr, returnGas, err := evm.Create2(scope.contract.Address, input, gas, &endowment, &salt)
+	stackValue := new(uint256.Int)
+	if err == nil {
+		stackValue.SetBytes(addr[:])
+	}
+	scope.stack.push(stackValue)
+	scope.contract.Gas += returnGas
+
+	if err == ErrExecutionReverted {
+		evm.returnData = res
+		return res, nil
+	}
+	evm.returnData = nil
+	return nil, nil
+}
+
+func opCall(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	stack := scope.stack
+	temp := stack.pop() //请求的gas，实际转给被调用合约的gas在 gasCall 里算好了
+	gas := evm.callGasTemp
+	addr, value, inOffset, inSize, retOffset, retSize := stack.pop(), stack.pop(), stack.pop(), stack.pop(), stack.pop(), stack.pop()
+	args := scope.memory.GetPtr(inOffset.Uint64(), inSize.Uint64())
+	if !value.IsZero() {
+		gas += CallStipend
+	}
+
+	ret, returnGas, err := evm.Call(scope.contract.Address, types.Address(addr.Bytes20()), args, gas, &value)
+	setBool(&temp, err == nil)
+	stack.push(&temp)
+	if err == nil || err == ErrExecutionReverted {
+		scope.memory.Set(retOffset.Uint64(), retSize.Uint64(), ret)
+	}
+	scope.contract.Gas += returnGas
+
+	evm.returnData = ret
+	return ret, nil
+}
+
+func opReturn(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	offset, size := scope.stack.pop(), scope.stack.pop()
+	return scope.memory.GetCopy(offset.Uint64(), size.Uint64()), nil
+}
+
+func opRevert(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	offset, size := scope.stack.pop(), scope.stack.pop()
+	ret := scope.memory.GetCopy(offset.Uint64(), size.Uint64())
+	evm.returnData = ret
+	return ret, ErrExecutionReverted
+}
+
+func opStop(pc *uint64, evm *EVM, scope *scope) ([]byte, error) {
+	return nil, nil
+}
+
+// getData 返回 data[start:start+size]，越界的部分补 0
+func getData(data []byte, start uint64, size uint64) []byte {
+	length := uint64(len(data))
+	if start > length {
+		start = length
+	}
+	end := start + size
+	if end > length || end < start {
+		end = length
+	}
+	padded := make([]byte, size)
+	copy(padded, data[start:end])
+	return padded
+}
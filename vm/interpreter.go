@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"blockchain/utils/math"
+	"fmt"
+)
+
+// run 执行合约的代码：每条指令先检查栈，再收固定的gas、按需要的内存大小收动态gas并扩展内存，最后执行。
+// 出错时返回错误，由调用方回滚；REVERT 返回 ErrExecutionReverted 和它的返回数据
+func (evm *EVM) run(contract *Contract, input []byte) ([]byte, error) {
+	evm.depth++
+	defer func() { evm.depth-- }()
+
+	//每次调用开始时清空上一次调用的返回数据
+	evm.returnData = nil
+	if len(contract.Code) == 0 {
+		return nil, nil
+	}
+	contract.Input = input
+
+	var (
+		mem   = NewMemory()
+		stack = newStack()
+		sc    = &scope{memory: mem, stack: stack, contract: contract}
+		pc    = uint64(0)
+	)
+	for {
+		op := contract.GetOp(pc)
+		operation := jumpTable[op]
+		if operation == nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOpCode, op)
+		}
+		if sLen := stack.len(); sLen < operation.minStack {
+			return nil, fmt.Errorf("%w: %v needs %d items, have %d", ErrStackUnderflow, op, operation.minStack, sLen)
+		} else if sLen > operation.maxStack {
+			return nil, fmt.Errorf("%w: %d", ErrStackOverflow, sLen)
+		}
+		if !contract.UseGas(operation.constantGas) {
+			return nil, ErrOutOfGas
+		}
+
+		var memorySize uint64
+		if operation.memorySize != nil {
+			size, overflow := operation.memorySize(stack)
+			if overflow {
+				return nil, ErrGasUintOverflow
+			}
+			if memorySize, overflow = math.SafeMul(toWordSize(size), 32); overflow {
+				return nil, ErrGasUintOverflow
+			}
+		}
+		if operation.dynamicGas != nil {
+			cost, err := operation.dynamicGas(evm, contract, stack, mem, memorySize)
+			if err != nil {
+				return nil, err
+			}
+			if !contract.UseGas(cost) {
+				return nil, ErrOutOfGas
+			}
+		}
+		if memorySize > 0 {
+			mem.Resize(memorySize)
+		}
+
+		res, err := operation.execute(&pc, evm, sc)
+		if err != nil {
+			return res, err
+		}
+		if operation.halts {
+			return res, nil
+		}
+		if !operation.jumps {
+			pc++
+		}
+	}
+}
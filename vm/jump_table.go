@@ -0,0 +1,140 @@
+package vm
+
+type operation struct {
+	execute     executionFunc
+	constantGas uint64
+	dynamicGas  gasFunc
+	minStack    int            //执行之前栈里至少要有的元素
+	maxStack    int            //执行之前栈里最多能有的元素，再多就会超过 StackLimit
+	memorySize  memorySizeFunc //需要的内存大小，执行之前按它收费并扩展内存
+
+	halts bool //执行之后停止，例如 STOP、RETURN
+	jumps bool //自己修改 pc
+}
+
+// jumpTable 里没有的指令都是无效指令。指令会递归调用 run，所以在 init 里初始化，避免初始化循环
+var jumpTable [256]*operation
+
+func init() {
+	jumpTable = newJumpTable()
+}
+
+func minStack(pops, push int) int {
+	return pops
+}
+
+func maxStack(pops, push int) int {
+	return StackLimit + pops - push
+}
+
+func newJumpTable() [256]*operation {
+	var tbl [256]*operation
+	op := func(code OpCode, execute executionFunc, gas uint64, pops, push int) *operation {
+		tbl[code] = &operation{
+			execute:     execute,
+			constantGas: gas,
+			minStack:    minStack(pops, push),
+			maxStack:    maxStack(pops, push),
+		}
+		return tbl[code]
+	}
+
+	op(STOP, opStop, 0, 0, 0).halts = true
+	op(ADD, opAdd, GasFastestStep, 2, 1)
+	op(MUL, opMul, GasFastStep, 2, 1)
+	op(SUB, opSub, GasFastestStep, 2, 1)
+	op(DIV, opDiv, GasFastStep, 2, 1)
+	op(SDIV, opSdiv, GasFastStep, 2, 1)
+	op(MOD, opMod, GasFastStep, 2, 1)
+	op(SMOD, opSmod, GasFastStep, 2, 1)
+	op(ADDMOD, opAddmod, GasMidStep, 3, 1)
+	op(MULMOD, opMulmod, GasMidStep, 3, 1)
+	op(EXP, opExp, ExpGas, 2, 1).dynamicGas = gasExp
+	op(SIGNEXTEND, opSignExtend, GasFastStep, 2, 1)
+
+	op(LT, opLt, GasFastestStep, 2, 1)
+	op(GT, opGt, GasFastestStep, 2, 1)
+	op(SLT, opSlt, GasFastestStep, 2, 1)
+	op(SGT, opSgt, GasFastestStep, 2, 1)
+	op(EQ, opEq, GasFastestStep, 2, 1)
+	op(ISZERO, opIszero, GasFastestStep, 1, 1)
+	op(AND, opAnd, GasFastestStep, 2, 1)
+	op(OR, opOr, GasFastestStep, 2, 1)
+	op(XOR, opXor, GasFastestStep, 2, 1)
+	op(NOT, opNot, GasFastestStep, 1, 1)
+	op(BYTE, opByte, GasFastestStep, 2, 1)
+	op(SHL, opSHL, GasFastestStep, 2, 1)
+	op(SHR, opSHR, GasFastestStep, 2, 1)
+	op(SAR, opSAR, GasFastestStep, 2, 1)
+
+	keccak := op(KECCAK256, opKeccak256, Keccak256Gas, 2, 1)
+	keccak.dynamicGas, keccak.memorySize = gasKeccak256, memoryKeccak256
+
+	op(ADDRESS, opAddress, GasQuickStep, 0, 1)
+	op(BALANCE, opBalance, BalanceGas, 1, 1)
+	op(ORIGIN, opOrigin, GasQuickStep, 0, 1)
+	op(CALLER, opCaller, GasQuickStep, 0, 1)
+	op(CALLVALUE, opCallValue, GasQuickStep, 0, 1)
+	op(CALLDATALOAD, opCallDataLoad, GasFastestStep, 1, 1)
+	op(CALLDATASIZE, opCallDataSize, GasQuickStep, 0, 1)
+	for code, execute := range map[OpCode]executionFunc{
+		CALLDATACOPY:   opCallDataCopy,
+		CODECOPY:       opCodeCopy,
+		RETURNDATACOPY: opReturnDataCopy,
+	} {
+		copyOp := op(code, execute, GasFastestStep, 3, 0)
+		copyOp.dynamicGas, copyOp.memorySize = gasCopy, memoryCopy
+	}
+	op(CODESIZE, opCodeSize, GasQuickStep, 0, 1)
+	op(GASPRICE, opGasprice, GasQuickStep, 0, 1)
+	op(EXTCODESIZE, opExtCodeSize, ExtcodeSizeGas, 1, 1)
+	op(RETURNDATASIZE, opReturnDataSize, GasQuickStep, 0, 1)
+
+	op(COINBASE, opCoinbase, GasQuickStep, 0, 1)
+	op(TIMESTAMP, opTimestamp, GasQuickStep, 0, 1)
+	op(NUMBER, opNumber, GasQuickStep, 0, 1)
+	op(GASLIMIT, opGasLimit, GasQuickStep, 0, 1)
+	op(SELFBALANCE, opSelfBalance, GasFastStep, 0, 1)
+	op(BASEFEE, opBaseFee, GasQuickStep, 0, 1)
+
+	op(POP, opPop, GasQuickStep, 1, 0)
+	mload := op(MLOAD, opMload, GasFastestStep, 1, 1)
+	mload.dynamicGas, mload.memorySize = gasMemory, memoryMLoad
+	mstore := op(MSTORE, opMstore, GasFastestStep, 2, 0)
+	mstore.dynamicGas, mstore.memorySize = gasMemory, memoryMLoad
+	mstore8 := op(MSTORE8, opMstore8, GasFastestStep, 2, 0)
+	mstore8.dynamicGas, mstore8.memorySize = gasMemory, memoryMStore8
+	op(SLOAD, opSload, SloadGas, 1, 1)
+	op(SSTORE, opSstore, 0, 2, 0).dynamicGas = gasSStore
+	op(JUMP, opJump, GasMidStep, 1, 0).jumps = true
+	op(JUMPI, opJumpi, GasSlowStep, 2, 0).jumps = true
+	op(PC, opPc, GasQuickStep, 0, 1)
+	op(MSIZE, opMsize, GasQuickStep, 0, 1)
+	op(GAS, opGas, GasQuickStep, 0, 1)
+	op(JUMPDEST, opJumpdest, JumpdestGas, 0, 0)
+	op(PUSH0, opPush0, GasQuickStep, 0, 1)
+
+	for i := 0; i < 32; i++ {
+		op(PUSH1+OpCode(i), makePush(uint64(i+1)), GasFastestStep, 0, 1)
+	}
+	for i := 1; i <= 16; i++ {
+		op(DUP1+OpCode(i-1), makeDup(i), GasFastestStep, i, i+1)
+		op(SWAP1+OpCode(i-1), makeSwap(i), GasFastestStep, i+1, i+1)
+	}
+	for i := 0; i <= 4; i++ {
+		log := op(LOG0+OpCode(i), makeLog(i), 0, 2+i, 0)
+		log.dynamicGas, log.memorySize = makeGasLog(uint64(i)), memoryRange
+	}
+
+	create := op(CREATE, opCreate, CreateGas, 3, 1)
+	create.dynamicGas, create.memorySize = gasMemory, memoryCreate
+	create2 := op(CREATE2, opCreate2, CreateGas, 4, 1)
+	create2.dynamicGas, create2.memorySize = gasCreate2, memoryCreate
+	call := op(CALL, opCall, CallGas, 7, 1)
+	call.dynamicGas, call.memorySize = gasCall, memoryCall
+	ret := op(RETURN, opReturn, 0, 2, 0)
+	ret.dynamicGas, ret.memorySize, ret.halts = gasMemory, memoryRange, true
+	revert := op(REVERT, opRevert, 0, 2, 0)
+	revert.dynamicGas, revert.memorySize, revert.halts = gasMemory, memoryRange, true
+	return tbl
+}
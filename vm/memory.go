@@ -0,0 +1,54 @@
+package vm
+
+import "github.com/holiman/uint256"
+
+// Memory 是按字节寻址的线性内存，只能按 32 字节扩展，每次扩展之前先按新的大小收费
+type Memory struct {
+	store       []byte
+	lastGasCost uint64 //已经为当前大小付过的gas
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Set 把 value 写到 [offset, offset+size)，调用之前内存已经扩展过
+func (m *Memory) Set(offset, size uint64, value []byte) {
+	if size > 0 {
+		copy(m.store[offset:offset+size], value)
+	}
+}
+
+// Set32 把 val 按 32 字节大端写到 offset
+func (m *Memory) Set32(offset uint64, val *uint256.Int) {
+	val.WriteToSlice(m.store[offset : offset+32])
+}
+
+// Resize 把内存扩展到 size 字节
+func (m *Memory) Resize(size uint64) {
+	if uint64(len(m.store)) < size {
+		m.store = append(m.store, make([]byte, size-uint64(len(m.store)))...)
+	}
+}
+
+// GetCopy 返回 [offset, offset+size) 的副本
+func (m *Memory) GetCopy(offset, size uint64) []byte {
+	if size == 0 {
+		return nil
+	}
+	cpy := make([]byte, size)
+	copy(cpy, m.store[offset:offset+size])
+	return cpy
+}
+
+// GetPtr 返回 [offset, offset+size) 的切片，不复制
+func (m *Memory) GetPtr(offset, size uint64) []byte {
+	if size == 0 {
+		return nil
+	}
+	return m.store[offset : offset+size]
+}
+
+func (m *Memory) Len() int {
+	return len(m.store)
+}
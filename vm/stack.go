@@ -0,0 +1,47 @@
+package vm
+
+import "github.com/holiman/uint256"
+
+// StackLimit 是栈最多能放的元素个数
+const StackLimit = 1024
+
+// Stack 是 EVM 的操作数栈，每个元素都是 256 位的
+type Stack struct {
+	data []uint256.Int
+}
+
+func newStack() *Stack {
+	return &Stack{data: make([]uint256.Int, 0, 16)}
+}
+
+func (st *Stack) push(d *uint256.Int) {
+	st.data = append(st.data, *d)
+}
+
+func (st *Stack) pop() (ret uint256.Int) {
+	ret = st.data[len(st.data)-1]
+	st.data = st.data[:len(st.data)-1]
+	return
+}
+
+// peek 返回栈顶元素的指针，指令可以直接把结果写回栈顶
+func (st *Stack) peek() *uint256.Int {
+	return &st.data[len(st.data)-1]
+}
+
+// Back 返回从栈顶往下数第 n 个元素，Back(0) 就是栈顶
+func (st *Stack) Back(n int) *uint256.Int {
+	return &st.data[len(st.data)-n-1]
+}
+
+func (st *Stack) swap(n int) {
+	st.data[len(st.data)-n], st.data[len(st.data)-1] = st.data[len(st.data)-1], st.data[len(st.data)-n]
+}
+
+func (st *Stack) dup(n int) {
+	st.push(&st.data[len(st.data)-n])
+}
+
+func (st *Stack) len() int {
+	return len(st.data)
+}
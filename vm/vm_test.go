@@ -0,0 +1,199 @@
+package vm
+
+import (
+	"blockchain/crypto/sha3"
+	"blockchain/statdb"
+	"blockchain/types"
+	"blockchain/utils/hash"
+	"blockchain/utils/hexutil"
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+var (
+	testCaller   = types.Address{0x01}
+	testContract = types.Address{19: 0xaa}
+)
+
+// fixture 是 testdata/fixtures.json 里的一个用例：在 testContract 上执行 code，核对返回值、gas、存储和日志
+type fixture struct {
+	Name    string            `json:"name"`
+	Pre     map[string]string `json:"pre"` //其他合约的地址和代码
+	Code    string            `json:"code"`
+	Input   string            `json:"input"`
+	Gas     uint64            `json:"gas"`
+	Ret     string            `json:"ret"`
+	GasUsed uint64            `json:"gasUsed"`
+	Refund  uint64            `json:"refund"`
+	Error   string            `json:"error"`
+	Storage map[string]string `json:"storage"`
+	Logs    []struct {
+		Topics []string `json:"topics"`
+		Data   string   `json:"data"`
+	} `json:"logs"`
+}
+
+func newTestEVM(t *testing.T) (*EVM, *statdb.Journal) {
+	state := statdb.NewJournal(statdb.NewMemoryStatDB())
+	state.Store(testCaller, types.Account{Amount: *uint256.NewInt(1000000)})
+	return NewEVM(BlockContext{Height: 1}, TxContext{Origin: testCaller, GasPrice: 1}, state), state
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	if s == "" {
+		return nil
+	}
+	b, err := hexutil.Decode(s)
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestFixtures(t *testing.T) {
+	data, err := os.ReadFile("testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	for _, fx := range fixtures {
+		t.Run(fx.Name, func(t *testing.T) {
+			evm, state := newTestEVM(t)
+			for addr, code := range fx.Pre {
+				state.SetCode(types.Address(decodeHex(t, addr)), decodeHex(t, code))
+			}
+			state.SetCode(testContract, decodeHex(t, fx.Code))
+
+			ret, leftOver, err := evm.Call(testCaller, testContract, decodeHex(t, fx.Input), fx.Gas, new(uint256.Int))
+			if (err == nil && fx.Error != "") || (err != nil && err.Error() != fx.Error) {
+				t.Fatalf("error mismatch: have %v, want %q", err, fx.Error)
+			}
+			if want := decodeHex(t, fx.Ret); !bytes.Equal(ret, want) {
+				t.Fatalf("return mismatch: have %x, want %x", ret, want)
+			}
+			if used := fx.Gas - leftOver; used != fx.GasUsed {
+				t.Fatalf("gas used mismatch: have %d, want %d", used, fx.GasUsed)
+			}
+			if evm.Refund() != fx.Refund {
+				t.Fatalf("refund mismatch: have %d, want %d", evm.Refund(), fx.Refund)
+			}
+			for key, want := range fx.Storage {
+				have, _ := state.GetState(testContract, hash.BytesToHash(decodeHex(t, key)))
+				if have != hash.BytesToHash(decodeHex(t, want)) {
+					t.Fatalf("storage %s mismatch: have %x, want %s", key, have, want)
+				}
+			}
+			logs := evm.Logs()
+			if len(logs) != len(fx.Logs) {
+				t.Fatalf("log count mismatch: have %d, want %d", len(logs), len(fx.Logs))
+			}
+			for i, want := range fx.Logs {
+				if logs[i].Address != testContract || !bytes.Equal(logs[i].Data, decodeHex(t, want.Data)) || len(logs[i].Topics) != len(want.Topics) {
+					t.Fatalf("log %d mismatch: %+v", i, logs[i])
+				}
+				for j, topic := range want.Topics {
+					if logs[i].Topics[j] != hash.BytesToHash(decodeHex(t, topic)) {
+						t.Fatalf("log %d topic %d mismatch: have %x, want %s", i, j, logs[i].Topics[j], topic)
+					}
+				}
+			}
+		})
+	}
+}
+
+// 合约用 CREATE 部署一个只有 STOP 的合约，返回新合约的地址
+func TestCreate(t *testing.T) {
+	evm, state := newTestEVM(t)
+	//初始化代码 PUSH1 1 PUSH1 0 RETURN 放在内存的 [27, 32)，然后 CREATE(0, 27, 5)
+	state.SetCode(testContract, decodeHex(t, "0x6460016000f3600052600560"+"1b6000f060005260206000f3"))
+
+	ret, leftOver, err := evm.Call(testCaller, testContract, nil, 100000, new(uint256.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := types.CreateAddress(testContract, 1)
+	if !bytes.Equal(ret[12:], want[:]) {
+		t.Fatalf("created address mismatch: have %x, want %x", ret[12:], want)
+	}
+	// CREATE 32000，初始化代码 9，保存 1 字节代码 200，其余指令 33
+	if used := 100000 - leftOver; used != 32242 {
+		t.Fatalf("gas used mismatch: have %d, want %d", used, 32242)
+	}
+	if code, _ := state.GetCode(want); !bytes.Equal(code, []byte{0x00}) {
+		t.Fatalf("code mismatch: have %x", code)
+	}
+	if account, _ := state.Load(testContract); account.Nonce != 1 {
+		t.Fatalf("creator nonce mismatch: have %d, want 1", account.Nonce)
+	}
+}
+
+// CREATE2 的地址由 salt 和初始化代码决定，同一个 salt 第二次部署时地址冲突
+func TestCreate2(t *testing.T) {
+	evm, state := newTestEVM(t)
+	//和 TestCreate 一样的初始化代码，CREATE2(0, 27, 5, 42)
+	state.SetCode(testContract, decodeHex(t, "0x6460016000f3600052"+"602a60056"+"01b6000f5"+"60005260206000f3"))
+
+	ret, leftOver, err := evm.Call(testCaller, testContract, nil, 100000, new(uint256.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	initHash := sha3.Keccak256(decodeHex(t, "0x60016000f3"))
+	want := types.CreateAddress2(testContract, hash.BytesToHash([]byte{42}), initHash[:])
+	if !bytes.Equal(ret[12:], want[:]) {
+		t.Fatalf("created address mismatch: have %x, want %x", ret[12:], want)
+	}
+	// 比 TestCreate 多一个 PUSH1 和初始化代码一个字的hash
+	if used := 100000 - leftOver; used != 32242+3+6 {
+		t.Fatalf("gas used mismatch: have %d, want %d", used, 32242+3+6)
+	}
+	if code, _ := state.GetCode(want); !bytes.Equal(code, []byte{0x00}) {
+		t.Fatalf("code mismatch: have %x", code)
+	}
+
+	ret, _, err = evm.Call(testCaller, testContract, nil, 100000, new(uint256.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret, make([]byte, 32)) {
+		t.Fatalf("second deployment should fail, have %x", ret)
+	}
+}
+
+// 被调用的合约 REVERT 时只回滚它自己的修改，调用方继续执行
+func TestCallRevertIsolated(t *testing.T) {
+	evm, state := newTestEVM(t)
+	callee := types.Address{19: 0xbb}
+	state.SetCode(callee, decodeHex(t, "0x600160005560006000fd")) //SSTORE(0, 1) 然后 REVERT
+	//SSTORE(0, 2)，调用 callee，把 CALL 的结果存到 slot 1
+	state.SetCode(testContract, decodeHex(t, "0x6002600055"+"60006000600060006000"+"73"+"00000000000000000000000000000000000000bb"+"61fffff1"+"60015500"))
+
+	if _, _, err := evm.Call(testCaller, testContract, nil, 100000, new(uint256.Int)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := state.GetState(callee, hash.Hash{}); v != (hash.Hash{}) {
+		t.Fatalf("reverted storage kept: %x", v)
+	}
+	if v, _ := state.GetState(testContract, hash.Hash{}); v != hash.BytesToHash([]byte{2}) {
+		t.Fatalf("caller storage mismatch: %x", v)
+	}
+	if v, _ := state.GetState(testContract, hash.BytesToHash([]byte{1})); v != (hash.Hash{}) {
+		t.Fatalf("call should have failed, result %x", v)
+	}
+}
+
+// 调用深度超过 CallCreateDepth 时调用失败，gas 不被消耗
+func TestCallDepth(t *testing.T) {
+	evm, state := newTestEVM(t)
+	state.SetCode(testContract, decodeHex(t, "0x00"))
+	evm.depth = CallCreateDepth + 1
+	if _, leftOver, err := evm.Call(testCaller, testContract, nil, 100, new(uint256.Int)); err != ErrDepth || leftOver != 100 {
+		t.Fatalf("have %v with %d gas left, want %v", err, leftOver, ErrDepth)
+	}
+}